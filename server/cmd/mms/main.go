@@ -2,19 +2,31 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/marks-music-solutions/mms/internal/api"
+	"github.com/marks-music-solutions/mms/internal/artwork"
+	"github.com/marks-music-solutions/mms/internal/backup"
 	"github.com/marks-music-solutions/mms/internal/config"
 	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/marks-music-solutions/mms/internal/duplicates"
+	"github.com/marks-music-solutions/mms/internal/inbox"
+	"github.com/marks-music-solutions/mms/internal/integrity"
+	"github.com/marks-music-solutions/mms/internal/organizer"
 	"github.com/marks-music-solutions/mms/internal/scanner"
+	"github.com/marks-music-solutions/mms/internal/search"
+	"github.com/marks-music-solutions/mms/internal/smartplaylist"
 	"github.com/marks-music-solutions/mms/internal/stream"
+	"github.com/marks-music-solutions/mms/internal/trash"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -27,6 +39,8 @@ func main() {
 	// Parse flags
 	configPath := flag.String("config", "", "path to config.yaml")
 	scanOnStart := flag.Bool("scan", false, "scan music library on startup")
+	migrationStatus := flag.Bool("migrations", false, "print database migration status and exit")
+	restoreArchive := flag.String("restore", "", "restore a backup archive and exit (stop the server first)")
 	flag.Parse()
 
 	// Load configuration
@@ -38,7 +52,7 @@ func main() {
 	log.Info().
 		Str("addr", cfg.Addr()).
 		Strs("music_dirs", cfg.Music.Directories).
-		Str("db", cfg.Database.Path).
+		Str("db_driver", cfg.Database.Driver).
 		Msg("MMS starting")
 
 	// Ensure data directories exist
@@ -46,20 +60,63 @@ func main() {
 	os.MkdirAll(cfg.Transcode.CacheDir, 0755)
 	os.MkdirAll("data/artwork", 0755)
 
+	// Restore from backup before anything opens the database
+	if *restoreArchive != "" {
+		if cfg.Database.Driver != db.DriverSQLite {
+			log.Fatal().Err(backup.ErrUnsupported).Msg("restore failed")
+		}
+		if err := backup.Restore(*restoreArchive, cfg.Database.Path, "data/artwork"); err != nil {
+			log.Fatal().Err(err).Msg("restore failed")
+		}
+		log.Info().Str("archive", *restoreArchive).Msg("restore complete")
+		return
+	}
+
 	// Connect to database
-	database, err := db.Open(cfg.Database.Path)
+	var database *sql.DB
+	if cfg.Database.Driver == db.DriverPostgres {
+		database, err = db.OpenPostgres(cfg.Database.DSN, cfg.Database.ReadConnections)
+	} else {
+		database, err = db.Open(cfg.Database.Path)
+	}
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to open database")
 	}
 	defer database.Close()
 
-	// Run migrations
-	if err := db.Migrate(database); err != nil {
+	if *migrationStatus {
+		if err := printMigrationStatus(database, cfg.Database.Driver); err != nil {
+			log.Fatal().Err(err).Msg("failed to read migration status")
+		}
+		return
+	}
+
+	// Run migrations (refuses a database migrated by a newer release)
+	if err := db.Migrate(database, cfg.Database.Driver); err != nil {
 		log.Fatal().Err(err).Msg("failed to run migrations")
 	}
 
 	// Create repository
-	repo := db.NewRepository(database)
+	var repo db.Store
+	var readPool *sql.DB
+	if cfg.Database.Driver == db.DriverPostgres {
+		repo = db.NewPostgresRepository(database)
+	} else {
+		// Open the read pool once the schema exists
+		readPool, err = db.OpenReader(cfg.Database.Path, cfg.Database.ReadConnections)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to open database read pool")
+		}
+		defer readPool.Close()
+		repo = db.NewRepository(database, readPool)
+	}
+
+	// Give playlists from before revision history a revision to go back to
+	if n, err := repo.RecordBaselineRevisions(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("failed to record playlist revisions")
+	} else if n > 0 {
+		log.Info().Int("playlists", n).Msg("recorded baseline playlist revisions")
+	}
 
 	// Create scanner
 	sc := scanner.NewScanner(repo, cfg.Music.Directories, "data/artwork")
@@ -67,8 +124,53 @@ func main() {
 	// Create streamer
 	st := stream.NewStreamer(cfg.Transcode.CacheDir, cfg.Transcode.FFmpegPath)
 
+	// Create library organizer
+	org, err := organizer.NewOrganizer(repo, cfg.Music.Directories, cfg.Organizer.Template, cfg.Organizer.OnConflict,
+		sc.LibraryLock())
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid organizer configuration")
+	}
+
+	// Create integrity verifier
+	verifier := integrity.NewVerifier(repo, cfg.Integrity.Interval)
+
+	// Create duplicate finder
+	finder := duplicates.NewFinder(repo, cfg.Duplicates.FpcalcPath)
+
+	// Create backup manager (snapshots are taken from the read pool; SQLite only)
+	backups := backup.NewManager(readPool, cfg.Backup.Directory, "data/artwork",
+		cfg.Backup.Keep, cfg.Backup.IncludeArtwork, cfg.Backup.Interval)
+
+	// Spelling correction for search, reloaded after every scan
+	speller := search.NewSpeller(repo.SearchTerms)
+	sc.OnComplete(speller.Invalidate)
+
+	// Search-as-you-type completions, built now and rebuilt after every scan
+	suggester := search.NewSuggester(repo.SearchSuggestions)
+	go suggester.Refresh()
+	sc.OnComplete(suggester.Refresh)
+
 	// Create handlers and router
-	handlers := api.NewHandlers(repo, sc, st)
+	weights := search.Weights{
+		Relevance:       cfg.Search.RelevanceWeight,
+		Plays:           cfg.Search.PlayWeight,
+		Rating:          cfg.Search.RatingWeight,
+		Recency:         cfg.Search.RecencyWeight,
+		Artist:          cfg.Search.ArtistWeight,
+		RecencyHalfLife: cfg.Search.RecencyHalfLife,
+	}
+	// Resized artwork, and playlist covers redrawn as entries change or a
+	// scan finds new album art
+	art := artwork.NewCache(cfg.Artwork.CacheDir)
+	covers := artwork.NewPlaylistCovers(repo, "data/artwork/playlists")
+	repo.OnPlaylistChange(covers.Queue)
+	sc.OnComplete(covers.QueueAll)
+
+	// Deleted playlists are kept for restoring until their retention runs out
+	purger := trash.NewPurger(repo, cfg.Playlists.DeletedRetention)
+
+	handlers := api.NewHandlers(repo, sc, st, org, verifier, finder, backups, speller, suggester, weights,
+		art, covers, purger)
 	router := api.NewRouter(handlers)
 
 	// Scan on startup if requested
@@ -96,6 +198,28 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// Periodically re-verify audio integrity
+	go verifier.Run(ctx)
+
+	// Scheduled backups
+	go backups.Run(ctx)
+
+	// Draw playlist covers
+	go covers.Run(ctx)
+
+	// Refresh scheduled smart playlists
+	go smartplaylist.NewRefresher(repo).Run(ctx)
+
+	// Purge expired deleted playlists
+	go purger.Run(ctx)
+
+	// Watch the import inbox
+	if cfg.Inbox.Directory != "" {
+		inb := inbox.NewInbox(repo, sc, cfg.Inbox.Directory, cfg.Inbox.QuarantineDir,
+			cfg.Inbox.Library, org.Template(), cfg.Inbox.PollInterval)
+		go inb.Run(ctx)
+	}
+
 	go func() {
 		log.Info().Str("addr", cfg.Addr()).Msg("server listening")
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -115,3 +239,25 @@ func main() {
 
 	log.Info().Msg("server stopped")
 }
+
+// printMigrationStatus writes one line per schema migration to stdout.
+func printMigrationStatus(database *sql.DB, driver string) error {
+	states, err := db.MigrationStatus(database, driver)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+	for _, st := range states {
+		status := "pending"
+		switch {
+		case !st.Known:
+			status = "applied by newer release (" + st.AppliedAt.Format(time.DateTime) + ")"
+		case st.AppliedAt != nil:
+			status = "applied " + st.AppliedAt.Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", st.Version, st.Name, status)
+	}
+	fmt.Fprintf(w, "\nbinary schema version: %d\n", db.LatestVersion())
+	return w.Flush()
+}
@@ -1,30 +1,74 @@
 package api
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/marks-music-solutions/mms/internal/artwork"
+	"github.com/marks-music-solutions/mms/internal/backup"
 	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/marks-music-solutions/mms/internal/duplicates"
+	"github.com/marks-music-solutions/mms/internal/integrity"
+	"github.com/marks-music-solutions/mms/internal/organizer"
+	"github.com/marks-music-solutions/mms/internal/playlistfile"
 	"github.com/marks-music-solutions/mms/internal/scanner"
+	"github.com/marks-music-solutions/mms/internal/search"
 	"github.com/marks-music-solutions/mms/internal/stream"
+	"github.com/marks-music-solutions/mms/internal/transfer"
+	"github.com/marks-music-solutions/mms/internal/trash"
 	"github.com/rs/zerolog/log"
 )
 
 // Handlers holds all HTTP handler dependencies.
 type Handlers struct {
-	repo     *db.Repository
-	scanner  *scanner.Scanner
-	streamer *stream.Streamer
+	repo      db.Store
+	scanner   *scanner.Scanner
+	streamer  *stream.Streamer
+	organizer *organizer.Organizer
+	verifier  *integrity.Verifier
+	finder    *duplicates.Finder
+	backups   *backup.Manager
+	speller   *search.Speller
+	suggester *search.Suggester
+	weights   search.Weights
+	artwork   *artwork.Cache
+	covers    *artwork.PlaylistCovers
+	purger    *trash.Purger
 }
 
 // NewHandlers creates a new Handlers instance.
-func NewHandlers(repo *db.Repository, sc *scanner.Scanner, st *stream.Streamer) *Handlers {
+func NewHandlers(repo db.Store, sc *scanner.Scanner, st *stream.Streamer, org *organizer.Organizer,
+	ver *integrity.Verifier, finder *duplicates.Finder, backups *backup.Manager,
+	speller *search.Speller, suggester *search.Suggester, weights search.Weights,
+	art *artwork.Cache, covers *artwork.PlaylistCovers, purger *trash.Purger) *Handlers {
 	return &Handlers{
-		repo:     repo,
-		scanner:  sc,
-		streamer: st,
+		repo:      repo,
+		scanner:   sc,
+		streamer:  st,
+		organizer: org,
+		verifier:  ver,
+		finder:    finder,
+		backups:   backups,
+		speller:   speller,
+		suggester: suggester,
+		weights:   weights,
+		artwork:   art,
+		covers:    covers,
+		purger:    purger,
 	}
 }
 
@@ -40,16 +84,21 @@ func (h *Handlers) HandleHealth(w http.ResponseWriter, r *http.Request) {
 // --- Artists ---
 
 func (h *Handlers) HandleListArtists(w http.ResponseWriter, r *http.Request) {
-	limit, offset := parsePagination(r)
-	artists, total, err := h.repo.ListArtists(r.Context(), limit, offset)
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	artists, page, err := h.repo.ListArtists(r.Context(), opts)
+	if errors.Is(err, db.ErrInvalidListOption) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to list artists")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{
-		"items": artists,
-		"total": total,
-	})
+	writeJSON(w, http.StatusOK, listResponse(artists, page, opts))
 }
 
 func (h *Handlers) HandleGetArtist(w http.ResponseWriter, r *http.Request) {
@@ -78,16 +127,21 @@ func (h *Handlers) HandleGetArtistAlbums(w http.ResponseWriter, r *http.Request)
 // --- Albums ---
 
 func (h *Handlers) HandleListAlbums(w http.ResponseWriter, r *http.Request) {
-	limit, offset := parsePagination(r)
-	albums, total, err := h.repo.ListAlbums(r.Context(), limit, offset)
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	albums, page, err := h.repo.ListAlbums(r.Context(), opts)
+	if errors.Is(err, db.ErrInvalidListOption) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to list albums")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{
-		"items": albums,
-		"total": total,
-	})
+	writeJSON(w, http.StatusOK, listResponse(albums, page, opts))
 }
 
 func (h *Handlers) HandleGetAlbum(w http.ResponseWriter, r *http.Request) {
@@ -99,7 +153,7 @@ func (h *Handlers) HandleGetAlbum(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Also fetch tracks for the album
-	tracks, err := h.repo.ListTracksByAlbum(r.Context(), id)
+	tracks, err := h.repo.ListTracksByAlbum(r.Context(), id, parseBoolParam(r, "hide_duplicates"))
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to list tracks")
 		return
@@ -113,7 +167,7 @@ func (h *Handlers) HandleGetAlbum(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handlers) HandleGetAlbumTracks(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	tracks, err := h.repo.ListTracksByAlbum(r.Context(), id)
+	tracks, err := h.repo.ListTracksByAlbum(r.Context(), id, parseBoolParam(r, "hide_duplicates"))
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to list tracks")
 		return
@@ -126,7 +180,7 @@ func (h *Handlers) HandleGetAlbumTracks(w http.ResponseWriter, r *http.Request)
 
 func (h *Handlers) HandleRecentAlbums(w http.ResponseWriter, r *http.Request) {
 	limit := parseIntParam(r, "limit", 20)
-	albums, err := h.repo.RecentAlbums(r.Context(), limit)
+	albums, err := h.repo.RecentAlbums(r.Context(), limit, parseBoolParam(r, "hide_duplicates"))
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to get recent albums")
 		return
@@ -139,7 +193,7 @@ func (h *Handlers) HandleRecentAlbums(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handlers) HandleRandomAlbums(w http.ResponseWriter, r *http.Request) {
 	limit := parseIntParam(r, "limit", 20)
-	albums, err := h.repo.RandomAlbums(r.Context(), limit)
+	albums, err := h.repo.RandomAlbums(r.Context(), limit, parseBoolParam(r, "hide_duplicates"))
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to get random albums")
 		return
@@ -152,6 +206,24 @@ func (h *Handlers) HandleRandomAlbums(w http.ResponseWriter, r *http.Request) {
 
 // --- Tracks ---
 
+func (h *Handlers) HandleListTracks(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tracks, page, err := h.repo.ListTracks(r.Context(), opts)
+	if errors.Is(err, db.ErrInvalidListOption) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list tracks")
+		return
+	}
+	writeJSON(w, http.StatusOK, listResponse(tracks, page, opts))
+}
+
 func (h *Handlers) HandleGetTrack(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	track, err := h.repo.GetTrackByID(r.Context(), id)
@@ -181,7 +253,30 @@ func (h *Handlers) HandleArtwork(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusNotFound, "artwork not found")
 		return
 	}
-	http.ServeFile(w, r, *album.CoverPath)
+	h.serveArtwork(w, r, *album.CoverPath)
+}
+
+// serveArtwork serves a cover image, scaled down to fit the size query
+// parameter when one is given.
+func (h *Handlers) serveArtwork(w http.ResponseWriter, r *http.Request, path string) {
+	if s := r.URL.Query().Get("size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err == nil {
+			n, err = artwork.Size(n)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "size must be a positive number of pixels")
+			return
+		}
+		resized, err := h.artwork.Resized(path, n)
+		if err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("artwork resize failed")
+			writeError(w, http.StatusNotFound, "artwork not found")
+			return
+		}
+		path = resized
+	}
+	http.ServeFile(w, r, path)
 }
 
 // --- Search ---
@@ -193,14 +288,44 @@ func (h *Handlers) HandleSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	q, err := search.PrepareQuery(query)
+	var perr *search.ParseError
+	if errors.As(err, &perr) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"error":    perr.Error(),
+			"position": perr.Pos,
+		})
+		return
+	}
+
+	// Fetch more text matches than asked for so listening history can
+	// promote a weaker match into the page
 	limit := parseIntParam(r, "limit", 30)
-	results, err := h.repo.Search(r.Context(), query, limit)
+	if limit <= 0 || limit > 100 {
+		limit = 30
+	}
+	candidates := min(limit*searchCandidates, 100)
+	results, err := h.repo.Search(r.Context(), q, candidates)
 	if err != nil {
 		log.Error().Err(err).Str("query", query).Msg("search failed")
 		writeError(w, http.StatusInternalServerError, "search failed")
 		return
 	}
 
+	// Search again with misspelled words corrected and rank those hits
+	// below exact ones
+	var didYouMean any
+	if corrected, ok := h.speller.Correct(r.Context(), q); ok {
+		fuzzy, err := h.repo.Search(r.Context(), corrected, candidates)
+		if err != nil {
+			log.Error().Err(err).Str("query", corrected.String()).Msg("fuzzy search failed")
+		} else if len(fuzzy) > 0 {
+			didYouMean = corrected.String()
+			results = mergeFuzzy(results, fuzzy)
+		}
+	}
+	results = rankResults(results, h.weights, limit)
+
 	// Group results by type
 	var artists, albums, tracks []*db.SearchResult
 	for _, sr := range results {
@@ -215,45 +340,377 @@ func (h *Handlers) HandleSearch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	writeJSON(w, http.StatusOK, map[string]any{
-		"artists": artists,
-		"albums":  albums,
-		"tracks":  tracks,
-		"total":   len(results),
+		"artists":      artists,
+		"albums":       albums,
+		"tracks":       tracks,
+		"total":        len(results),
+		"did_you_mean": didYouMean,
+	})
+}
+
+// HandleSearchSuggest returns completions for a partly typed query from the
+// in-memory prefix index, for search-as-you-type.
+func (h *Handlers) HandleSearchSuggest(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "query parameter 'q' is required")
+		return
+	}
+
+	suggestions, err := h.suggester.Suggest(r.Context(), query, parseIntParam(r, "limit", 8))
+	if err != nil {
+		log.Error().Err(err).Str("query", query).Msg("search suggest failed")
+		writeError(w, http.StatusInternalServerError, "search suggest failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"suggestions": suggestions})
+}
+
+// fuzzyPenalty scales the rank of hits found only through spelling
+// correction. Ranks are negative with lower being better, so this moves them
+// toward the end.
+const fuzzyPenalty = 0.5
+
+// searchCandidates is how many text matches per requested result are
+// re-ranked.
+const searchCandidates = 3
+
+// mergeFuzzy adds corrected-spelling hits to exact ones.
+func mergeFuzzy(exact, fuzzy []*db.SearchResult) []*db.SearchResult {
+	seen := make(map[string]bool, len(exact))
+	for _, sr := range exact {
+		seen[sr.EntityType+":"+sr.EntityID] = true
+	}
+	merged := slices.Clone(exact)
+	for _, sr := range fuzzy {
+		if seen[sr.EntityType+":"+sr.EntityID] {
+			continue
+		}
+		sr.Rank *= fuzzyPenalty
+		merged = append(merged, sr)
+	}
+	return merged
+}
+
+// rankResults replaces text ranks with ones blended from listening history
+// and returns the best limit results, best first.
+func rankResults(results []*db.SearchResult, w search.Weights, limit int) []*db.SearchResult {
+	sigs := make([]search.Signals, len(results))
+	for i, sr := range results {
+		sigs[i] = search.Signals{Rank: sr.Rank, Plays: sr.Plays, ArtistTracks: sr.ArtistTracks}
+		if sr.Rating != nil {
+			sigs[i].Rating = *sr.Rating
+		}
+		if sr.LastPlayed != nil {
+			sigs[i].LastPlayed = *sr.LastPlayed
+		}
+	}
+	for i, rank := range w.Blend(sigs, time.Now()) {
+		results[i].Rank = rank
+	}
+	slices.SortStableFunc(results, func(a, b *db.SearchResult) int {
+		return cmp.Compare(a.Rank, b.Rank)
 	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
 }
 
 // --- Library Management ---
 
 func (h *Handlers) HandleScanLibrary(w http.ResponseWriter, r *http.Request) {
+	if err := h.scanner.StartScanAll(); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"status":  "scanning",
+		"message": "Library scan started in background",
+	})
+}
+
+func (h *Handlers) HandleOrganizePreview(w http.ResponseWriter, r *http.Request) {
+	moves, err := h.organizer.Plan(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("organize plan failed")
+		writeError(w, http.StatusInternalServerError, "failed to plan library organize")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"template": h.organizer.Template().String(),
+		"items":    moves,
+		"total":    len(moves),
+	})
+}
+
+func (h *Handlers) HandleOrganizeLibrary(w http.ResponseWriter, r *http.Request) {
+	if err := h.organizer.Start(); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"status":  "organizing",
+		"message": "Library organize started in background",
+	})
+}
+
+func (h *Handlers) HandleOrganizeStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"running": h.organizer.IsRunning(),
+		"last":    h.organizer.LastResult(),
+	})
+}
+
+func (h *Handlers) HandleListImports(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+	status := r.URL.Query().Get("status")
+	records, total, err := h.repo.ListImports(r.Context(), status, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list imports")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items": records,
+		"total": total,
+	})
+}
+
+func (h *Handlers) HandleListIntegrity(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+	status := r.URL.Query().Get("status")
+	results, total, err := h.repo.ListIntegrityResults(r.Context(), status, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list integrity results")
+		return
+	}
+	summary, err := h.repo.CountIntegrityStatuses(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to summarize integrity results")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items":   results,
+		"total":   total,
+		"summary": summary,
+		"running": h.verifier.IsRunning(),
+	})
+}
+
+func (h *Handlers) HandleVerifyIntegrity(w http.ResponseWriter, r *http.Request) {
+	if h.verifier.IsRunning() {
+		writeError(w, http.StatusConflict, "integrity verification already running")
+		return
+	}
+	// Re-verify everything, not just tracks that are due
+	before := time.Now()
+	go func() {
+		if err := h.verifier.VerifyDue(context.Background(), before); err != nil {
+			log.Error().Err(err).Msg("integrity verification failed")
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"status":  "verifying",
+		"message": "Integrity verification started in background",
+	})
+}
+
+func (h *Handlers) HandleLibraryHealth(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+	issueType := r.URL.Query().Get("type")
+	if issueType != "" && !slices.Contains(db.HealthIssueTypes, issueType) {
+		writeError(w, http.StatusBadRequest, "unknown issue type")
+		return
+	}
+	issues, total, err := h.repo.ListHealthIssues(r.Context(), issueType, r.URL.Query().Get("artist_id"), limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to build health report")
+		return
+	}
+	summary, err := h.repo.CountHealthIssues(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to summarize health report")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items":   issues,
+		"total":   total,
+		"summary": summary,
+	})
+}
+
+func (h *Handlers) HandleListDuplicates(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = "track"
+	}
+	if kind != "track" && kind != "album" {
+		writeError(w, http.StatusBadRequest, "kind must be 'track' or 'album'")
+		return
+	}
+	groups, total, err := h.repo.ListDuplicateGroups(r.Context(), kind, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list duplicates")
+		return
+	}
+	resp := map[string]any{
+		"items":   groups,
+		"total":   total,
+		"running": h.finder.IsRunning(),
+	}
+	if last := h.finder.LastRun(); !last.IsZero() {
+		resp["last_run"] = last
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handlers) HandleScanDuplicates(w http.ResponseWriter, r *http.Request) {
+	if h.finder.IsRunning() {
+		writeError(w, http.StatusConflict, "duplicate scan already running")
+		return
+	}
 	go func() {
-		if err := h.scanner.ScanAll(); err != nil {
-			log.Error().Err(err).Msg("library scan failed")
+		if err := h.finder.Run(context.Background()); err != nil {
+			log.Error().Err(err).Msg("duplicate scan failed")
 		}
 	}()
 	writeJSON(w, http.StatusAccepted, map[string]string{
 		"status":  "scanning",
-		"message": "Library scan started in background",
+		"message": "Duplicate scan started in background",
+	})
+}
+
+// --- Admin ---
+
+func (h *Handlers) HandleListBackups(w http.ResponseWriter, r *http.Request) {
+	backups, err := h.backups.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list backups")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items":   backups,
+		"total":   len(backups),
+		"running": h.backups.IsRunning(),
+	})
+}
+
+func (h *Handlers) HandleCreateBackup(w http.ResponseWriter, r *http.Request) {
+	if h.backups.IsRunning() {
+		writeError(w, http.StatusConflict, "backup already running")
+		return
+	}
+	go func() {
+		if _, err := h.backups.Create(context.Background()); err != nil {
+			log.Error().Err(err).Msg("backup failed")
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"status":  "backing_up",
+		"message": "Backup started in background",
 	})
 }
 
+// HandleCheckSearchIndex compares the search index with the catalog.
+func (h *Handlers) HandleCheckSearchIndex(w http.ResponseWriter, r *http.Request) {
+	report, err := h.repo.CheckSearchIndex(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("search index check failed")
+		writeError(w, http.StatusInternalServerError, "failed to check search index")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// HandleRebuildSearchIndex repopulates the search index from the catalog and
+// returns the check afterwards.
+func (h *Handlers) HandleRebuildSearchIndex(w http.ResponseWriter, r *http.Request) {
+	if err := h.repo.RebuildSearchIndex(r.Context()); err != nil {
+		log.Error().Err(err).Msg("search index rebuild failed")
+		writeError(w, http.StatusInternalServerError, "failed to rebuild search index")
+		return
+	}
+	h.speller.Invalidate()
+
+	report, err := h.repo.CheckSearchIndex(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("search index check failed")
+		writeError(w, http.StatusInternalServerError, "failed to check search index")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (h *Handlers) HandleExportLibrary(w http.ResponseWriter, r *http.Request) {
+	doc, err := transfer.Export(r.Context(), h.repo)
+	if err != nil {
+		log.Error().Err(err).Msg("library export failed")
+		writeError(w, http.StatusInternalServerError, "failed to export library")
+		return
+	}
+	name := "mms-library-" + doc.ExportedAt.Format("20060102") + ".json"
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	writeJSON(w, http.StatusOK, doc)
+}
+
+func (h *Handlers) HandleImportLibrary(w http.ResponseWriter, r *http.Request) {
+	var doc transfer.Document
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	report, err := transfer.Import(r.Context(), h.repo, &doc)
+	if errors.Is(err, transfer.ErrInvalidDocument) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("library import failed")
+		writeError(w, http.StatusInternalServerError, "failed to import library")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
 // --- Playlists ---
 
+// HandleListPlaylists lists playlists, most recently updated first, or with
+// view=tree as pinned playlists and the folder tree.
 func (h *Handlers) HandleListPlaylists(w http.ResponseWriter, r *http.Request) {
 	playlists, err := h.repo.ListPlaylists(r.Context())
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to list playlists")
 		return
 	}
+	switch r.URL.Query().Get("view") {
+	case "", "flat":
+	case "tree":
+		folders, err := h.repo.ListPlaylistFolders(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to list playlist folders")
+			return
+		}
+		writeJSON(w, http.StatusOK, db.BuildPlaylistTree(folders, playlists))
+		return
+	default:
+		writeError(w, http.StatusBadRequest, "view must be flat or tree")
+		return
+	}
 	writeJSON(w, http.StatusOK, map[string]any{
 		"items": playlists,
 		"total": len(playlists),
 	})
 }
 
+// HandleCreatePlaylist creates a playlist, or a smart playlist when the body
+// has rules, at the end of the top level or of folder_id.
 func (h *Handlers) HandleCreatePlaylist(w http.ResponseWriter, r *http.Request) {
 	var body struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
+		Name        string         `json:"name"`
+		Description string         `json:"description"`
+		Rules       *db.SmartRules `json:"rules"`
+		FolderID    *string        `json:"folder_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid request body")
@@ -263,15 +720,32 @@ func (h *Handlers) HandleCreatePlaylist(w http.ResponseWriter, r *http.Request)
 		writeError(w, http.StatusBadRequest, "name is required")
 		return
 	}
-
-	playlist, err := h.repo.CreatePlaylist(r.Context(), body.Name, body.Description)
+	var playlist *db.Playlist
+	var err error
+	if body.Rules != nil {
+		playlist, err = h.repo.CreateSmartPlaylist(r.Context(), body.Name, body.Description, body.Rules, body.FolderID)
+	} else {
+		playlist, err = h.repo.CreatePlaylist(r.Context(), body.Name, body.Description, body.FolderID)
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "folder not found")
+		return
+	}
+	if errors.Is(err, db.ErrInvalidRules) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
+		log.Error().Err(err).Msg("create playlist failed")
 		writeError(w, http.StatusInternalServerError, "failed to create playlist")
 		return
 	}
 	writeJSON(w, http.StatusCreated, playlist)
 }
 
+// HandleGetPlaylist returns a playlist with a page of its tracks
+// (limit/offset, in playlist order). Live smart playlists are re-evaluated
+// when the first page is read, so later pages continue the same listing.
 func (h *Handlers) HandleGetPlaylist(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	playlist, err := h.repo.GetPlaylistByID(r.Context(), id)
@@ -279,64 +753,767 @@ func (h *Handlers) HandleGetPlaylist(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusNotFound, "playlist not found")
 		return
 	}
-	writeJSON(w, http.StatusOK, playlist)
-}
 
-func (h *Handlers) HandleDeletePlaylist(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	if err := h.repo.DeletePlaylist(r.Context(), id); err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to delete playlist")
+	limit := parseIntParam(r, "limit", 100)
+	if limit <= 0 || limit > maxPlaylistPage {
+		limit = 100
+	}
+	offset := max(parseIntParam(r, "offset", 0), 0)
+	if offset == 0 {
+		playlist = h.refreshLive(r.Context(), playlist)
+	}
+	tracks, err := h.repo.ListPlaylistTracks(r.Context(), id, limit, offset)
+	if err != nil {
+		log.Error().Err(err).Str("playlist", id).Msg("list playlist tracks failed")
+		writeError(w, http.StatusInternalServerError, "failed to list playlist tracks")
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
+	writeJSON(w, http.StatusOK, struct {
+		*db.Playlist
+		Tracks []*db.PlaylistTrack `json:"tracks"`
+		Limit  int                 `json:"limit"`
+		Offset int                 `json:"offset"`
+	}{playlist, tracks, limit, offset})
 }
 
-func (h *Handlers) HandleAddTrackToPlaylist(w http.ResponseWriter, r *http.Request) {
-	playlistID := chi.URLParam(r, "id")
-	var body struct {
-		TrackID string `json:"track_id"`
+// maxPlaylistPage is the most playlist entries returned per request.
+const maxPlaylistPage = 500
+
+// refreshLive re-evaluates a live smart playlist's rules. On failure the
+// entries from the last refresh are served.
+func (h *Handlers) refreshLive(ctx context.Context, p *db.Playlist) *db.Playlist {
+	if p.Rules == nil || !p.Rules.Live() {
+		return p
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid request body")
+	refreshed, err := h.repo.RefreshSmartPlaylist(ctx, p.ID)
+	if err != nil {
+		log.Warn().Err(err).Str("playlist", p.ID).Msg("smart playlist refresh failed")
+		return p
+	}
+	return refreshed
+}
+
+// HandleRefreshPlaylist re-evaluates a smart playlist's rules now.
+func (h *Handlers) HandleRefreshPlaylist(w http.ResponseWriter, r *http.Request) {
+	playlist, err := h.repo.RefreshSmartPlaylist(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "playlist not found")
 		return
 	}
-	if body.TrackID == "" {
-		writeError(w, http.StatusBadRequest, "track_id is required")
+	if errors.Is(err, db.ErrNotSmartPlaylist) {
+		writeError(w, http.StatusConflict, "only smart playlists can be refreshed")
 		return
 	}
-
-	if err := h.repo.AddTrackToPlaylist(r.Context(), playlistID, body.TrackID); err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to add track")
+	if err != nil {
+		log.Error().Err(err).Msg("smart playlist refresh failed")
+		writeError(w, http.StatusInternalServerError, "failed to refresh playlist")
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
+	writeJSON(w, http.StatusOK, playlist)
 }
 
-// --- Play History ---
-
-func (h *Handlers) HandleRecordPlay(w http.ResponseWriter, r *http.Request) {
-	trackID := chi.URLParam(r, "id")
-	var body struct {
-		Duration *float64 `json:"duration"`
+// HandleDeletePlaylist moves a playlist to the deleted list, from where it
+// can be restored until purged. permanent=true purges it right away,
+// whether or not it was deleted before.
+func (h *Handlers) HandleDeletePlaylist(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var err error
+	if parseBoolParam(r, "permanent") {
+		err = h.repo.PurgePlaylist(r.Context(), id)
+	} else {
+		err = h.repo.DeletePlaylist(r.Context(), id)
 	}
-	json.NewDecoder(r.Body).Decode(&body)
-
-	if err := h.repo.RecordPlay(r.Context(), trackID, body.Duration); err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to record play")
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "playlist not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("playlist", id).Msg("delete playlist failed")
+		writeError(w, http.StatusInternalServerError, "failed to delete playlist")
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// --- Stats ---
-
-func (h *Handlers) HandleStats(w http.ResponseWriter, r *http.Request) {
-	// Quick library stats
-	ctx := r.Context()
-	var artistCount, albumCount, trackCount int64
-	h.repo.CountEntities(ctx, &artistCount, &albumCount, &trackCount)
-
-	writeJSON(w, http.StatusOK, map[string]any{
+// HandleListDeletedPlaylists lists deleted playlists, most recently deleted
+// first, with when each will be purged.
+func (h *Handlers) HandleListDeletedPlaylists(w http.ResponseWriter, r *http.Request) {
+	playlists, err := h.repo.ListDeletedPlaylists(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list deleted playlists")
+		return
+	}
+	type deletedPlaylist struct {
+		*db.Playlist
+		PurgeAt *time.Time `json:"purge_at"`
+	}
+	items := make([]deletedPlaylist, len(playlists))
+	for i, p := range playlists {
+		items[i] = deletedPlaylist{Playlist: p}
+		if p.DeletedAt != nil {
+			items[i].PurgeAt = h.purger.PurgeAt(*p.DeletedAt)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items": items,
+		"total": len(items),
+	})
+}
+
+// HandleListPlaylistRevisions lists a playlist's revisions, newest first,
+// without their snapshots.
+func (h *Handlers) HandleListPlaylistRevisions(w http.ResponseWriter, r *http.Request) {
+	revisions, err := h.repo.ListPlaylistRevisions(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "playlist not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list playlist revisions")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items": revisions,
+		"total": len(revisions),
+	})
+}
+
+// HandleGetPlaylistRevision returns one revision with its snapshot.
+func (h *Handlers) HandleGetPlaylistRevision(w http.ResponseWriter, r *http.Request) {
+	rev, err := strconv.Atoi(chi.URLParam(r, "rev"))
+	if err != nil || rev < 1 {
+		writeError(w, http.StatusBadRequest, "invalid revision")
+		return
+	}
+	revision, err := h.repo.GetPlaylistRevision(r.Context(), chi.URLParam(r, "id"), rev)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "revision not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to get playlist revision")
+		return
+	}
+	writeJSON(w, http.StatusOK, revision)
+}
+
+// HandleDiffPlaylistRevisions compares revision from with revision to. to
+// defaults to the latest revision and from to the one before to; from=0
+// compares with an empty playlist.
+func (h *Handlers) HandleDiffPlaylistRevisions(w http.ResponseWriter, r *http.Request) {
+	from := parseIntParam(r, "from", -1)
+	to := parseIntParam(r, "to", 0)
+	if from < -1 || to < 0 {
+		writeError(w, http.StatusBadRequest, "revisions must not be negative")
+		return
+	}
+	diff, err := h.repo.DiffPlaylistRevisions(r.Context(), chi.URLParam(r, "id"), from, to)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "revision not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("diff playlist revisions failed")
+		writeError(w, http.StatusInternalServerError, "failed to diff playlist revisions")
+		return
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
+
+// HandleRestorePlaylist returns a playlist to an earlier revision, or
+// without one undoes its deletion. A deleted playlist is restored too.
+func (h *Handlers) HandleRestorePlaylist(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var body struct {
+		Revision int `json:"revision"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	if body.Revision < 0 {
+		writeError(w, http.StatusBadRequest, "revision must not be negative")
+		return
+	}
+
+	playlist, err := h.repo.RestorePlaylistRevision(r.Context(), id, body.Revision)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "revision not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("playlist", id).Msg("restore playlist failed")
+		writeError(w, http.StatusInternalServerError, "failed to restore playlist")
+		return
+	}
+	writeJSON(w, http.StatusOK, playlist)
+}
+
+// HandlePlaylistCover serves a playlist's cover: the uploaded one, or a
+// collage of its first albums' art.
+func (h *Handlers) HandlePlaylistCover(w http.ResponseWriter, r *http.Request) {
+	playlist, err := h.repo.GetPlaylistByID(r.Context(), chi.URLParam(r, "id"))
+	if err != nil || playlist.CoverPath == nil {
+		writeError(w, http.StatusNotFound, "cover not found")
+		return
+	}
+	h.serveArtwork(w, r, *playlist.CoverPath)
+}
+
+// maxCoverSize caps uploaded playlist covers.
+const maxCoverSize = 10 << 20
+
+// HandleSetPlaylistCover replaces a playlist's generated cover with a JPEG
+// or PNG sent as the request body.
+func (h *Handlers) HandleSetPlaylistCover(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := h.covers.SetCustom(r.Context(), id, http.MaxBytesReader(w, r.Body, maxCoverSize))
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "playlist not found")
+		return
+	}
+	if errors.Is(err, artwork.ErrInvalidImage) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("playlist", id).Msg("playlist cover upload failed")
+		writeError(w, http.StatusInternalServerError, "failed to save cover")
+		return
+	}
+	h.writePlaylist(w, r, id)
+}
+
+// HandleResetPlaylistCover removes an uploaded cover and goes back to the
+// generated one.
+func (h *Handlers) HandleResetPlaylistCover(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := h.covers.ClearCustom(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "playlist not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("playlist", id).Msg("playlist cover reset failed")
+		writeError(w, http.StatusInternalServerError, "failed to reset cover")
+		return
+	}
+	h.writePlaylist(w, r, id)
+}
+
+// writePlaylist responds with a playlist's current details.
+func (h *Handlers) writePlaylist(w http.ResponseWriter, r *http.Request, id string) {
+	playlist, err := h.repo.GetPlaylistByID(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to get playlist")
+		return
+	}
+	writeJSON(w, http.StatusOK, playlist)
+}
+
+// HandleUpdatePlaylist renames a playlist, changes its description,
+// replaces a smart playlist's rules or pins it. Pinning alone leaves
+// updated_at as it is.
+func (h *Handlers) HandleUpdatePlaylist(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var body struct {
+		Name        *string        `json:"name"`
+		Description *string        `json:"description"`
+		Rules       *db.SmartRules `json:"rules"`
+		Pinned      *bool          `json:"pinned"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.Name != nil && strings.TrimSpace(*body.Name) == "" {
+		writeError(w, http.StatusBadRequest, "name cannot be empty")
+		return
+	}
+
+	var err error
+	if body.Pinned != nil {
+		err = h.repo.PinPlaylist(r.Context(), id, *body.Pinned)
+	}
+	var playlist *db.Playlist
+	if err == nil && (body.Pinned == nil || body.Name != nil || body.Description != nil || body.Rules != nil) {
+		playlist, err = h.repo.UpdatePlaylist(r.Context(), id, body.Name, body.Description, body.Rules)
+	} else if err == nil {
+		playlist, err = h.repo.GetPlaylistByID(r.Context(), id)
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "playlist not found")
+		return
+	}
+	if errors.Is(err, db.ErrInvalidRules) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, db.ErrNotSmartPlaylist) {
+		writeError(w, http.StatusConflict, "rules can only be set on smart playlists")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update playlist")
+		return
+	}
+	writeJSON(w, http.StatusOK, playlist)
+}
+
+// HandleMovePlaylist files a playlist in a folder (folder_id, null or
+// omitted for the top level) at a 1-based position; 0 or omitted puts it
+// last.
+func (h *Handlers) HandleMovePlaylist(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var body struct {
+		FolderID *string `json:"folder_id"`
+		Position int     `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.Position < 0 {
+		writeError(w, http.StatusBadRequest, "position must not be negative")
+		return
+	}
+
+	err := h.repo.MovePlaylist(r.Context(), id, body.FolderID, body.Position)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("playlist", id).Msg("move playlist failed")
+		writeError(w, http.StatusInternalServerError, "failed to move playlist")
+		return
+	}
+	h.writePlaylist(w, r, id)
+}
+
+// HandleAddTrackToPlaylist appends a single track (track_id) or, in bulk,
+// any mix of track_ids, album_ids and artist_ids.
+func (h *Handlers) HandleAddTrackToPlaylist(w http.ResponseWriter, r *http.Request) {
+	playlistID := chi.URLParam(r, "id")
+	var body struct {
+		TrackID string `json:"track_id"`
+		db.PlaylistAdditions
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	add := &body.PlaylistAdditions
+	if body.TrackID != "" {
+		add.TrackIDs = append([]string{body.TrackID}, add.TrackIDs...)
+	}
+	if len(add.TrackIDs) == 0 && len(add.AlbumIDs) == 0 && len(add.ArtistIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "track_id, track_ids, album_ids or artist_ids is required")
+		return
+	}
+
+	added, err := h.repo.AddToPlaylist(r.Context(), playlistID, add)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, db.ErrSmartPlaylist) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to add tracks")
+		return
+	}
+	playlist, err := h.repo.GetPlaylistByID(r.Context(), playlistID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to get playlist")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"added":    added,
+		"playlist": playlist,
+	})
+}
+
+// HandleRemovePlaylistEntry removes one entry; later entries move up.
+func (h *Handlers) HandleRemovePlaylistEntry(w http.ResponseWriter, r *http.Request) {
+	err := h.repo.RemovePlaylistEntry(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "entryId"))
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "playlist entry not found")
+		return
+	}
+	if errors.Is(err, db.ErrSmartPlaylist) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to remove playlist entry")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleMovePlaylistEntry moves an entry to a 1-based position.
+func (h *Handlers) HandleMovePlaylistEntry(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Position int `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.Position < 1 {
+		writeError(w, http.StatusBadRequest, "position must be 1 or more")
+		return
+	}
+
+	err := h.repo.MovePlaylistEntry(r.Context(), chi.URLParam(r, "id"), chi.URLParam(r, "entryId"), body.Position)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "playlist entry not found")
+		return
+	}
+	if errors.Is(err, db.ErrSmartPlaylist) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to move playlist entry")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleExportPlaylist downloads a playlist as an M3U8, XSPF or PLS file
+// with absolute paths, paths relative to the music directory, or stream
+// URLs.
+func (h *Handlers) HandleExportPlaylist(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	format := cmp.Or(r.URL.Query().Get("format"), playlistfile.FormatM3U8)
+	if format != playlistfile.FormatM3U8 && format != playlistfile.FormatXSPF && format != playlistfile.FormatPLS {
+		writeError(w, http.StatusBadRequest, "format must be m3u8, xspf or pls")
+		return
+	}
+	paths := cmp.Or(r.URL.Query().Get("paths"), transfer.PathsAbsolute)
+	if paths != transfer.PathsAbsolute && paths != transfer.PathsRelative && paths != transfer.PathsStream {
+		writeError(w, http.StatusBadRequest, "paths must be absolute, relative or stream")
+		return
+	}
+	playlist, err := h.repo.GetPlaylistByID(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "playlist not found")
+		return
+	}
+	playlist = h.refreshLive(r.Context(), playlist)
+
+	f, err := transfer.ExportPlaylistFile(r.Context(), h.repo, id, paths, h.scanner.Dirs(), requestBaseURL(r))
+	if err != nil {
+		log.Error().Err(err).Str("playlist", id).Msg("playlist export failed")
+		writeError(w, http.StatusInternalServerError, "failed to export playlist")
+		return
+	}
+	var buf bytes.Buffer
+	if err := playlistfile.Write(&buf, format, f); err != nil {
+		log.Error().Err(err).Str("playlist", id).Msg("playlist export failed")
+		writeError(w, http.StatusInternalServerError, "failed to export playlist")
+		return
+	}
+	w.Header().Set("Content-Type", playlistfile.ContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="`+safeFilename(playlist.Name)+"."+format+`"`)
+	w.Write(buf.Bytes())
+}
+
+// maxPlaylistFileSize caps uploaded playlist files.
+const maxPlaylistFileSize = 10 << 20
+
+// HandleImportPlaylist creates a playlist from an M3U8, XSPF or PLS file sent
+// as the request body. The format is detected unless given; name overrides
+// the playlist name in the file. Entries that match no track are reported.
+func (h *Handlers) HandleImportPlaylist(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPlaylistFileSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read playlist file")
+		return
+	}
+	format := cmp.Or(r.URL.Query().Get("format"), playlistfile.Detect(data))
+	f, err := playlistfile.Parse(bytes.NewReader(data), format)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(f.Entries) == 0 {
+		writeError(w, http.StatusBadRequest, "playlist file has no entries")
+		return
+	}
+
+	report, err := transfer.ImportPlaylistFile(r.Context(), h.repo, f, r.URL.Query().Get("name"), h.scanner.Dirs())
+	if err != nil {
+		log.Error().Err(err).Msg("playlist import failed")
+		writeError(w, http.StatusInternalServerError, "failed to import playlist")
+		return
+	}
+	if report.Playlist == nil {
+		writeJSON(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+	writeJSON(w, http.StatusCreated, report)
+}
+
+// maxServiceExportSize caps uploaded streaming-service exports; an Apple
+// Music library file lists every track in the library.
+const maxServiceExportSize = 200 << 20
+
+// HandleImportServicePlaylists creates playlists from a streaming-service
+// export sent as the request body: Spotify's account data JSON, a CSV from a
+// playlist-transfer tool, or an Apple Music library XML. The source is
+// detected unless given. Tracks that aren't in the library come back as a
+// deduplicated missing list, or with report=csv as a CSV of just that list.
+func (h *Handlers) HandleImportServicePlaylists(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxServiceExportSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read export")
+		return
+	}
+	source := cmp.Or(r.URL.Query().Get("source"), playlistfile.DetectSource(data))
+	files, err := playlistfile.ParseExport(bytes.NewReader(data), source)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := transfer.ImportServicePlaylists(r.Context(), h.repo, files, r.URL.Query().Get("name"))
+	if err != nil {
+		log.Error().Err(err).Str("source", source).Msg("service playlist import failed")
+		writeError(w, http.StatusInternalServerError, "failed to import playlists")
+		return
+	}
+	created := slices.ContainsFunc(report.Playlists, func(p *transfer.ServicePlaylistReport) bool { return p.Playlist != nil })
+
+	if r.URL.Query().Get("report") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="missing-tracks.csv"`)
+		if !created {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"artist", "album", "title", "playlists"})
+		for _, m := range report.Missing {
+			cw.Write([]string{m.Artist, m.Album, m.Title, strings.Join(m.Playlists, "; ")})
+		}
+		cw.Flush()
+		return
+	}
+	if !created {
+		writeJSON(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+	writeJSON(w, http.StatusCreated, report)
+}
+
+// requestBaseURL returns the scheme and host the client used to reach the
+// server, honouring a reverse proxy's X-Forwarded-Proto.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	scheme = cmp.Or(r.Header.Get("X-Forwarded-Proto"), scheme)
+	return scheme + "://" + r.Host
+}
+
+// safeFilename replaces characters that are awkward in download file names.
+func safeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' ' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+	return cmp.Or(strings.TrimSpace(name), "playlist")
+}
+
+// --- Playlist Folders ---
+
+func (h *Handlers) HandleListPlaylistFolders(w http.ResponseWriter, r *http.Request) {
+	folders, err := h.repo.ListPlaylistFolders(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list playlist folders")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items": folders,
+		"total": len(folders),
+	})
+}
+
+// HandleCreatePlaylistFolder creates a folder at the end of the top level or
+// of parent_id.
+func (h *Handlers) HandleCreatePlaylistFolder(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name     string  `json:"name"`
+		ParentID *string `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	folder, err := h.repo.CreatePlaylistFolder(r.Context(), body.Name, body.ParentID)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "parent folder not found")
+		return
+	}
+	if errors.Is(err, db.ErrInvalidFolder) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("create playlist folder failed")
+		writeError(w, http.StatusInternalServerError, "failed to create folder")
+		return
+	}
+	writeJSON(w, http.StatusCreated, folder)
+}
+
+// HandleRenamePlaylistFolder changes a folder's name.
+func (h *Handlers) HandleRenamePlaylistFolder(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		writeError(w, http.StatusBadRequest, "name cannot be empty")
+		return
+	}
+
+	folder, err := h.repo.RenamePlaylistFolder(r.Context(), chi.URLParam(r, "folderId"), body.Name)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "folder not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to rename folder")
+		return
+	}
+	writeJSON(w, http.StatusOK, folder)
+}
+
+// HandleDeletePlaylistFolder removes a folder; its contents move up a level.
+func (h *Handlers) HandleDeletePlaylistFolder(w http.ResponseWriter, r *http.Request) {
+	err := h.repo.DeletePlaylistFolder(r.Context(), chi.URLParam(r, "folderId"))
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "folder not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("delete playlist folder failed")
+		writeError(w, http.StatusInternalServerError, "failed to delete folder")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleMovePlaylistFolder moves a folder into another (parent_id, null or
+// omitted for the top level) at a 1-based position; 0 or omitted puts it
+// last.
+func (h *Handlers) HandleMovePlaylistFolder(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "folderId")
+	var body struct {
+		ParentID *string `json:"parent_id"`
+		Position int     `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.Position < 0 {
+		writeError(w, http.StatusBadRequest, "position must not be negative")
+		return
+	}
+
+	err := h.repo.MovePlaylistFolder(r.Context(), id, body.ParentID, body.Position)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, db.ErrFolderCycle) || errors.Is(err, db.ErrInvalidFolder) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("folder", id).Msg("move playlist folder failed")
+		writeError(w, http.StatusInternalServerError, "failed to move folder")
+		return
+	}
+	folder, err := h.repo.GetPlaylistFolder(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to get folder")
+		return
+	}
+	writeJSON(w, http.StatusOK, folder)
+}
+
+// --- Play History ---
+
+func (h *Handlers) HandleRecordPlay(w http.ResponseWriter, r *http.Request) {
+	trackID := chi.URLParam(r, "id")
+	var body struct {
+		Duration *float64 `json:"duration"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	if err := h.repo.RecordPlay(r.Context(), trackID, body.Duration); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to record play")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- Ratings ---
+
+func (h *Handlers) HandleSetTrackRating(w http.ResponseWriter, r *http.Request) {
+	trackID := chi.URLParam(r, "id")
+	var body struct {
+		Rating int `json:"rating"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.Rating < 0 || body.Rating > 5 {
+		writeError(w, http.StatusBadRequest, "rating must be between 0 and 5")
+		return
+	}
+	if _, err := h.repo.GetTrackByID(r.Context(), trackID); err != nil {
+		writeError(w, http.StatusNotFound, "track not found")
+		return
+	}
+
+	if err := h.repo.SetTrackRating(r.Context(), trackID, body.Rating, time.Now()); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to save rating")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- Stats ---
+
+func (h *Handlers) HandleStats(w http.ResponseWriter, r *http.Request) {
+	// Quick library stats
+	ctx := r.Context()
+	var artistCount, albumCount, trackCount int64
+	h.repo.CountEntities(ctx, &artistCount, &albumCount, &trackCount)
+
+	writeJSON(w, http.StatusOK, map[string]any{
 		"artists": artistCount,
 		"albums":  albumCount,
 		"tracks":  trackCount,
@@ -361,6 +1538,93 @@ func parsePagination(r *http.Request) (limit, offset int) {
 	return
 }
 
+// parseListOptions reads sort, order and filter parameters for the artist,
+// album and track listings.
+func parseListOptions(r *http.Request) (*db.ListOptions, error) {
+	q := r.URL.Query()
+	limit, offset := parsePagination(r)
+	opts := &db.ListOptions{
+		Limit:          limit,
+		Offset:         offset,
+		Sort:           q.Get("sort"),
+		Order:          q.Get("order"),
+		HideDuplicates: parseBoolParam(r, "hide_duplicates"),
+		Genre:          q.Get("genre"),
+		Format:         q.Get("format"),
+		HiRes:          parseBoolParam(r, "hires"),
+		Cursor:         q.Get("cursor"),
+	}
+
+	ints := map[string]*int{
+		"year_from":       &opts.YearFrom,
+		"year_to":         &opts.YearTo,
+		"decade":          &opts.Decade,
+		"min_sample_rate": &opts.MinSampleRate,
+		"min_bit_depth":   &opts.MinBitDepth,
+	}
+	for name, dst := range ints {
+		if s := q.Get(name); s != "" {
+			v, err := strconv.Atoi(s)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("%s must be a positive number", name)
+			}
+			*dst = v
+		}
+	}
+	if opts.Decade%10 != 0 {
+		return nil, fmt.Errorf("decade must be a year ending in 0, e.g. 1990")
+	}
+
+	if s := q.Get("added_since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("added_since must be a date (2006-01-02) or RFC 3339 time")
+		}
+		opts.AddedSince = t
+	}
+
+	if opts.Sort == db.SortRandom {
+		// Without a seed every request reshuffles; hand one back so the
+		// client can page through a stable order
+		opts.Seed = rand.Int64()
+		if s := q.Get("seed"); s != "" {
+			v, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("seed must be a number")
+			}
+			opts.Seed = v
+		}
+	}
+	return opts, nil
+}
+
+// listResponse wraps a page of a sorted listing with its cursors. Pages
+// fetched by cursor leave out the total, and random order echoes its seed.
+func listResponse[T any](items []T, page *db.Page, opts *db.ListOptions) map[string]any {
+	resp := map[string]any{
+		"items": items,
+		"next":  nilIfEmpty(page.Next),
+		"prev":  nilIfEmpty(page.Prev),
+	}
+	if page.Total >= 0 {
+		resp["total"] = page.Total
+	}
+	if opts.Sort == db.SortRandom {
+		resp["seed"] = opts.Seed
+	}
+	return resp
+}
+
+func nilIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
 func parseIntParam(r *http.Request, name string, defaultVal int) int {
 	s := r.URL.Query().Get(name)
 	if s == "" {
@@ -372,3 +1636,8 @@ func parseIntParam(r *http.Request, name string, defaultVal int) int {
 	}
 	return v
 }
+
+func parseBoolParam(r *http.Request, name string) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get(name))
+	return v
+}
@@ -43,6 +43,7 @@ func NewRouter(handlers *Handlers) http.Handler {
 		r.Get("/albums/random", handlers.HandleRandomAlbums)
 
 		// Tracks
+		r.Get("/tracks", handlers.HandleListTracks)
 		r.Get("/tracks/{id}", handlers.HandleGetTrack)
 		r.Get("/tracks/{id}/stream", handlers.HandleStreamTrack)
 
@@ -51,22 +52,64 @@ func NewRouter(handlers *Handlers) http.Handler {
 
 		// Search
 		r.Get("/search", handlers.HandleSearch)
+		r.Get("/search/suggest", handlers.HandleSearchSuggest)
 
 		// Library management
 		r.Post("/library/scan", handlers.HandleScanLibrary)
+		r.Get("/library/organize/preview", handlers.HandleOrganizePreview)
+		r.Get("/library/organize", handlers.HandleOrganizeStatus)
+		r.Post("/library/organize", handlers.HandleOrganizeLibrary)
+		r.Get("/library/imports", handlers.HandleListImports)
+		r.Get("/library/integrity", handlers.HandleListIntegrity)
+		r.Post("/library/integrity/verify", handlers.HandleVerifyIntegrity)
+		r.Get("/library/health", handlers.HandleLibraryHealth)
+		r.Get("/library/duplicates", handlers.HandleListDuplicates)
+		r.Post("/library/duplicates/scan", handlers.HandleScanDuplicates)
 
 		// Playlists
 		r.Get("/playlists", handlers.HandleListPlaylists)
 		r.Post("/playlists", handlers.HandleCreatePlaylist)
+		r.Post("/playlists/import", handlers.HandleImportPlaylist)
+		r.Post("/playlists/import/service", handlers.HandleImportServicePlaylists)
+		r.Get("/playlists/deleted", handlers.HandleListDeletedPlaylists)
+		r.Get("/playlists/folders", handlers.HandleListPlaylistFolders)
+		r.Post("/playlists/folders", handlers.HandleCreatePlaylistFolder)
+		r.Patch("/playlists/folders/{folderId}", handlers.HandleRenamePlaylistFolder)
+		r.Delete("/playlists/folders/{folderId}", handlers.HandleDeletePlaylistFolder)
+		r.Post("/playlists/folders/{folderId}/move", handlers.HandleMovePlaylistFolder)
 		r.Get("/playlists/{id}", handlers.HandleGetPlaylist)
+		r.Patch("/playlists/{id}", handlers.HandleUpdatePlaylist)
+		r.Get("/playlists/{id}/export", handlers.HandleExportPlaylist)
+		r.Post("/playlists/{id}/refresh", handlers.HandleRefreshPlaylist)
+		r.Post("/playlists/{id}/move", handlers.HandleMovePlaylist)
+		r.Get("/playlists/{id}/cover", handlers.HandlePlaylistCover)
+		r.Put("/playlists/{id}/cover", handlers.HandleSetPlaylistCover)
+		r.Delete("/playlists/{id}/cover", handlers.HandleResetPlaylistCover)
 		r.Delete("/playlists/{id}", handlers.HandleDeletePlaylist)
+		r.Get("/playlists/{id}/revisions", handlers.HandleListPlaylistRevisions)
+		r.Get("/playlists/{id}/revisions/diff", handlers.HandleDiffPlaylistRevisions)
+		r.Get("/playlists/{id}/revisions/{rev}", handlers.HandleGetPlaylistRevision)
+		r.Post("/playlists/{id}/restore", handlers.HandleRestorePlaylist)
 		r.Post("/playlists/{id}/tracks", handlers.HandleAddTrackToPlaylist)
+		r.Delete("/playlists/{id}/tracks/{entryId}", handlers.HandleRemovePlaylistEntry)
+		r.Post("/playlists/{id}/tracks/{entryId}/move", handlers.HandleMovePlaylistEntry)
 
 		// Play history
 		r.Post("/tracks/{id}/play", handlers.HandleRecordPlay)
 
+		// Ratings
+		r.Put("/tracks/{id}/rating", handlers.HandleSetTrackRating)
+
 		// Stats
 		r.Get("/stats", handlers.HandleStats)
+
+		// Admin
+		r.Get("/admin/backups", handlers.HandleListBackups)
+		r.Post("/admin/backup", handlers.HandleCreateBackup)
+		r.Get("/admin/export", handlers.HandleExportLibrary)
+		r.Post("/admin/import", handlers.HandleImportLibrary)
+		r.Get("/admin/search-index", handlers.HandleCheckSearchIndex)
+		r.Post("/admin/search-index/rebuild", handlers.HandleRebuildSearchIndex)
 	})
 
 	// SPA fallback - serve index.html for all other routes
@@ -81,7 +124,7 @@ func NewRouter(handlers *Handlers) http.Handler {
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Range")
 		w.Header().Set("Access-Control-Expose-Headers", "Content-Range, Content-Length, Accept-Ranges")
 
@@ -0,0 +1,180 @@
+package artwork
+
+// Package artwork resizes cover images and builds playlist collages, using
+// only the standard library's JPEG and PNG codecs.
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png" // decode PNG covers
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// Sizes are the widths resized artwork is served at. Requests are rounded up
+// to one of them so the cache holds a handful of files per cover.
+var Sizes = []int{64, 128, 256, 512, 1024}
+
+// jpegQuality is used for every image this package writes.
+const jpegQuality = 85
+
+// ErrInvalidSize is returned for sizes that are not positive.
+var ErrInvalidSize = errors.New("invalid artwork size")
+
+// Size rounds a requested size up to the nearest of Sizes, or down to the
+// largest.
+func Size(n int) (int, error) {
+	if n <= 0 {
+		return 0, ErrInvalidSize
+	}
+	i, _ := slices.BinarySearch(Sizes, n)
+	return Sizes[min(i, len(Sizes)-1)], nil
+}
+
+// Cache stores resized copies of artwork on disk.
+type Cache struct {
+	dir string
+}
+
+// NewCache creates a cache of resized artwork in dir.
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// Resized returns the path of a copy of the image at path that fits in a
+// size×size box, creating it if needed. Images already that small are
+// returned as they are. Copies are made again when the original changes.
+func (c *Cache) Resized(path string, size int) (string, error) {
+	src, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum([]byte(path))
+	out := filepath.Join(c.dir, hex.EncodeToString(sum[:8])+"-"+strconv.Itoa(size)+".jpg")
+	if cached, err := os.Stat(out); err == nil && !cached.ModTime().Before(src.ModTime()) {
+		return out, nil
+	}
+
+	img, err := Load(path)
+	if err != nil {
+		return "", err
+	}
+	if b := img.Bounds(); b.Dx() <= size && b.Dy() <= size {
+		return path, nil
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return "", fmt.Errorf("create artwork cache: %w", err)
+	}
+	if err := WriteJPEG(out, Fit(img, size)); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// Load decodes a JPEG or PNG file.
+func Load(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// WriteJPEG encodes img to path, replacing it atomically so readers never
+// see a partial file.
+func WriteJPEG(path string, img image.Image) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".artwork-*")
+	if err != nil {
+		return fmt.Errorf("write artwork: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := jpeg.Encode(tmp, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("encode artwork: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write artwork: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Fit scales img down to fit in a size×size box, keeping its aspect ratio.
+func Fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	if w >= h {
+		return scale(img, size, max(h*size/w, 1))
+	}
+	return scale(img, max(w*size/h, 1), size)
+}
+
+// Square crops the centre square of img and scales it to size×size.
+func Square(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	crop := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(crop, crop.Bounds(), img, image.Pt(x0, y0), draw.Src)
+	return scale(crop, size, size)
+}
+
+// Collage tiles four images, each cropped square, into a 2×2 grid of
+// size×size.
+func Collage(tiles [4]image.Image, size int) image.Image {
+	half := size / 2
+	out := image.NewRGBA(image.Rect(0, 0, half*2, half*2))
+	for i, img := range tiles {
+		at := image.Pt(i%2*half, i/2*half)
+		draw.Draw(out, image.Rectangle{at, at.Add(image.Pt(half, half))}, Square(img, half), image.Point{}, draw.Src)
+	}
+	return out
+}
+
+// scale resamples img to w×h by averaging the source pixels under each
+// destination pixel, which is sharp enough for downscaling artwork. Images
+// are enlarged by repeating pixels.
+func scale(img image.Image, w, h int) *image.RGBA {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	sw, sh := b.Dx(), b.Dy()
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := range w {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint32(p[0])
+					g += uint32(p[1])
+					bl += uint32(p[2])
+					a += uint32(p[3])
+					n++
+				}
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(bl/n), uint8(a/n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,272 @@
+package artwork
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+// Playlist cover dimensions: collages are coverSize square, uploads are
+// scaled to fit in maxUploadSize.
+const (
+	coverSize     = 600
+	maxUploadSize = 1200
+)
+
+// ErrInvalidImage is returned for uploads that are not JPEG or PNG images.
+var ErrInvalidImage = errors.New("cover must be a JPEG or PNG image")
+
+// PlaylistCovers draws playlist covers: a 2×2 collage of the first four
+// distinct albums' art, or the first album's art when there are fewer. Covers
+// are redrawn in the background when a playlist's entries change, unless the
+// user uploaded one.
+type PlaylistCovers struct {
+	repo db.Store
+	dir  string
+
+	// drawing serializes cover writes, so a background redraw can't
+	// replace an upload that lands while it runs
+	drawing sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]bool
+	wake    chan struct{}
+	sources map[string]string // playlist ID -> art the current cover was drawn from
+}
+
+// NewPlaylistCovers creates a cover generator writing to dir. Register
+// Queue with the store's OnPlaylistChange and start Run.
+func NewPlaylistCovers(repo db.Store, dir string) *PlaylistCovers {
+	return &PlaylistCovers{
+		repo:    repo,
+		dir:     dir,
+		pending: make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+		sources: make(map[string]string),
+	}
+}
+
+// Queue schedules a playlist's cover to be redrawn.
+func (c *PlaylistCovers) Queue(playlistID string) {
+	c.mu.Lock()
+	c.pending[playlistID] = true
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// QueueAll schedules every playlist's cover, e.g. after a scan finds new
+// album art. Covers whose art hasn't changed are left alone.
+func (c *PlaylistCovers) QueueAll() {
+	playlists, err := c.repo.ListPlaylists(context.Background())
+	if err != nil {
+		log.Warn().Err(err).Msg("list playlists for covers")
+		return
+	}
+	for _, p := range playlists {
+		c.Queue(p.ID)
+	}
+}
+
+// Run draws queued covers until ctx is cancelled, starting with every
+// playlist.
+func (c *PlaylistCovers) Run(ctx context.Context) {
+	c.QueueAll()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.wake:
+		}
+		c.mu.Lock()
+		ids := make([]string, 0, len(c.pending))
+		for id := range c.pending {
+			ids = append(ids, id)
+		}
+		clear(c.pending)
+		c.mu.Unlock()
+
+		for _, id := range ids {
+			if err := c.Update(ctx, id); err != nil && ctx.Err() == nil {
+				log.Warn().Err(err).Str("playlist", id).Msg("playlist cover update failed")
+			}
+		}
+	}
+}
+
+// Update redraws a playlist's generated cover from its current entries, or
+// removes the files of a purged playlist.
+func (c *PlaylistCovers) Update(ctx context.Context, playlistID string) error {
+	c.drawing.Lock()
+	defer c.drawing.Unlock()
+	return c.update(ctx, playlistID)
+}
+
+func (c *PlaylistCovers) update(ctx context.Context, playlistID string) error {
+	p, err := c.repo.GetPlaylistByID(ctx, playlistID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted playlists keep their cover in case they are restored
+		if deleted, err := c.isDeleted(ctx, playlistID); err != nil || deleted {
+			return err
+		}
+		c.forget(playlistID)
+		os.Remove(c.generatedPath(playlistID))
+		os.Remove(c.customPath(playlistID))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if p.CoverCustom {
+		return nil
+	}
+
+	// A few spare albums in case some art is unreadable
+	paths, err := c.repo.PlaylistCoverArt(ctx, playlistID, 8)
+	if err != nil {
+		return err
+	}
+	var tiles []image.Image
+	var used []string
+	for _, path := range paths {
+		img, err := Load(path)
+		if err != nil {
+			log.Debug().Err(err).Str("cover", path).Msg("skipping unreadable album art")
+			continue
+		}
+		tiles = append(tiles, img)
+		used = append(used, path+"@"+modTime(path))
+		if len(tiles) == 4 {
+			break
+		}
+	}
+
+	out := c.generatedPath(playlistID)
+	source := strings.Join(used, "\n")
+	c.mu.Lock()
+	unchanged, seen := c.sources[playlistID]
+	c.mu.Unlock()
+	if seen && unchanged == source && p.CoverPath != nil {
+		if _, err := os.Stat(out); err == nil {
+			return nil
+		}
+	}
+
+	if len(tiles) == 0 {
+		os.Remove(out)
+		c.remember(playlistID, source)
+		if p.CoverPath == nil {
+			return nil
+		}
+		return c.repo.SetPlaylistCover(ctx, playlistID, nil, false)
+	}
+
+	var cover image.Image
+	if len(tiles) == 4 {
+		cover = Collage([4]image.Image(tiles), coverSize)
+	} else {
+		cover = Square(tiles[0], coverSize)
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return fmt.Errorf("create playlist cover directory: %w", err)
+	}
+	if err := WriteJPEG(out, cover); err != nil {
+		return err
+	}
+	c.remember(playlistID, source)
+	return c.repo.SetPlaylistCover(ctx, playlistID, &out, false)
+}
+
+// isDeleted reports whether a playlist is deleted but not yet purged.
+func (c *PlaylistCovers) isDeleted(ctx context.Context, playlistID string) (bool, error) {
+	deleted, err := c.repo.ListDeletedPlaylists(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range deleted {
+		if p.ID == playlistID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetCustom stores an uploaded JPEG or PNG as a playlist's cover, in place
+// of the generated one.
+func (c *PlaylistCovers) SetCustom(ctx context.Context, playlistID string, r io.Reader) error {
+	c.drawing.Lock()
+	defer c.drawing.Unlock()
+	if _, err := c.repo.GetPlaylistByID(ctx, playlistID); errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("playlist %s: %w", playlistID, db.ErrNotFound)
+	} else if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return fmt.Errorf("create playlist cover directory: %w", err)
+	}
+	out := c.customPath(playlistID)
+	if err := WriteJPEG(out, Fit(img, maxUploadSize)); err != nil {
+		return err
+	}
+	os.Remove(c.generatedPath(playlistID))
+	c.forget(playlistID)
+	return c.repo.SetPlaylistCover(ctx, playlistID, &out, true)
+}
+
+// ClearCustom removes an uploaded cover and draws the generated one again.
+func (c *PlaylistCovers) ClearCustom(ctx context.Context, playlistID string) error {
+	c.drawing.Lock()
+	defer c.drawing.Unlock()
+	if err := c.repo.SetPlaylistCover(ctx, playlistID, nil, false); err != nil {
+		return err
+	}
+	os.Remove(c.customPath(playlistID))
+	return c.update(ctx, playlistID)
+}
+
+func (c *PlaylistCovers) generatedPath(id string) string {
+	return filepath.Join(c.dir, id+".jpg")
+}
+
+func (c *PlaylistCovers) customPath(id string) string {
+	return filepath.Join(c.dir, id+".custom.jpg")
+}
+
+func (c *PlaylistCovers) remember(id, source string) {
+	c.mu.Lock()
+	c.sources[id] = source
+	c.mu.Unlock()
+}
+
+func (c *PlaylistCovers) forget(id string) {
+	c.mu.Lock()
+	delete(c.sources, id)
+	c.mu.Unlock()
+}
+
+// modTime identifies a version of a file, so replaced album art redraws
+// the collages using it.
+func modTime(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return info.ModTime().UTC().Format(time.RFC3339Nano)
+}
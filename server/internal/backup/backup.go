@@ -0,0 +1,305 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+// Archive layout. Settings and overrides live in the database
+// (system_config), so the snapshot covers them.
+const (
+	archivePrefix = "mms-backup-"
+	archiveSuffix = ".tar.gz"
+	dbEntry       = "mms.db"
+	artworkEntry  = "artwork"
+	manifestEntry = "manifest.json"
+	timeLayout    = "20060102-150405"
+)
+
+// ErrUnsupported is returned when the database driver has no online backup
+// support (PostgreSQL deployments should use pg_dump).
+var ErrUnsupported = errors.New("online backup requires the sqlite driver; use pg_dump for postgres")
+
+// Info describes one backup archive.
+type Info struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// manifest is stored in every archive to describe its contents.
+type manifest struct {
+	CreatedAt     time.Time `json:"created_at"`
+	SchemaVersion int       `json:"schema_version"`
+	Artwork       bool      `json:"artwork"`
+}
+
+// Manager takes consistent snapshots of the running database and keeps a
+// fixed number of rotated archives.
+type Manager struct {
+	db             *sql.DB // snapshot source; nil when the driver is unsupported
+	dir            string
+	artworkDir     string
+	keep           int
+	includeArtwork bool
+	interval       time.Duration
+
+	mu      sync.Mutex
+	running bool
+	last    *Info
+}
+
+// NewManager creates a backup manager. database should be the read pool so
+// snapshots don't block writers; pass nil for drivers without online backup.
+func NewManager(database *sql.DB, dir, artworkDir string, keep int, includeArtwork bool, interval time.Duration) *Manager {
+	if keep <= 0 {
+		keep = 7
+	}
+	return &Manager{
+		db:             database,
+		dir:            dir,
+		artworkDir:     artworkDir,
+		keep:           keep,
+		includeArtwork: includeArtwork,
+		interval:       interval,
+	}
+}
+
+// IsRunning returns whether a backup is in progress.
+func (m *Manager) IsRunning() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.running
+}
+
+// LastBackup returns the most recent backup made by this process, if any.
+func (m *Manager) LastBackup() *Info {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last
+}
+
+// Run creates a backup every interval until ctx is cancelled. A zero
+// interval or unsupported driver disables scheduling.
+func (m *Manager) Run(ctx context.Context) {
+	if m.interval <= 0 || m.db == nil {
+		return
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := m.Create(ctx); err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("scheduled backup failed")
+		}
+	}
+}
+
+// Create snapshots the database with VACUUM INTO, archives it with the
+// artwork cache and prunes old archives.
+func (m *Manager) Create(ctx context.Context) (*Info, error) {
+	if m.db == nil {
+		return nil, ErrUnsupported
+	}
+	m.mu.Lock()
+	if m.running {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("backup already in progress")
+	}
+	m.running = true
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.running = false
+		m.mu.Unlock()
+	}()
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, fmt.Errorf("create backup directory: %w", err)
+	}
+
+	now := time.Now().UTC()
+	stamp := now.Format(timeLayout)
+	tmp, err := os.MkdirTemp(m.dir, ".tmp-"+stamp+"-")
+	if err != nil {
+		return nil, fmt.Errorf("create staging directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	snapshot := filepath.Join(tmp, dbEntry)
+	if _, err := m.db.ExecContext(ctx, `VACUUM INTO ?`, snapshot); err != nil {
+		return nil, fmt.Errorf("snapshot database: %w", err)
+	}
+
+	name := archivePrefix + stamp + archiveSuffix
+	dest := filepath.Join(m.dir, name)
+	man := manifest{CreatedAt: now, SchemaVersion: db.LatestVersion(), Artwork: m.includeArtwork}
+	if err := m.writeArchive(dest+".partial", snapshot, man); err != nil {
+		os.Remove(dest + ".partial")
+		return nil, err
+	}
+	if err := os.Rename(dest+".partial", dest); err != nil {
+		return nil, fmt.Errorf("finalize archive: %w", err)
+	}
+
+	fi, err := os.Stat(dest)
+	if err != nil {
+		return nil, fmt.Errorf("stat archive: %w", err)
+	}
+	info := &Info{Name: name, Size: fi.Size(), CreatedAt: now}
+	m.mu.Lock()
+	m.last = info
+	m.mu.Unlock()
+	log.Info().Str("archive", dest).Int64("bytes", info.Size).Msg("backup created")
+
+	if err := m.prune(); err != nil {
+		log.Warn().Err(err).Msg("failed to prune old backups")
+	}
+	return info, nil
+}
+
+// List returns the archives in the backup directory, newest first.
+func (m *Manager) List() ([]*Info, error) {
+	entries, err := os.ReadDir(m.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []*Info{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read backup directory: %w", err)
+	}
+
+	backups := []*Info{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, archivePrefix) || !strings.HasSuffix(name, archiveSuffix) {
+			continue
+		}
+		created, err := time.Parse(timeLayout, strings.TrimSuffix(strings.TrimPrefix(name, archivePrefix), archiveSuffix))
+		if err != nil {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, &Info{Name: name, Size: fi.Size(), CreatedAt: created})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+// prune deletes all but the newest keep archives.
+func (m *Manager) prune() error {
+	backups, err := m.List()
+	if err != nil {
+		return err
+	}
+	for _, b := range backups[min(m.keep, len(backups)):] {
+		if err := os.Remove(filepath.Join(m.dir, b.Name)); err != nil {
+			return err
+		}
+		log.Info().Str("archive", b.Name).Msg("removed old backup")
+	}
+	return nil
+}
+
+func (m *Manager) writeArchive(dest, snapshot string, man manifest) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("create archive: %w", err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(man, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name: manifestEntry, Mode: 0644, Size: int64(len(data)), ModTime: man.CreatedAt,
+	}); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	if err := addFile(tw, snapshot, dbEntry); err != nil {
+		return fmt.Errorf("archive database: %w", err)
+	}
+
+	if man.Artwork {
+		err := filepath.WalkDir(m.artworkDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(m.artworkDir, path)
+			if err != nil {
+				return err
+			}
+			return addFile(tw, path, artworkEntry+"/"+filepath.ToSlash(rel))
+		})
+		if err != nil {
+			return fmt.Errorf("archive artwork: %w", err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("close archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("close archive: %w", err)
+	}
+	return f.Close()
+}
+
+func addFile(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
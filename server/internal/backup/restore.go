@@ -0,0 +1,156 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+// Restore replaces the database (and artwork, when the archive has it) with
+// the contents of a backup archive. The server must not be running. The
+// current files are kept alongside with a ".pre-restore-<time>" suffix.
+func Restore(archive, dbPath, artworkDir string) error {
+	staging, err := os.MkdirTemp(filepath.Dir(dbPath), ".restore-")
+	if err != nil {
+		return fmt.Errorf("create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	man, err := extractArchive(archive, staging)
+	if err != nil {
+		return fmt.Errorf("extract %s: %w", archive, err)
+	}
+	if man.SchemaVersion > db.LatestVersion() {
+		return fmt.Errorf("%w: backup at version %d, binary supports %d",
+			db.ErrSchemaTooNew, man.SchemaVersion, db.LatestVersion())
+	}
+
+	restoredDB := filepath.Join(staging, dbEntry)
+	if err := checkDatabase(restoredDB); err != nil {
+		return fmt.Errorf("backup database is damaged: %w", err)
+	}
+
+	suffix := ".pre-restore-" + time.Now().UTC().Format(timeLayout)
+
+	// Move the live database and its WAL files aside together
+	for _, ext := range []string{"", "-wal", "-shm"} {
+		if err := os.Rename(dbPath+ext, dbPath+suffix+ext); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("move current database aside: %w", err)
+		}
+	}
+	if err := os.Rename(restoredDB, dbPath); err != nil {
+		return fmt.Errorf("install restored database: %w", err)
+	}
+	log.Info().Str("db", dbPath).Str("previous", dbPath+suffix).Msg("database restored")
+
+	if !man.Artwork {
+		return nil
+	}
+	restoredArt := filepath.Join(staging, artworkEntry)
+	if err := os.Rename(artworkDir, artworkDir+suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("move current artwork aside: %w", err)
+	}
+	if _, err := os.Stat(restoredArt); errors.Is(err, fs.ErrNotExist) {
+		// The backup was taken before any artwork was extracted
+		return os.MkdirAll(artworkDir, 0755)
+	}
+	if err := os.Rename(restoredArt, artworkDir); err != nil {
+		return fmt.Errorf("install restored artwork: %w", err)
+	}
+	log.Info().Str("dir", artworkDir).Msg("artwork restored")
+	return nil
+}
+
+// extractArchive unpacks a backup into dest and returns its manifest.
+func extractArchive(archive, dest string) (*manifest, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var man *manifest
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if !filepath.IsLocal(hdr.Name) {
+			return nil, fmt.Errorf("unsafe path %q in archive", hdr.Name)
+		}
+
+		if hdr.Name == manifestEntry {
+			man = &manifest{}
+			if err := json.NewDecoder(tr).Decode(man); err != nil {
+				return nil, fmt.Errorf("read manifest: %w", err)
+			}
+			continue
+		}
+
+		target := filepath.Join(dest, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return nil, err
+		}
+		if err := out.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if man == nil {
+		return nil, fmt.Errorf("not an MMS backup: no %s", manifestEntry)
+	}
+	if _, err := os.Stat(filepath.Join(dest, dbEntry)); err != nil {
+		return nil, fmt.Errorf("not an MMS backup: no %s", dbEntry)
+	}
+	return man, nil
+}
+
+// checkDatabase runs SQLite's integrity check on a restored database file.
+func checkDatabase(path string) error {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var result string
+	if err := conn.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check: %s", result)
+	}
+	return nil
+}
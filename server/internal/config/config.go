@@ -3,16 +3,25 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config holds all server configuration.
 type Config struct {
-	Server  ServerConfig  `yaml:"server"`
-	Music   MusicConfig   `yaml:"music"`
-	Database DatabaseConfig `yaml:"database"`
-	Transcode TranscodeConfig `yaml:"transcode"`
+	Server     ServerConfig     `yaml:"server"`
+	Music      MusicConfig      `yaml:"music"`
+	Database   DatabaseConfig   `yaml:"database"`
+	Transcode  TranscodeConfig  `yaml:"transcode"`
+	Artwork    ArtworkConfig    `yaml:"artwork"`
+	Organizer  OrganizerConfig  `yaml:"organizer"`
+	Inbox      InboxConfig      `yaml:"inbox"`
+	Integrity  IntegrityConfig  `yaml:"integrity"`
+	Duplicates DuplicatesConfig `yaml:"duplicates"`
+	Backup     BackupConfig     `yaml:"backup"`
+	Search     SearchConfig     `yaml:"search"`
+	Playlists  PlaylistsConfig  `yaml:"playlists"`
 }
 
 // ServerConfig holds HTTP server settings.
@@ -23,13 +32,16 @@ type ServerConfig struct {
 
 // MusicConfig holds music library settings.
 type MusicConfig struct {
-	Directories []string `yaml:"directories"`
-	WatchForChanges bool `yaml:"watch_for_changes"`
+	Directories     []string `yaml:"directories"`
+	WatchForChanges bool     `yaml:"watch_for_changes"`
 }
 
 // DatabaseConfig holds database settings.
 type DatabaseConfig struct {
-	Path string `yaml:"path"`
+	Driver          string `yaml:"driver"` // "sqlite" (default) or "postgres"
+	Path            string `yaml:"path"`   // SQLite database file
+	DSN             string `yaml:"dsn"`    // PostgreSQL connection string
+	ReadConnections int    `yaml:"read_connections"`
 }
 
 // TranscodeConfig holds transcoding settings.
@@ -38,6 +50,76 @@ type TranscodeConfig struct {
 	FFmpegPath string `yaml:"ffmpeg_path"`
 }
 
+// ArtworkConfig holds cover art settings.
+type ArtworkConfig struct {
+	// CacheDir holds resized copies of album and playlist covers.
+	CacheDir string `yaml:"cache_dir"`
+}
+
+// OrganizerConfig holds library file organizer settings.
+type OrganizerConfig struct {
+	// Template is the layout of organized files relative to their music
+	// directory, e.g. "{albumartist}/{year} - {album}/{disc}-{track} {title}.{ext}".
+	Template string `yaml:"template"`
+	// OnConflict decides what happens when the target path is taken:
+	// "skip" leaves the file where it is, "rename" appends a counter.
+	OnConflict string `yaml:"on_conflict"`
+}
+
+// InboxConfig holds settings for the import drop folder.
+type InboxConfig struct {
+	// Directory is watched for new files and .zip archives; empty disables the inbox.
+	Directory string `yaml:"directory"`
+	// QuarantineDir receives files that fail validation.
+	QuarantineDir string `yaml:"quarantine_dir"`
+	// Library is the music directory imports are moved into (default: first music directory).
+	Library      string        `yaml:"library"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// IntegrityConfig holds audio verification settings.
+type IntegrityConfig struct {
+	// Interval is how often each track is re-verified; 0 disables the schedule.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// DuplicatesConfig holds duplicate detection settings.
+type DuplicatesConfig struct {
+	// FpcalcPath is Chromaprint's fpcalc tool; empty disables fingerprint matching.
+	FpcalcPath string `yaml:"fpcalc_path"`
+}
+
+// BackupConfig holds online backup settings.
+type BackupConfig struct {
+	Directory string `yaml:"directory"`
+	// Interval between scheduled backups; 0 disables the schedule.
+	Interval time.Duration `yaml:"interval"`
+	// Keep is how many backups to retain; older ones are deleted.
+	Keep           int  `yaml:"keep"`
+	IncludeArtwork bool `yaml:"include_artwork"`
+}
+
+// SearchConfig holds search ranking weights. Each signal is scaled to 0..1
+// before weighting; 0 turns a signal off.
+type SearchConfig struct {
+	RelevanceWeight float64 `yaml:"relevance_weight"` // text match
+	PlayWeight      float64 `yaml:"play_weight"`
+	RatingWeight    float64 `yaml:"rating_weight"`
+	RecencyWeight   float64 `yaml:"recency_weight"`
+	// ArtistWeight favors artists with more of the library, so main artists
+	// rank above one-off guest appearances.
+	ArtistWeight float64 `yaml:"artist_weight"`
+	// RecencyHalfLife is how long after the last play the recency boost halves.
+	RecencyHalfLife time.Duration `yaml:"recency_half_life"`
+}
+
+// PlaylistsConfig holds playlist settings.
+type PlaylistsConfig struct {
+	// DeletedRetention is how long deleted playlists can be restored before
+	// they are purged; 0 keeps them until purged by hand.
+	DeletedRetention time.Duration `yaml:"deleted_retention"`
+}
+
 // Addr returns the listen address string.
 func (c *Config) Addr() string {
 	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
@@ -56,12 +138,45 @@ func Load(path string) (*Config, error) {
 			Port: 8080,
 		},
 		Database: DatabaseConfig{
-			Path: "data/mms.db",
+			Driver:          "sqlite",
+			Path:            "data/mms.db",
+			ReadConnections: 4,
 		},
 		Transcode: TranscodeConfig{
 			CacheDir:   "data/cache/transcode",
 			FFmpegPath: "ffmpeg",
 		},
+		Artwork: ArtworkConfig{
+			CacheDir: "data/cache/artwork",
+		},
+		Organizer: OrganizerConfig{
+			Template:   "{albumartist}/{year} - {album}/{disc}-{track} {title}.{ext}",
+			OnConflict: "skip",
+		},
+		Inbox: InboxConfig{
+			QuarantineDir: "data/quarantine",
+			PollInterval:  30 * time.Second,
+		},
+		Integrity: IntegrityConfig{
+			Interval: 30 * 24 * time.Hour,
+		},
+		Backup: BackupConfig{
+			Directory:      "data/backups",
+			Interval:       24 * time.Hour,
+			Keep:           7,
+			IncludeArtwork: true,
+		},
+		Search: SearchConfig{
+			RelevanceWeight: 1,
+			PlayWeight:      0.3,
+			RatingWeight:    0.2,
+			RecencyWeight:   0.1,
+			ArtistWeight:    0.2,
+			RecencyHalfLife: 30 * 24 * time.Hour,
+		},
+		Playlists: PlaylistsConfig{
+			DeletedRetention: 30 * 24 * time.Hour,
+		},
 	}
 
 	if err := yaml.Unmarshal(data, cfg); err != nil {
@@ -72,6 +187,40 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("at least one music directory must be configured")
 	}
 
+	if cfg.Inbox.Library == "" {
+		cfg.Inbox.Library = cfg.Music.Directories[0]
+	}
+
+	switch cfg.Database.Driver {
+	case "sqlite":
+	case "postgres":
+		if cfg.Database.DSN == "" {
+			return nil, fmt.Errorf("database.dsn is required for the postgres driver")
+		}
+	default:
+		return nil, fmt.Errorf("database.driver must be \"sqlite\" or \"postgres\"")
+	}
+
+	if cfg.Organizer.OnConflict != "skip" && cfg.Organizer.OnConflict != "rename" {
+		return nil, fmt.Errorf("organizer.on_conflict must be \"skip\" or \"rename\"")
+	}
+
+	for name, w := range map[string]float64{
+		"relevance_weight": cfg.Search.RelevanceWeight,
+		"play_weight":      cfg.Search.PlayWeight,
+		"rating_weight":    cfg.Search.RatingWeight,
+		"recency_weight":   cfg.Search.RecencyWeight,
+		"artist_weight":    cfg.Search.ArtistWeight,
+	} {
+		if w < 0 {
+			return nil, fmt.Errorf("search.%s must not be negative", name)
+		}
+	}
+
+	if cfg.Playlists.DeletedRetention < 0 {
+		return nil, fmt.Errorf("playlists.deleted_retention must not be negative")
+	}
+
 	return cfg, nil
 }
 
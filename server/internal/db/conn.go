@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Supported database drivers (database.driver in config.yaml).
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// querier runs queries on a conn or inside a tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// conn wraps a connection pool so repository queries can be written once with
+// "?" placeholders and run on either driver.
+type conn struct {
+	*sql.DB
+	driver string
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.DB.ExecContext(ctx, rebind(c.driver, query), bindArgs(c.driver, args)...)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.DB.QueryContext(ctx, rebind(c.driver, query), bindArgs(c.driver, args)...)
+}
+
+func (c *conn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return c.DB.QueryRowContext(ctx, rebind(c.driver, query), bindArgs(c.driver, args)...)
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*tx, error) {
+	t, err := c.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{Tx: t, driver: c.driver}, nil
+}
+
+// tx is the transaction counterpart of conn.
+type tx struct {
+	*sql.Tx
+	driver string
+}
+
+func (t *tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.Tx.ExecContext(ctx, rebind(t.driver, query), bindArgs(t.driver, args)...)
+}
+
+func (t *tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.QueryContext(ctx, rebind(t.driver, query), bindArgs(t.driver, args)...)
+}
+
+func (t *tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return t.Tx.QueryRowContext(ctx, rebind(t.driver, query), bindArgs(t.driver, args)...)
+}
+
+// rebind rewrites "?" placeholders as "$1", "$2", ... for PostgreSQL,
+// leaving question marks inside quoted literals alone.
+func rebind(driver, query string) string {
+	if driver != DriverPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// bindArgs adapts argument types for the driver. Flag columns are INTEGER in
+// both schemas, so booleans are bound as 0/1 on PostgreSQL as they are on
+// SQLite.
+func bindArgs(driver string, args []any) []any {
+	if driver != DriverPostgres {
+		return args
+	}
+	for i, a := range args {
+		if v, ok := a.(bool); ok {
+			if v {
+				args[i] = 1
+			} else {
+				args[i] = 0
+			}
+		}
+	}
+	return args
+}
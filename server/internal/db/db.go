@@ -1,8 +1,12 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog/log"
@@ -24,7 +28,7 @@ func Open(path string) (*sql.DB, error) {
 		return nil, fmt.Errorf("ping database: %w", err)
 	}
 
-	// Connection pool settings for SQLite (single-writer)
+	// Single writer; reads go through the pool from OpenReader
 	db.SetMaxOpenConns(1)
 	db.SetMaxIdleConns(1)
 
@@ -32,120 +36,187 @@ func Open(path string) (*sql.DB, error) {
 	return db, nil
 }
 
-// Migrate runs all schema migrations.
-func Migrate(db *sql.DB) error {
-	for i, m := range migrations {
-		if _, err := db.Exec(m); err != nil {
-			return fmt.Errorf("migration %d failed: %w", i, err)
+// OpenReader creates a read-only connection pool on an existing database.
+// WAL mode lets these connections query while the writer from Open is busy.
+func OpenReader(path string, maxConns int) (*sql.DB, error) {
+	if maxConns <= 0 {
+		maxConns = 4
+	}
+	dsn := fmt.Sprintf("file:%s?mode=ro&_foreign_keys=on&_busy_timeout=5000", path)
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open read pool: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ping read pool: %w", err)
+	}
+
+	db.SetMaxOpenConns(maxConns)
+	db.SetMaxIdleConns(maxConns)
+
+	log.Info().Int("connections", maxConns).Msg("database read pool connected")
+	return db, nil
+}
+
+// migration is one numbered schema change.
+type migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+// MigrationState describes whether a schema version has been applied.
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+	// Known is false for versions recorded in the database but not present in
+	// this binary (the database was migrated by a newer release).
+	Known bool
+}
+
+// ErrSchemaTooNew is returned when the database has migrations this binary
+// does not know about.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// LatestVersion returns the schema version this binary migrates to. Both
+// drivers' migration lists carry the same versions.
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// migrationsFor returns the migration list for a driver.
+func migrationsFor(driver string) []migration {
+	if driver == DriverPostgres {
+		return postgresMigrations
+	}
+	return migrations
+}
+
+// Migrate applies every pending migration in order, each in its own
+// transaction. It refuses to touch a database whose schema is newer than
+// this binary.
+func Migrate(db *sql.DB, driver string) error {
+	c := &conn{DB: db, driver: driver}
+	if err := ensureMigrationTable(c); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(c)
+	if err != nil {
+		return err
+	}
+	for v := range applied {
+		if v > LatestVersion() {
+			return fmt.Errorf("%w: database at version %d, binary supports %d", ErrSchemaTooNew, v, LatestVersion())
+		}
+	}
+
+	count := 0
+	for _, m := range migrationsFor(driver) {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := applyMigration(c, m); err != nil {
+			return err
 		}
+		log.Info().Int("version", m.Version).Str("name", m.Name).Msg("database migration applied")
+		count++
 	}
-	log.Info().Int("count", len(migrations)).Msg("database migrations applied")
+	log.Info().Int("applied", count).Int("version", LatestVersion()).Msg("database schema up to date")
 	return nil
 }
 
-var migrations = []string{
-	// Artists table
-	`CREATE TABLE IF NOT EXISTS artists (
-		id TEXT PRIMARY KEY,
-		name TEXT NOT NULL,
-		sort_name TEXT NOT NULL,
-		image_path TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	)`,
-	`CREATE INDEX IF NOT EXISTS idx_artists_name ON artists(name)`,
-	`CREATE INDEX IF NOT EXISTS idx_artists_sort_name ON artists(sort_name)`,
-
-	// Albums table
-	`CREATE TABLE IF NOT EXISTS albums (
-		id TEXT PRIMARY KEY,
-		artist_id TEXT NOT NULL REFERENCES artists(id),
-		title TEXT NOT NULL,
-		sort_title TEXT NOT NULL,
-		year INTEGER,
-		genre TEXT,
-		cover_path TEXT,
-		track_count INTEGER DEFAULT 0,
-		disc_count INTEGER DEFAULT 1,
-		duration_seconds REAL DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	)`,
-	`CREATE INDEX IF NOT EXISTS idx_albums_artist_id ON albums(artist_id)`,
-	`CREATE INDEX IF NOT EXISTS idx_albums_title ON albums(title)`,
-	`CREATE INDEX IF NOT EXISTS idx_albums_year ON albums(year)`,
-	`CREATE INDEX IF NOT EXISTS idx_albums_created_at ON albums(created_at)`,
-
-	// Tracks table
-	`CREATE TABLE IF NOT EXISTS tracks (
-		id TEXT PRIMARY KEY,
-		album_id TEXT NOT NULL REFERENCES albums(id),
-		artist_id TEXT NOT NULL REFERENCES artists(id),
-		title TEXT NOT NULL,
-		track_number INTEGER,
-		disc_number INTEGER DEFAULT 1,
-		duration_seconds REAL NOT NULL,
-		file_path TEXT NOT NULL UNIQUE,
-		file_size INTEGER NOT NULL,
-		format TEXT NOT NULL DEFAULT 'flac',
-		sample_rate INTEGER,
-		bit_depth INTEGER,
-		channels INTEGER DEFAULT 2,
-		bitrate INTEGER,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	)`,
-	`CREATE INDEX IF NOT EXISTS idx_tracks_album_id ON tracks(album_id)`,
-	`CREATE INDEX IF NOT EXISTS idx_tracks_artist_id ON tracks(artist_id)`,
-	`CREATE INDEX IF NOT EXISTS idx_tracks_file_path ON tracks(file_path)`,
-
-	// Playlists table
-	`CREATE TABLE IF NOT EXISTS playlists (
-		id TEXT PRIMARY KEY,
+// MigrationStatus lists every migration known to this binary or recorded in
+// the database, ordered by version.
+func MigrationStatus(db *sql.DB, driver string) ([]MigrationState, error) {
+	c := &conn{DB: db, driver: driver}
+	if err := ensureMigrationTable(c); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []MigrationState
+	for _, m := range migrationsFor(driver) {
+		st := MigrationState{Version: m.Version, Name: m.Name, Known: true}
+		if rec, ok := applied[m.Version]; ok {
+			st.AppliedAt = &rec.appliedAt
+		}
+		states = append(states, st)
+	}
+	for v, rec := range applied {
+		if v > LatestVersion() {
+			states = append(states, MigrationState{Version: v, Name: rec.name, AppliedAt: &rec.appliedAt})
+		}
+	}
+	slices.SortFunc(states, func(a, b MigrationState) int { return a.Version - b.Version })
+	return states, nil
+}
+
+func ensureMigrationTable(c *conn) error {
+	timestamp := "DATETIME"
+	if c.driver == DriverPostgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+	_, err := c.ExecContext(context.Background(), `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
 		name TEXT NOT NULL,
-		description TEXT,
-		cover_path TEXT,
-		track_count INTEGER DEFAULT 0,
-		duration_seconds REAL DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	)`,
-
-	// Playlist tracks junction table
-	`CREATE TABLE IF NOT EXISTS playlist_tracks (
-		id TEXT PRIMARY KEY,
-		playlist_id TEXT NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
-		track_id TEXT NOT NULL REFERENCES tracks(id),
-		position INTEGER NOT NULL,
-		added_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	)`,
-	`CREATE INDEX IF NOT EXISTS idx_playlist_tracks_playlist ON playlist_tracks(playlist_id, position)`,
-
-	// Play history
-	`CREATE TABLE IF NOT EXISTS play_history (
-		id TEXT PRIMARY KEY,
-		track_id TEXT NOT NULL REFERENCES tracks(id),
-		played_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		duration_listened REAL
-	)`,
-	`CREATE INDEX IF NOT EXISTS idx_play_history_track ON play_history(track_id)`,
-	`CREATE INDEX IF NOT EXISTS idx_play_history_played_at ON play_history(played_at)`,
-
-	// FTS5 search index
-	`CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
-		entity_id UNINDEXED,
-		entity_type UNINDEXED,
-		title,
-		artist,
-		album,
-		content='',
-		tokenize='unicode61 remove_diacritics 2'
-	)`,
-
-	// System config
-	`CREATE TABLE IF NOT EXISTS system_config (
-		key TEXT PRIMARY KEY,
-		value TEXT NOT NULL,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	)`,
+		applied_at `+timestamp+` DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+type appliedMigration struct {
+	name      string
+	appliedAt time.Time
+}
+
+func appliedMigrations(c *conn) (map[int]appliedMigration, error) {
+	rows, err := c.QueryContext(context.Background(), `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var v int
+		var rec appliedMigration
+		if err := rows.Scan(&v, &rec.name, &rec.appliedAt); err != nil {
+			return nil, fmt.Errorf("scan schema_migrations: %w", err)
+		}
+		applied[v] = rec
+	}
+	return applied, rows.Err()
+}
+
+func applyMigration(c *conn, m migration) error {
+	ctx := context.Background()
+	tx, err := c.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("migration %d: begin: %w", m.Version, err)
+	}
+	defer tx.Rollback()
+
+	for i, stmt := range m.Statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %d (%s) statement %d failed: %w", m.Version, m.Name, i, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name,
+	); err != nil {
+		return fmt.Errorf("migration %d: record version: %w", m.Version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migration %d: commit: %w", m.Version, err)
+	}
+	return nil
 }
@@ -0,0 +1,418 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// Playlists can be filed in nestable folders. Folders and playlists each
+// have a manual position among their siblings; listings put a level's
+// folders before its playlists. Pinned playlists are additionally listed at
+// the top of the tree in the order they were pinned. Rearranging doesn't
+// touch a playlist's updated_at.
+
+// ErrFolderCycle is returned when moving a folder into itself or one of its
+// subfolders.
+var ErrFolderCycle = errors.New("folder cannot be moved into itself")
+
+// ErrInvalidFolder is returned for folder moves that would nest too deep.
+var ErrInvalidFolder = errors.New("invalid folder")
+
+// maxFolderDepth bounds folder nesting.
+const maxFolderDepth = 16
+
+// PlaylistTree is the playlist library as folders: pinned playlists, then
+// the top-level folders and playlists.
+type PlaylistTree struct {
+	Pinned    []*Playlist           `json:"pinned"`
+	Folders   []*PlaylistTreeFolder `json:"folders"`
+	Playlists []*Playlist           `json:"playlists"`
+}
+
+// PlaylistTreeFolder is a folder with its contents, in order.
+type PlaylistTreeFolder struct {
+	*PlaylistFolder
+	Folders   []*PlaylistTreeFolder `json:"folders"`
+	Playlists []*Playlist           `json:"playlists"`
+}
+
+// BuildPlaylistTree arranges folders and playlists by parent and position.
+// Playlists in a folder that no longer exists are listed at the top level.
+func BuildPlaylistTree(folders []*PlaylistFolder, playlists []*Playlist) *PlaylistTree {
+	tree := &PlaylistTree{Pinned: []*Playlist{}, Folders: []*PlaylistTreeFolder{}, Playlists: []*Playlist{}}
+	nodes := make(map[string]*PlaylistTreeFolder, len(folders))
+	for _, f := range folders {
+		nodes[f.ID] = &PlaylistTreeFolder{PlaylistFolder: f, Folders: []*PlaylistTreeFolder{}, Playlists: []*Playlist{}}
+	}
+
+	folders = slices.Clone(folders)
+	slices.SortStableFunc(folders, func(a, b *PlaylistFolder) int { return a.Position - b.Position })
+	for _, f := range folders {
+		if parent, ok := nodes[deref(f.ParentID)]; ok {
+			parent.Folders = append(parent.Folders, nodes[f.ID])
+		} else {
+			tree.Folders = append(tree.Folders, nodes[f.ID])
+		}
+	}
+
+	playlists = slices.Clone(playlists)
+	slices.SortStableFunc(playlists, func(a, b *Playlist) int { return a.Position - b.Position })
+	for _, p := range playlists {
+		if folder, ok := nodes[deref(p.FolderID)]; ok {
+			folder.Playlists = append(folder.Playlists, p)
+		} else {
+			tree.Playlists = append(tree.Playlists, p)
+		}
+		if p.PinnedAt != nil {
+			tree.Pinned = append(tree.Pinned, p)
+		}
+	}
+	slices.SortStableFunc(tree.Pinned, func(a, b *Playlist) int { return a.PinnedAt.Compare(*b.PinnedAt) })
+	return tree
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// folderColumns are the columns scanFolder reads.
+const folderColumns = `id, name, parent_id, position, created_at, updated_at`
+
+func scanFolder(row interface{ Scan(...any) error }) (*PlaylistFolder, error) {
+	f := &PlaylistFolder{}
+	if err := row.Scan(&f.ID, &f.Name, &f.ParentID, &f.Position, &f.CreatedAt, &f.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// CreatePlaylistFolder creates a folder at the end of its parent, or of the
+// top level when parentID is nil.
+func (r *Repository) CreatePlaylistFolder(ctx context.Context, name string, parentID *string) (*PlaylistFolder, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin create folder: %w", err)
+	}
+	defer tx.Rollback()
+
+	if parentID != nil {
+		if err := requireRow(ctx, tx, "playlist_folders", *parentID); err != nil {
+			return nil, fmt.Errorf("folder %s: %w", *parentID, err)
+		}
+		ancestors, err := folderAncestors(ctx, tx, *parentID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkFolderDepth(ancestors, 1); err != nil {
+			return nil, err
+		}
+	}
+	id := uuid.New().String()
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO playlist_folders (id, name, parent_id, position)
+		 VALUES (?, ?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM playlist_folders WHERE COALESCE(parent_id, '') = COALESCE(?, '')))`,
+		id, name, parentID, parentID,
+	); err != nil {
+		return nil, fmt.Errorf("create folder: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit create folder: %w", err)
+	}
+	return r.GetPlaylistFolder(ctx, id)
+}
+
+// GetPlaylistFolder retrieves a folder, or ErrNotFound.
+func (r *Repository) GetPlaylistFolder(ctx context.Context, id string) (*PlaylistFolder, error) {
+	f, err := scanFolder(r.rdb.QueryRowContext(ctx,
+		`SELECT `+folderColumns+` FROM playlist_folders WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("folder %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get folder %s: %w", id, err)
+	}
+	return f, nil
+}
+
+// ListPlaylistFolders returns every folder, each level in position order.
+func (r *Repository) ListPlaylistFolders(ctx context.Context) ([]*PlaylistFolder, error) {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT `+folderColumns+` FROM playlist_folders ORDER BY COALESCE(parent_id, ''), position, name`)
+	if err != nil {
+		return nil, fmt.Errorf("list folders: %w", err)
+	}
+	defer rows.Close()
+
+	folders := []*PlaylistFolder{}
+	for rows.Next() {
+		f, err := scanFolder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan folder: %w", err)
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
+
+// RenamePlaylistFolder changes a folder's name.
+func (r *Repository) RenamePlaylistFolder(ctx context.Context, id, name string) (*PlaylistFolder, error) {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE playlist_folders SET name = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, name, id)
+	if err != nil {
+		return nil, fmt.Errorf("rename folder: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("folder %s: %w", id, ErrNotFound)
+	}
+	return r.GetPlaylistFolder(ctx, id)
+}
+
+// DeletePlaylistFolder removes a folder. Its subfolders and playlists move
+// up to its parent, after the parent's own contents; nothing is deleted
+// with it.
+func (r *Repository) DeletePlaylistFolder(ctx context.Context, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin delete folder: %w", err)
+	}
+	defer tx.Rollback()
+
+	var parentID *string
+	if err := tx.QueryRowContext(ctx,
+		`SELECT parent_id FROM playlist_folders WHERE id = ?`, id,
+	).Scan(&parentID); errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("folder %s: %w", id, ErrNotFound)
+	} else if err != nil {
+		return fmt.Errorf("get folder %s: %w", id, err)
+	}
+
+	for _, table := range []string{"playlist_folders", "playlists"} {
+		parentCol := folderParentColumn(table)
+		moved, err := siblingOrder(ctx, tx, table, &id)
+		if err != nil {
+			return err
+		}
+		order, err := siblingOrder(ctx, tx, table, parentID)
+		if err != nil {
+			return err
+		}
+		order = slices.DeleteFunc(order, func(s string) bool { return s == id })
+		for _, m := range moved {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE `+table+` SET `+parentCol+` = ? WHERE id = ?`, parentID, m,
+			); err != nil {
+				return fmt.Errorf("move out of folder: %w", err)
+			}
+		}
+		if err := renumberSiblings(ctx, tx, table, append(order, moved...)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM playlist_folders WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("delete folder: %w", err)
+	}
+	return tx.Commit()
+}
+
+// MovePlaylistFolder moves a folder into another folder, or to the top
+// level when parentID is nil, at a 1-based position among its new siblings;
+// 0 puts it last.
+func (r *Repository) MovePlaylistFolder(ctx context.Context, id string, parentID *string, position int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin move folder: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := requireRow(ctx, tx, "playlist_folders", id); err != nil {
+		return fmt.Errorf("folder %s: %w", id, err)
+	}
+	if parentID != nil {
+		if err := requireRow(ctx, tx, "playlist_folders", *parentID); err != nil {
+			return fmt.Errorf("folder %s: %w", *parentID, err)
+		}
+		ancestors, err := folderAncestors(ctx, tx, *parentID)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(ancestors, id) {
+			return ErrFolderCycle
+		}
+		height, err := folderHeight(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkFolderDepth(ancestors, height); err != nil {
+			return err
+		}
+	}
+	if err := moveSibling(ctx, tx, "playlist_folders", id, parentID, position); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MovePlaylist files a playlist in a folder, or at the top level when
+// folderID is nil, at a 1-based position among the playlists there; 0 puts
+// it last.
+func (r *Repository) MovePlaylist(ctx context.Context, id string, folderID *string, position int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin move playlist: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := requirePlaylist(ctx, tx, id); err != nil {
+		return fmt.Errorf("playlist %s: %w", id, err)
+	}
+	if folderID != nil {
+		if err := requireRow(ctx, tx, "playlist_folders", *folderID); err != nil {
+			return fmt.Errorf("folder %s: %w", *folderID, err)
+		}
+	}
+	if err := moveSibling(ctx, tx, "playlists", id, folderID, position); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PinPlaylist pins or unpins a playlist. Pinning an already pinned playlist
+// keeps its place among the pinned.
+func (r *Repository) PinPlaylist(ctx context.Context, id string, pinned bool) error {
+	query := `UPDATE playlists SET pinned_at = COALESCE(pinned_at, CURRENT_TIMESTAMP) WHERE id = ? AND deleted_at IS NULL`
+	if !pinned {
+		query = `UPDATE playlists SET pinned_at = NULL WHERE id = ? AND deleted_at IS NULL`
+	}
+	res, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("pin playlist: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("playlist %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// folderParentColumn is the column holding a row's folder in table.
+func folderParentColumn(table string) string {
+	if table == "playlists" {
+		return "folder_id"
+	}
+	return "parent_id"
+}
+
+// siblingOrder returns the IDs of the folders or playlists in a folder, or
+// at the top level for nil, in position order.
+func siblingOrder(ctx context.Context, t *tx, table string, parentID *string) ([]string, error) {
+	ids, err := queryIDs(ctx, t,
+		`SELECT id FROM `+table+` WHERE COALESCE(`+folderParentColumn(table)+`, '') = COALESCE(?, '')
+		 ORDER BY position, created_at, id`, parentID)
+	if err != nil {
+		return nil, fmt.Errorf("list folder contents: %w", err)
+	}
+	return ids, nil
+}
+
+// moveSibling moves a folder or playlist to a position in a folder,
+// renumbering the siblings it leaves and joins.
+func moveSibling(ctx context.Context, t *tx, table, id string, parentID *string, position int) error {
+	parentCol := folderParentColumn(table)
+	var from *string
+	if err := t.QueryRowContext(ctx,
+		`SELECT `+parentCol+` FROM `+table+` WHERE id = ?`, id,
+	).Scan(&from); err != nil {
+		return err
+	}
+	if deref(from) != deref(parentID) {
+		old, err := siblingOrder(ctx, t, table, from)
+		if err != nil {
+			return err
+		}
+		if err := renumberSiblings(ctx, t, table, slices.DeleteFunc(old, func(s string) bool { return s == id })); err != nil {
+			return err
+		}
+		if _, err := t.ExecContext(ctx,
+			`UPDATE `+table+` SET `+parentCol+` = ? WHERE id = ?`, parentID, id,
+		); err != nil {
+			return fmt.Errorf("move to folder: %w", err)
+		}
+	}
+
+	order, err := siblingOrder(ctx, t, table, parentID)
+	if err != nil {
+		return err
+	}
+	order = slices.DeleteFunc(order, func(s string) bool { return s == id })
+	to := len(order)
+	if position > 0 {
+		to = min(position, len(order)+1) - 1
+	}
+	return renumberSiblings(ctx, t, table, slices.Insert(order, to, id))
+}
+
+// renumberSiblings sets positions to 1..n in the given order.
+func renumberSiblings(ctx context.Context, t *tx, table string, order []string) error {
+	for i, id := range order {
+		if _, err := t.ExecContext(ctx,
+			`UPDATE `+table+` SET position = ? WHERE id = ? AND position <> ?`, i+1, id, i+1,
+		); err != nil {
+			return fmt.Errorf("renumber folder contents: %w", err)
+		}
+	}
+	return nil
+}
+
+// folderAncestors returns a folder's ID followed by its parents' up to the
+// top level.
+func folderAncestors(ctx context.Context, t *tx, id string) ([]string, error) {
+	chain := []string{id}
+	for len(chain) <= maxFolderDepth {
+		var parentID *string
+		if err := t.QueryRowContext(ctx,
+			`SELECT parent_id FROM playlist_folders WHERE id = ?`, chain[len(chain)-1],
+		).Scan(&parentID); err != nil {
+			return nil, fmt.Errorf("folder parents: %w", err)
+		}
+		if parentID == nil {
+			break
+		}
+		chain = append(chain, *parentID)
+	}
+	return chain, nil
+}
+
+// folderHeight returns how many levels a folder and its subfolders span.
+func folderHeight(ctx context.Context, t *tx, id string) (int, error) {
+	level := []string{id}
+	height := 0
+	for len(level) > 0 && height <= maxFolderDepth {
+		height++
+		var next []string
+		for _, f := range level {
+			ids, err := queryIDs(ctx, t, `SELECT id FROM playlist_folders WHERE parent_id = ?`, f)
+			if err != nil {
+				return 0, fmt.Errorf("list subfolders: %w", err)
+			}
+			next = append(next, ids...)
+		}
+		level = next
+	}
+	return height, nil
+}
+
+// checkFolderDepth returns ErrInvalidFolder if adding levels below a folder
+// with the given ancestors would nest deeper than maxFolderDepth.
+func checkFolderDepth(ancestors []string, levels int) error {
+	if len(ancestors)+levels > maxFolderDepth {
+		return fmt.Errorf("%w: folders nest at most %d deep", ErrInvalidFolder, maxFolderDepth)
+	}
+	return nil
+}
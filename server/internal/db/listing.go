@@ -0,0 +1,472 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Sort keys accepted by the artist, album and track listings.
+const (
+	SortTitle     = "title"
+	SortArtist    = "artist"
+	SortYear      = "year"
+	SortAdded     = "added"
+	SortDuration  = "duration"
+	SortPlayCount = "play_count"
+	SortRandom    = "random"
+)
+
+// ErrInvalidListOption is returned for sort keys or orders a listing does not
+// support.
+var ErrInvalidListOption = errors.New("invalid list option")
+
+// hiResCondition matches tracks above CD quality.
+const hiResCondition = `(%[1]s.bit_depth > 16 OR %[1]s.sample_rate > 48000)`
+
+// ListOptions controls paging, ordering and filtering of the artist, album
+// and track listings. Zero values mean "no filter".
+type ListOptions struct {
+	Limit  int
+	Offset int    // ignored when Cursor is set
+	Cursor string // Page.Next or Page.Prev from an earlier call
+
+	Sort  string // one of the Sort* keys; empty for the listing's default
+	Order string // "asc" or "desc"; empty for the sort key's default
+	Seed  int64  // shuffle seed for SortRandom, so pages stay consistent
+
+	HideDuplicates bool // albums and tracks only
+
+	// Album-level filters
+	Genre    string
+	YearFrom int
+	YearTo   int
+	Decade   int // first year of the decade, e.g. 1990
+
+	// Track-level filters; artists and albums match when any track does
+	Format        string
+	MinSampleRate int
+	MinBitDepth   int
+	HiRes         bool
+
+	AddedSince time.Time
+
+	cursor *cursor // decoded Cursor
+}
+
+// Page describes where a page of a listing sits. Total is -1 for pages
+// fetched by cursor, since counting the whole listing is what cursors avoid.
+// Next and Prev are empty at either end.
+type Page struct {
+	Total int64
+	Next  string
+	Prev  string
+}
+
+// cursor is the position a Page.Next or Page.Prev cursor encodes: the sort
+// key and ID of the row to continue from, or the offset for random order.
+// It is tied to the sort key and order it was issued for.
+type cursor struct {
+	Sort   string `json:"s"`
+	Order  string `json:"o"`
+	Value  any    `json:"v,omitempty"`
+	ID     string `json:"i,omitempty"`
+	Back   bool   `json:"b,omitempty"` // rows before the position, not after
+	Seed   int64  `json:"r,omitempty"`
+	Offset int    `json:"n,omitempty"`
+}
+
+func (c *cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOption)
+	}
+	c := &cursor{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOption)
+	}
+	return c, nil
+}
+
+// listSpec describes one listing: the SQL expression behind each sort key
+// and which table aliases the filters apply to.
+type listSpec struct {
+	name        string
+	from        string // FROM clause with every alias sorts and filters use
+	sorts       map[string]string
+	defaultSort string
+	id          string // ID column, used as the final tie-breaker
+	created     string // created_at column for AddedSince
+}
+
+var artistList = listSpec{
+	name: "artists",
+	from: "FROM artists a",
+	sorts: map[string]string{
+		SortTitle:     "a.sort_name",
+		SortArtist:    "a.sort_name",
+		SortYear:      "COALESCE((SELECT MIN(year) FROM albums WHERE artist_id = a.id), 0)",
+		SortAdded:     "a.created_at",
+		SortDuration:  "(SELECT COALESCE(SUM(duration_seconds), 0) FROM tracks WHERE artist_id = a.id)",
+		SortPlayCount: "(SELECT COUNT(*) FROM play_history ph JOIN tracks pt ON pt.id = ph.track_id WHERE pt.artist_id = a.id)",
+	},
+	defaultSort: SortTitle,
+	id:          "a.id",
+	created:     "a.created_at",
+}
+
+var albumList = listSpec{
+	name: "albums",
+	from: "FROM albums al JOIN artists ar ON ar.id = al.artist_id",
+	sorts: map[string]string{
+		SortTitle:     "al.sort_title",
+		SortArtist:    "ar.sort_name",
+		SortYear:      "COALESCE(al.year, 0)",
+		SortAdded:     "al.created_at",
+		SortDuration:  "al.duration_seconds",
+		SortPlayCount: "(SELECT COUNT(*) FROM play_history ph JOIN tracks pt ON pt.id = ph.track_id WHERE pt.album_id = al.id)",
+	},
+	defaultSort: SortTitle,
+	id:          "al.id",
+	created:     "al.created_at",
+}
+
+var trackList = listSpec{
+	name: "tracks",
+	from: `FROM tracks t
+		 JOIN artists ar ON ar.id = t.artist_id
+		 JOIN albums al ON al.id = t.album_id`,
+	sorts: map[string]string{
+		SortTitle:     "t.title",
+		SortArtist:    "ar.sort_name",
+		SortYear:      "COALESCE(al.year, 0)",
+		SortAdded:     "t.created_at",
+		SortDuration:  "t.duration_seconds",
+		SortPlayCount: "(SELECT COUNT(*) FROM play_history ph WHERE ph.track_id = t.id)",
+	},
+	defaultSort: SortTitle,
+	id:          "t.id",
+	created:     "t.created_at",
+}
+
+// normalize fills in defaults and rejects unsupported sort keys and orders.
+func (o *ListOptions) normalize(spec listSpec) error {
+	if o.Limit <= 0 || o.Limit > 200 {
+		o.Limit = 50
+	}
+	if o.Offset < 0 {
+		o.Offset = 0
+	}
+	if o.Cursor != "" {
+		c, err := decodeCursor(o.Cursor)
+		if err != nil {
+			return err
+		}
+		// A cursor carries its own sort; an explicit, different one is a
+		// client mixing up listings
+		if (o.Sort != "" && o.Sort != c.Sort) || (o.Order != "" && o.Order != c.Order) {
+			return fmt.Errorf("%w: cursor was issued for sort=%s order=%s", ErrInvalidListOption, c.Sort, c.Order)
+		}
+		o.Sort, o.Order, o.cursor = c.Sort, c.Order, c
+		if c.Sort == SortRandom {
+			o.Seed, o.Offset = c.Seed, c.Offset
+		}
+	}
+	if o.Sort == "" {
+		o.Sort = spec.defaultSort
+	}
+	if _, ok := spec.sorts[o.Sort]; !ok && o.Sort != SortRandom {
+		return fmt.Errorf("%w: %s cannot be sorted by %q", ErrInvalidListOption, spec.name, o.Sort)
+	}
+	switch o.Order {
+	case "":
+		// Newest and most played first unless asked otherwise
+		o.Order = "asc"
+		if o.Sort == SortAdded || o.Sort == SortPlayCount {
+			o.Order = "desc"
+		}
+	case "asc", "desc":
+	default:
+		return fmt.Errorf("%w: order must be asc or desc", ErrInvalidListOption)
+	}
+	return nil
+}
+
+// orderBy returns the ORDER BY clause for the chosen sort key, flipped when
+// reading backwards from a cursor. The ID breaks ties so paging is stable.
+func (o *ListOptions) orderBy(spec listSpec, back bool) string {
+	dir := "ASC"
+	if (o.Order == "desc") != back {
+		dir = "DESC"
+	}
+	return "ORDER BY " + spec.sorts[o.Sort] + " " + dir + ", " + spec.id + " " + dir
+}
+
+// albumConditions returns the album-level filters against alias.
+func (o *ListOptions) albumConditions(alias string) ([]string, []any) {
+	var conds []string
+	var args []any
+	if o.Genre != "" {
+		conds = append(conds, "LOWER("+alias+".genre) = LOWER(?)")
+		args = append(args, o.Genre)
+	}
+	if o.YearFrom > 0 {
+		conds = append(conds, alias+".year >= ?")
+		args = append(args, o.YearFrom)
+	}
+	if o.YearTo > 0 {
+		conds = append(conds, alias+".year <= ?")
+		args = append(args, o.YearTo)
+	}
+	if o.Decade > 0 {
+		conds = append(conds, alias+".year BETWEEN ? AND ?")
+		args = append(args, o.Decade, o.Decade+9)
+	}
+	return conds, args
+}
+
+// trackConditions returns the track-level filters against alias.
+func (o *ListOptions) trackConditions(alias string) ([]string, []any) {
+	var conds []string
+	var args []any
+	if o.Format != "" {
+		conds = append(conds, "LOWER("+alias+".format) = LOWER(?)")
+		args = append(args, o.Format)
+	}
+	if o.MinSampleRate > 0 {
+		conds = append(conds, alias+".sample_rate >= ?")
+		args = append(args, o.MinSampleRate)
+	}
+	if o.MinBitDepth > 0 {
+		conds = append(conds, alias+".bit_depth >= ?")
+		args = append(args, o.MinBitDepth)
+	}
+	if o.HiRes {
+		conds = append(conds, fmt.Sprintf(hiResCondition, alias))
+	}
+	return conds, args
+}
+
+// where builds the WHERE clause for a listing. Artists match through any of
+// their tracks, albums through any of theirs for track-level filters.
+func (r *Repository) where(spec listSpec, o *ListOptions) (string, []any) {
+	var conds []string
+	var args []any
+
+	albumConds, albumArgs := o.albumConditions("fa")
+	trackConds, trackArgs := o.trackConditions("ft")
+	switch spec.name {
+	case artistList.name:
+		if len(albumConds)+len(trackConds) > 0 {
+			inner := append(albumConds, trackConds...)
+			conds = append(conds, `EXISTS (SELECT 1 FROM tracks ft JOIN albums fa ON fa.id = ft.album_id
+				WHERE ft.artist_id = a.id AND `+strings.Join(inner, " AND ")+`)`)
+			args = append(append(args, albumArgs...), trackArgs...)
+		}
+	case albumList.name:
+		c, a := o.albumConditions("al")
+		conds, args = append(conds, c...), append(args, a...)
+		if len(trackConds) > 0 {
+			conds = append(conds, `EXISTS (SELECT 1 FROM tracks ft
+				WHERE ft.album_id = al.id AND `+strings.Join(trackConds, " AND ")+`)`)
+			args = append(args, trackArgs...)
+		}
+		if o.HideDuplicates {
+			conds = append(conds, notDuplicateAlbum)
+		}
+	case trackList.name:
+		c, a := o.albumConditions("al")
+		conds, args = append(conds, c...), append(args, a...)
+		c, a = o.trackConditions("t")
+		conds, args = append(conds, c...), append(args, a...)
+		if o.HideDuplicates {
+			conds = append(conds, notDuplicateTrack)
+		}
+	}
+
+	if !o.AddedSince.IsZero() {
+		conds = append(conds, spec.created+" >= ?")
+		args = append(args, r.timeArg(o.AddedSince))
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+// pageIDs returns the IDs of one page of a listing, in order, and where the
+// page sits. Callers fetch the full rows with an IN clause.
+func (r *Repository) pageIDs(ctx context.Context, spec listSpec, o *ListOptions) ([]string, *Page, error) {
+	where, args := r.where(spec, o)
+	if o.Sort == SortRandom {
+		return r.shuffledPage(ctx, `SELECT `+spec.id+` `+spec.from+` `+where, args, o)
+	}
+
+	page := &Page{Total: -1}
+	c := o.cursor
+	if c == nil {
+		if err := r.rdb.QueryRowContext(ctx, `SELECT COUNT(*) `+spec.from+` `+where, args...).Scan(&page.Total); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Keyset condition: rows after (or, going back, before) the cursor row
+	// in sort order
+	back := c != nil && c.Back
+	paging := "LIMIT ? OFFSET ?"
+	pageArgs := append(slices.Clone(args), o.Limit+1, o.Offset)
+	if c != nil {
+		op := ">"
+		if (o.Order == "desc") != back {
+			op = "<"
+		}
+		where = withCondition(where, "("+spec.sorts[o.Sort]+", "+spec.id+") "+op+" (?, ?)")
+		pageArgs = append(slices.Clone(args), c.Value, c.ID, o.Limit+1)
+		paging = "LIMIT ?"
+	}
+
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT `+spec.id+`, `+spec.sorts[o.Sort]+` `+spec.from+` `+where+` `+o.orderBy(spec, back)+` `+paging,
+		pageArgs...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	var keys []any
+	for rows.Next() {
+		var id string
+		var key any
+		if err := rows.Scan(&id, &key); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		keys = append(keys, r.cursorValue(key))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// The extra row only tells whether there is more in this direction
+	more := len(ids) > o.Limit
+	if more {
+		ids, keys = ids[:o.Limit], keys[:o.Limit]
+	}
+	if back {
+		slices.Reverse(ids)
+		slices.Reverse(keys)
+	}
+	if len(ids) == 0 {
+		return ids, page, nil
+	}
+
+	at := func(i int, back bool) string {
+		return (&cursor{Sort: o.Sort, Order: o.Order, Value: keys[i], ID: ids[i], Back: back}).encode()
+	}
+	last := len(ids) - 1
+	if back {
+		page.Next = at(last, false)
+		if more {
+			page.Prev = at(0, true)
+		}
+	} else {
+		if more {
+			page.Next = at(last, false)
+		}
+		if c != nil || o.Offset > 0 {
+			page.Prev = at(0, true)
+		}
+	}
+	return ids, page, nil
+}
+
+// cursorValue converts a scanned sort key to a value that compares equal to
+// the column when bound back into a query.
+func (r *Repository) cursorValue(v any) any {
+	switch v := v.(type) {
+	case time.Time:
+		return r.timeArg(v)
+	case []byte:
+		return string(v)
+	}
+	return v
+}
+
+// shuffledPage runs idQuery, shuffles the IDs with the options' seed and
+// returns the requested page of them. The same seed always gives the same
+// order, so clients can page through a random listing.
+func (r *Repository) shuffledPage(ctx context.Context, idQuery string, args []any, o *ListOptions) ([]string, *Page, error) {
+	rows, err := r.rdb.QueryContext(ctx, idQuery, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// IDs come back in table order; sort first so the shuffle depends only
+	// on the seed and the set of IDs
+	slices.Sort(ids)
+	rng := rand.New(rand.NewPCG(uint64(o.Seed), 0x6d6d73))
+	rng.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+
+	page := &Page{Total: int64(len(ids))}
+	start := min(o.Offset, len(ids))
+	end := min(start+o.Limit, len(ids))
+	if end < len(ids) {
+		page.Next = (&cursor{Sort: SortRandom, Order: o.Order, Seed: o.Seed, Offset: end}).encode()
+	}
+	if start > 0 {
+		page.Prev = (&cursor{Sort: SortRandom, Order: o.Order, Seed: o.Seed, Offset: max(start-o.Limit, 0)}).encode()
+	}
+	return ids[start:end], page, nil
+}
+
+// inClause returns "(?, ?, ...)" and the matching args for ids.
+func inClause(ids []string) (string, []any) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + ")", args
+}
+
+// withCondition adds cond to a WHERE clause built by where.
+func withCondition(where, cond string) string {
+	if where == "" {
+		return "WHERE " + cond
+	}
+	return where + " AND " + cond
+}
+
+// sortByIDs puts items fetched with an IN clause back in the order of ids.
+func sortByIDs[T any](items []T, ids []string, id func(T) string) {
+	pos := make(map[string]int, len(ids))
+	for i, v := range ids {
+		pos[v] = i
+	}
+	slices.SortFunc(items, func(a, b T) int { return pos[id(a)] - pos[id(b)] })
+}
@@ -0,0 +1,425 @@
+package db
+
+// migrations is the ordered list of schema changes. Each entry runs once, in
+// its own transaction, and is recorded in schema_migrations. Never edit or
+// reorder an entry that has shipped; append a new version instead.
+//
+// The early versions use IF NOT EXISTS because databases created before
+// schema_migrations existed already contain their tables.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "baseline schema",
+		Statements: []string{
+			// Artists table
+			`CREATE TABLE IF NOT EXISTS artists (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				sort_name TEXT NOT NULL,
+				image_path TEXT,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_artists_name ON artists(name)`,
+			`CREATE INDEX IF NOT EXISTS idx_artists_sort_name ON artists(sort_name)`,
+
+			// Albums table
+			`CREATE TABLE IF NOT EXISTS albums (
+				id TEXT PRIMARY KEY,
+				artist_id TEXT NOT NULL REFERENCES artists(id),
+				title TEXT NOT NULL,
+				sort_title TEXT NOT NULL,
+				year INTEGER,
+				genre TEXT,
+				cover_path TEXT,
+				track_count INTEGER DEFAULT 0,
+				disc_count INTEGER DEFAULT 1,
+				duration_seconds REAL DEFAULT 0,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_albums_artist_id ON albums(artist_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_albums_title ON albums(title)`,
+			`CREATE INDEX IF NOT EXISTS idx_albums_year ON albums(year)`,
+			`CREATE INDEX IF NOT EXISTS idx_albums_created_at ON albums(created_at)`,
+
+			// Tracks table
+			`CREATE TABLE IF NOT EXISTS tracks (
+				id TEXT PRIMARY KEY,
+				album_id TEXT NOT NULL REFERENCES albums(id),
+				artist_id TEXT NOT NULL REFERENCES artists(id),
+				title TEXT NOT NULL,
+				track_number INTEGER,
+				disc_number INTEGER DEFAULT 1,
+				duration_seconds REAL NOT NULL,
+				file_path TEXT NOT NULL UNIQUE,
+				file_size INTEGER NOT NULL,
+				format TEXT NOT NULL DEFAULT 'flac',
+				sample_rate INTEGER,
+				bit_depth INTEGER,
+				channels INTEGER DEFAULT 2,
+				bitrate INTEGER,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_tracks_album_id ON tracks(album_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_tracks_artist_id ON tracks(artist_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_tracks_file_path ON tracks(file_path)`,
+
+			// Playlists table
+			`CREATE TABLE IF NOT EXISTS playlists (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				description TEXT,
+				cover_path TEXT,
+				track_count INTEGER DEFAULT 0,
+				duration_seconds REAL DEFAULT 0,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+
+			// Playlist tracks junction table
+			`CREATE TABLE IF NOT EXISTS playlist_tracks (
+				id TEXT PRIMARY KEY,
+				playlist_id TEXT NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+				track_id TEXT NOT NULL REFERENCES tracks(id),
+				position INTEGER NOT NULL,
+				added_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_playlist_tracks_playlist ON playlist_tracks(playlist_id, position)`,
+
+			// Play history
+			`CREATE TABLE IF NOT EXISTS play_history (
+				id TEXT PRIMARY KEY,
+				track_id TEXT NOT NULL REFERENCES tracks(id),
+				played_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				duration_listened REAL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_play_history_track ON play_history(track_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_play_history_played_at ON play_history(played_at)`,
+
+			// FTS5 search index
+			`CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+				entity_id UNINDEXED,
+				entity_type UNINDEXED,
+				title,
+				artist,
+				album,
+				content='',
+				tokenize='unicode61 remove_diacritics 2'
+			)`,
+
+			// System config
+			`CREATE TABLE IF NOT EXISTS system_config (
+				key TEXT PRIMARY KEY,
+				value TEXT NOT NULL,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "import history",
+		Statements: []string{
+			// Import inbox history
+			`CREATE TABLE IF NOT EXISTS import_history (
+				id TEXT PRIMARY KEY,
+				source TEXT NOT NULL,
+				file_name TEXT NOT NULL,
+				status TEXT NOT NULL,
+				reason TEXT,
+				dest_path TEXT,
+				imported_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_import_history_imported_at ON import_history(imported_at)`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "track integrity",
+		Statements: []string{
+			// Audio integrity verification results
+			`CREATE TABLE IF NOT EXISTS track_integrity (
+				track_id TEXT PRIMARY KEY REFERENCES tracks(id) ON DELETE CASCADE,
+				status TEXT NOT NULL,
+				detail TEXT,
+				checked_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_track_integrity_status ON track_integrity(status)`,
+			`CREATE INDEX IF NOT EXISTS idx_track_integrity_checked_at ON track_integrity(checked_at)`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "duplicate detection",
+		Statements: []string{
+			// External identifiers and audio fingerprints per track
+			`CREATE TABLE IF NOT EXISTS track_identifiers (
+				track_id TEXT PRIMARY KEY REFERENCES tracks(id) ON DELETE CASCADE,
+				mb_recording_id TEXT,
+				fingerprint TEXT,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_track_identifiers_mb ON track_identifiers(mb_recording_id)`,
+
+			// Duplicate detection results
+			`CREATE TABLE IF NOT EXISTS duplicate_groups (
+				id TEXT PRIMARY KEY,
+				kind TEXT NOT NULL,
+				reason TEXT NOT NULL,
+				preferred_id TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS duplicate_members (
+				group_id TEXT NOT NULL REFERENCES duplicate_groups(id) ON DELETE CASCADE,
+				entity_id TEXT NOT NULL,
+				preferred INTEGER NOT NULL DEFAULT 0,
+				quality_score REAL NOT NULL DEFAULT 0,
+				PRIMARY KEY (group_id, entity_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_duplicate_members_entity ON duplicate_members(entity_id, preferred)`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "tag fallbacks",
+		Statements: []string{
+			// Tag fallbacks applied by the scanner, for the library health report
+			`CREATE TABLE IF NOT EXISTS track_tag_fallbacks (
+				track_id TEXT PRIMARY KEY REFERENCES tracks(id) ON DELETE CASCADE,
+				title_from_filename INTEGER NOT NULL DEFAULT 0,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "track ratings",
+		Statements: []string{
+			`CREATE TABLE track_ratings (
+				track_id TEXT PRIMARY KEY REFERENCES tracks(id) ON DELETE CASCADE,
+				rating INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+				rated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "search index content",
+		Statements: []string{
+			// The original index was contentless (content=''), so entity_id
+			// and the text columns read back as NULL and rows could not be
+			// deleted. Rebuild it storing its content. Artists and albums
+			// repeat their name in the artist/album column so field
+			// qualifiers such as artist: match them as well as their tracks.
+			`DROP TABLE IF EXISTS search_index`,
+			`CREATE VIRTUAL TABLE search_index USING fts5(
+				entity_id UNINDEXED,
+				entity_type UNINDEXED,
+				title,
+				artist,
+				album,
+				tokenize='unicode61 remove_diacritics 2'
+			)`,
+			`INSERT INTO search_index (entity_id, entity_type, title, artist, album)
+				SELECT id, 'artist', name, name, '' FROM artists`,
+			`INSERT INTO search_index (entity_id, entity_type, title, artist, album)
+				SELECT al.id, 'album', al.title, ar.name, al.title
+				FROM albums al JOIN artists ar ON ar.id = al.artist_id`,
+			`INSERT INTO search_index (entity_id, entity_type, title, artist, album)
+				SELECT t.id, 'track', t.title, ar.name, al.title
+				FROM tracks t
+				JOIN artists ar ON ar.id = t.artist_id
+				JOIN albums al ON al.id = t.album_id`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "search vocabulary",
+		Statements: []string{
+			// Indexed words with their document counts, for spelling
+			// correction
+			`CREATE VIRTUAL TABLE search_terms USING fts5vocab(search_index, row)`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "search index triggers",
+		Statements: []string{
+			// search_index becomes an external-content index over
+			// search_entries, which has a key to update and delete by;
+			// FTS5 can only find its own rows by rowid. Triggers on the
+			// catalog keep the entries in step, so rescans no longer pile
+			// up duplicates and removed entities drop out.
+			`DROP TABLE IF EXISTS search_terms`,
+			`DROP TABLE IF EXISTS search_index`,
+			`CREATE TABLE search_entries (
+				id INTEGER PRIMARY KEY,
+				entity_id TEXT NOT NULL,
+				entity_type TEXT NOT NULL,
+				title TEXT NOT NULL,
+				artist TEXT NOT NULL,
+				album TEXT NOT NULL,
+				UNIQUE (entity_type, entity_id)
+			)`,
+			`CREATE VIRTUAL TABLE search_index USING fts5(
+				entity_id UNINDEXED,
+				entity_type UNINDEXED,
+				title,
+				artist,
+				album,
+				content='search_entries',
+				content_rowid='id',
+				tokenize='unicode61 remove_diacritics 2'
+			)`,
+			`CREATE VIRTUAL TABLE search_terms USING fts5vocab(search_index, row)`,
+
+			// Entries -> full-text index
+			`CREATE TRIGGER search_entries_ai AFTER INSERT ON search_entries BEGIN
+				INSERT INTO search_index (rowid, entity_id, entity_type, title, artist, album)
+				VALUES (new.id, new.entity_id, new.entity_type, new.title, new.artist, new.album);
+			END`,
+			`CREATE TRIGGER search_entries_ad AFTER DELETE ON search_entries BEGIN
+				INSERT INTO search_index (search_index, rowid, entity_id, entity_type, title, artist, album)
+				VALUES ('delete', old.id, old.entity_id, old.entity_type, old.title, old.artist, old.album);
+			END`,
+			`CREATE TRIGGER search_entries_au AFTER UPDATE ON search_entries BEGIN
+				INSERT INTO search_index (search_index, rowid, entity_id, entity_type, title, artist, album)
+				VALUES ('delete', old.id, old.entity_id, old.entity_type, old.title, old.artist, old.album);
+				INSERT INTO search_index (rowid, entity_id, entity_type, title, artist, album)
+				VALUES (new.id, new.entity_id, new.entity_type, new.title, new.artist, new.album);
+			END`,
+
+			// Catalog -> entries. Artists and albums repeat their name in
+			// the artist/album column so field qualifiers match them.
+			`CREATE TRIGGER artists_search_ai AFTER INSERT ON artists BEGIN
+				INSERT INTO search_entries (entity_id, entity_type, title, artist, album)
+				VALUES (new.id, 'artist', new.name, new.name, '')
+				ON CONFLICT (entity_type, entity_id) DO UPDATE SET
+				  title = excluded.title, artist = excluded.artist, album = excluded.album;
+			END`,
+			`CREATE TRIGGER artists_search_au AFTER UPDATE OF name ON artists
+			 WHEN old.name IS NOT new.name BEGIN
+				UPDATE search_entries SET title = new.name, artist = new.name
+				WHERE entity_type = 'artist' AND entity_id = new.id;
+				UPDATE search_entries SET artist = new.name
+				WHERE entity_type = 'album' AND entity_id IN (SELECT id FROM albums WHERE artist_id = new.id);
+				UPDATE search_entries SET artist = new.name
+				WHERE entity_type = 'track' AND entity_id IN (SELECT id FROM tracks WHERE artist_id = new.id);
+			END`,
+			`CREATE TRIGGER artists_search_ad AFTER DELETE ON artists BEGIN
+				DELETE FROM search_entries WHERE entity_type = 'artist' AND entity_id = old.id;
+			END`,
+			`CREATE TRIGGER albums_search_ai AFTER INSERT ON albums BEGIN
+				INSERT INTO search_entries (entity_id, entity_type, title, artist, album)
+				SELECT new.id, 'album', new.title, ar.name, new.title FROM artists ar WHERE ar.id = new.artist_id
+				ON CONFLICT (entity_type, entity_id) DO UPDATE SET
+				  title = excluded.title, artist = excluded.artist, album = excluded.album;
+			END`,
+			`CREATE TRIGGER albums_search_au AFTER UPDATE OF title, artist_id ON albums
+			 WHEN old.title IS NOT new.title OR old.artist_id IS NOT new.artist_id BEGIN
+				UPDATE search_entries SET
+				  title = new.title,
+				  artist = COALESCE((SELECT name FROM artists WHERE id = new.artist_id), artist),
+				  album = new.title
+				WHERE entity_type = 'album' AND entity_id = new.id;
+				UPDATE search_entries SET album = new.title
+				WHERE entity_type = 'track' AND entity_id IN (SELECT id FROM tracks WHERE album_id = new.id);
+			END`,
+			`CREATE TRIGGER albums_search_ad AFTER DELETE ON albums BEGIN
+				DELETE FROM search_entries WHERE entity_type = 'album' AND entity_id = old.id;
+			END`,
+			`CREATE TRIGGER tracks_search_ai AFTER INSERT ON tracks BEGIN
+				INSERT INTO search_entries (entity_id, entity_type, title, artist, album)
+				SELECT new.id, 'track', new.title, ar.name, al.title
+				FROM artists ar, albums al
+				WHERE ar.id = new.artist_id AND al.id = new.album_id
+				ON CONFLICT (entity_type, entity_id) DO UPDATE SET
+				  title = excluded.title, artist = excluded.artist, album = excluded.album;
+			END`,
+			`CREATE TRIGGER tracks_search_au AFTER UPDATE OF title, artist_id, album_id ON tracks
+			 WHEN old.title IS NOT new.title OR old.artist_id IS NOT new.artist_id OR old.album_id IS NOT new.album_id BEGIN
+				UPDATE search_entries SET
+				  title = new.title,
+				  artist = COALESCE((SELECT name FROM artists WHERE id = new.artist_id), artist),
+				  album = COALESCE((SELECT title FROM albums WHERE id = new.album_id), album)
+				WHERE entity_type = 'track' AND entity_id = new.id;
+			END`,
+			`CREATE TRIGGER tracks_search_ad AFTER DELETE ON tracks BEGIN
+				DELETE FROM search_entries WHERE entity_type = 'track' AND entity_id = old.id;
+			END`,
+
+			// Start from the catalog rather than the old, duplicated index
+			`INSERT INTO search_entries (entity_id, entity_type, title, artist, album)
+				SELECT id, 'artist', name, name, '' FROM artists`,
+			`INSERT INTO search_entries (entity_id, entity_type, title, artist, album)
+				SELECT al.id, 'album', al.title, ar.name, al.title
+				FROM albums al JOIN artists ar ON ar.id = al.artist_id`,
+			`INSERT INTO search_entries (entity_id, entity_type, title, artist, album)
+				SELECT t.id, 'track', t.title, ar.name, al.title
+				FROM tracks t
+				JOIN artists ar ON ar.id = t.artist_id
+				JOIN albums al ON al.id = t.album_id`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "smart playlists",
+		Statements: []string{
+			// Rules as JSON; NULL for regular playlists
+			`ALTER TABLE playlists ADD COLUMN rules TEXT`,
+			`ALTER TABLE playlists ADD COLUMN refreshed_at DATETIME`,
+		},
+	},
+	{
+		Version: 11,
+		Name:    "playlist covers",
+		Statements: []string{
+			// Set for uploaded covers, which generated collages must not replace
+			`ALTER TABLE playlists ADD COLUMN cover_custom INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		Version: 12,
+		Name:    "playlist folders",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS playlist_folders (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				parent_id TEXT REFERENCES playlist_folders(id),
+				position INTEGER NOT NULL DEFAULT 0,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_playlist_folders_parent ON playlist_folders(parent_id, position)`,
+			`ALTER TABLE playlists ADD COLUMN folder_id TEXT REFERENCES playlist_folders(id)`,
+			`ALTER TABLE playlists ADD COLUMN position INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE playlists ADD COLUMN pinned_at DATETIME`,
+			`CREATE INDEX IF NOT EXISTS idx_playlists_folder ON playlists(folder_id, position)`,
+			// Keep the order playlists were listed in: most recently updated first
+			`UPDATE playlists SET position = (
+				SELECT COUNT(*) FROM playlists p2
+				WHERE p2.updated_at > playlists.updated_at
+				   OR (p2.updated_at = playlists.updated_at AND p2.id <= playlists.id))`,
+		},
+	},
+	{
+		Version: 13,
+		Name:    "playlist revisions",
+		Statements: []string{
+			// Deleted playlists are kept, with their entries, until purged
+			`ALTER TABLE playlists ADD COLUMN deleted_at DATETIME`,
+			`CREATE TABLE IF NOT EXISTS playlist_revisions (
+				id TEXT PRIMARY KEY,
+				playlist_id TEXT NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+				revision INTEGER NOT NULL,
+				action TEXT NOT NULL,
+				name TEXT NOT NULL,
+				track_count INTEGER NOT NULL,
+				snapshot TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (playlist_id, revision)
+			)`,
+		},
+	},
+}
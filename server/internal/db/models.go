@@ -63,10 +63,43 @@ type Playlist struct {
 	Name            string    `json:"name"`
 	Description     *string   `json:"description,omitempty"`
 	CoverPath       *string   `json:"cover_path,omitempty"`
+	CoverCustom     bool      `json:"cover_custom"` // uploaded rather than generated
 	TrackCount      int       `json:"track_count"`
 	DurationSeconds float64   `json:"duration_seconds"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
+	// Smart playlists only: the rules and when entries were last refreshed
+	Rules       *SmartRules `json:"rules,omitempty"`
+	RefreshedAt *time.Time  `json:"refreshed_at,omitempty"`
+	// Filing: the folder (nil at the top level), place within it, and when
+	// the playlist was pinned
+	FolderID *string    `json:"folder_id,omitempty"`
+	Position int        `json:"position"`
+	PinnedAt *time.Time `json:"pinned_at,omitempty"`
+	// Set on deleted playlists, which are kept for restoring until purged
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// PlaylistRevision is a recorded state of a playlist. Snapshot is only
+// loaded for single revisions.
+type PlaylistRevision struct {
+	PlaylistID string            `json:"playlist_id"`
+	Revision   int               `json:"revision"` // 1, 2, ... per playlist
+	Action     string            `json:"action"`
+	Name       string            `json:"name"`
+	TrackCount int               `json:"track_count"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Snapshot   *PlaylistSnapshot `json:"snapshot,omitempty"`
+}
+
+// PlaylistFolder groups playlists; folders nest.
+type PlaylistFolder struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  *string   `json:"parent_id,omitempty"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // PlaylistTrack represents a track within a playlist.
@@ -78,6 +111,16 @@ type PlaylistTrack struct {
 	AddedAt    time.Time `json:"added_at"`
 	// Joined track fields
 	Track *Track `json:"track,omitempty"`
+	// Missing is set when the track is no longer in the library; Track is
+	// then a placeholder
+	Missing bool `json:"missing,omitempty"`
+}
+
+// PlaylistAdditions lists what to append to a playlist.
+type PlaylistAdditions struct {
+	TrackIDs  []string `json:"track_ids"`
+	AlbumIDs  []string `json:"album_ids"`
+	ArtistIDs []string `json:"artist_ids"`
 }
 
 // PlayHistory represents a play event.
@@ -88,6 +131,13 @@ type PlayHistory struct {
 	DurationListened *float64  `json:"duration_listened,omitempty"`
 }
 
+// TrackRating is a user's 1-5 star rating of a track.
+type TrackRating struct {
+	TrackID string    `json:"track_id"`
+	Rating  int       `json:"rating"`
+	RatedAt time.Time `json:"rated_at"`
+}
+
 // SearchResult represents a full-text search result.
 type SearchResult struct {
 	EntityID   string  `json:"entity_id"`
@@ -96,4 +146,100 @@ type SearchResult struct {
 	Artist     string  `json:"artist"`
 	Album      string  `json:"album"`
 	Rank       float64 `json:"rank"`
+	// Listening signals used for ranking; albums and artists sum or
+	// average over their tracks
+	Plays        int64      `json:"plays"`
+	Rating       *float64   `json:"rating,omitempty"`
+	LastPlayed   *time.Time `json:"last_played,omitempty"`
+	ArtistTracks int64      `json:"-"` // tracks by the result's artist
+}
+
+// SearchIndexReport compares the search index with the catalog.
+type SearchIndexReport struct {
+	Entries    int64  `json:"entries"`
+	Missing    int64  `json:"missing"`               // catalog entities not in the index
+	Stale      int64  `json:"stale"`                 // entries for removed entities or with outdated text
+	IndexError string `json:"index_error,omitempty"` // full-text index doesn't match its entries (SQLite)
+	Consistent bool   `json:"consistent"`
+}
+
+// ImportRecord represents one file processed by the import inbox.
+type ImportRecord struct {
+	ID         string    `json:"id"`
+	Source     string    `json:"source"` // dropped file, folder or archive name
+	FileName   string    `json:"file_name"`
+	Status     string    `json:"status"` // "imported", "quarantined"
+	Reason     *string   `json:"reason,omitempty"`
+	DestPath   *string   `json:"dest_path,omitempty"`
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// IntegrityResult represents the latest audio verification of a track.
+type IntegrityResult struct {
+	TrackID   string    `json:"track_id"`
+	Status    string    `json:"status"` // "ok", "no_checksum", "corrupt", "truncated", "missing", "unsupported"
+	Detail    *string   `json:"detail,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	// Joined track fields
+	Title      string `json:"title,omitempty"`
+	ArtistName string `json:"artist_name,omitempty"`
+	AlbumTitle string `json:"album_title,omitempty"`
+	Format     string `json:"format,omitempty"`
+	FilePath   string `json:"file_path,omitempty"`
+}
+
+// TrackIdentifiers holds external identifiers used to match a track.
+type TrackIdentifiers struct {
+	TrackID       string
+	MBRecordingID string
+	Fingerprint   string // comma-separated raw Chromaprint values
+}
+
+// DuplicateGroup represents tracks or albums detected as copies of each other.
+type DuplicateGroup struct {
+	ID          string             `json:"id"`
+	Kind        string             `json:"kind"`   // "track", "album"
+	Reason      string             `json:"reason"` // comma-separated: "musicbrainz", "metadata", "fingerprint", "tracks"
+	PreferredID string             `json:"preferred_id"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Members     []*DuplicateMember `json:"members"`
+}
+
+// DuplicateMember is one copy within a duplicate group.
+type DuplicateMember struct {
+	EntityID     string  `json:"entity_id"`
+	Preferred    bool    `json:"preferred"`
+	QualityScore float64 `json:"quality_score"`
+	// Joined entity
+	Track *Track `json:"track,omitempty"`
+	Album *Album `json:"album,omitempty"`
+}
+
+// Library health issue types.
+const (
+	IssueUnknownArtist     = "unknown_artist"      // track has no artist tag
+	IssueUnknownAlbum      = "unknown_album"       // track has no album tag
+	IssueTitleFromFilename = "title_from_filename" // track has no title tag
+	IssueMissingCover      = "missing_cover"       // album has no artwork
+	IssueTrackGaps         = "track_gaps"          // album disc is missing track numbers
+	IssueMixedFormats      = "mixed_formats"       // album mixes file formats
+	IssueMixedSampleRates  = "mixed_sample_rates"  // album mixes sample rates
+)
+
+// HealthIssueTypes lists every issue type in report order.
+var HealthIssueTypes = []string{
+	IssueUnknownArtist, IssueUnknownAlbum, IssueTitleFromFilename,
+	IssueMissingCover, IssueTrackGaps, IssueMixedFormats, IssueMixedSampleRates,
+}
+
+// HealthIssue is one problem found by the library health report.
+type HealthIssue struct {
+	Type       string  `json:"type"`
+	EntityType string  `json:"entity_type"` // "track" or "album"
+	EntityID   string  `json:"entity_id"`
+	Title      string  `json:"title"`
+	ArtistID   string  `json:"artist_id"`
+	ArtistName string  `json:"artist_name"`
+	Detail     *string `json:"detail,omitempty"`
+	FilePath   *string `json:"file_path,omitempty"`
 }
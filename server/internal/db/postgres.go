@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/lib/pq"
+	"github.com/marks-music-solutions/mms/internal/search"
+	"github.com/rs/zerolog/log"
+)
+
+// OpenPostgres connects to a PostgreSQL database. dsn is a lib/pq connection
+// string or postgres:// URL.
+func OpenPostgres(dsn string, maxConns int) (*sql.DB, error) {
+	if maxConns <= 0 {
+		maxConns = 4
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ping database: %w", err)
+	}
+
+	// One extra connection so writes aren't starved by readers
+	db.SetMaxOpenConns(maxConns + 1)
+	db.SetMaxIdleConns(maxConns + 1)
+
+	log.Info().Str("driver", DriverPostgres).Msg("database connected")
+	return db, nil
+}
+
+// PostgresRepository is the PostgreSQL Store. The shared queries in
+// Repository run unchanged with rebound placeholders; full-text search uses a
+// tsvector column instead of FTS5.
+type PostgresRepository struct {
+	*Repository
+}
+
+// NewPostgresRepository creates a data repository on a PostgreSQL pool.
+func NewPostgresRepository(db *sql.DB) *PostgresRepository {
+	c := &conn{DB: db, driver: DriverPostgres}
+	return &PostgresRepository{
+		Repository: &Repository{db: c, rdb: c},
+	}
+}
+
+// Search performs a full-text search across the library. Rank is negated so
+// that, as with FTS5, lower values are better matches. Results carry the
+// listening signals used to re-rank them.
+func (r *PostgresRepository) Search(ctx context.Context, q *search.Query, limit int) ([]*SearchResult, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 30
+	}
+
+	conds, args := r.searchFilters(q)
+	rank, order := "0", "ORDER BY entity_type, title"
+	if tsQuery := q.TSQuery(); tsQuery != "" {
+		rank, order = "-ts_rank(document, to_tsquery('simple', ?))", "ORDER BY rank"
+		conds = append([]string{"document @@ to_tsquery('simple', ?)"}, conds...)
+		args = append([]any{tsQuery, tsQuery}, args...)
+	}
+	args = append(args, limit)
+
+	rows, err := r.rdb.QueryContext(ctx,
+		`WITH hits AS (
+		   SELECT entity_id, entity_type, title, artist, album, `+rank+` AS rank
+		   FROM search_index
+		   WHERE `+strings.Join(conds, " AND ")+`
+		   `+order+`
+		   LIMIT ?
+		 )
+		 `+searchSignalsQuery, args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	defer rows.Close()
+
+	return scanSearchResults(rows)
+}
+
+// RebuildSearchIndex repopulates the search index from the catalog,
+// discarding whatever it held.
+func (r *PostgresRepository) RebuildSearchIndex(ctx context.Context) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin rebuild search index: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		`DELETE FROM search_index`,
+		`SELECT mms_index_entry(id, 'artist', name, name, '') FROM artists`,
+		`SELECT mms_index_entry(al.id, 'album', al.title, ar.name, al.title)
+		 FROM albums al JOIN artists ar ON ar.id = al.artist_id`,
+		`SELECT mms_index_entry(t.id, 'track', t.title, ar.name, al.title)
+		 FROM tracks t
+		 JOIN artists ar ON ar.id = t.artist_id
+		 JOIN albums al ON al.id = t.album_id`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("rebuild search index: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// CheckSearchIndex compares the search index with the catalog. Entries whose
+// document no longer matches their text count as stale.
+func (r *PostgresRepository) CheckSearchIndex(ctx context.Context) (*SearchIndexReport, error) {
+	report, err := r.checkSearchEntries(ctx, "search_index",
+		"s.document = mms_search_document(s.title, s.artist, s.album)")
+	if err != nil {
+		return nil, err
+	}
+	report.Consistent = report.Missing == 0 && report.Stale == 0
+	return report, nil
+}
+
+// SearchTerms returns every lexeme in the search index with the number of
+// documents containing it.
+func (r *PostgresRepository) SearchTerms(ctx context.Context) (map[string]int64, error) {
+	rows, err := r.rdb.QueryContext(ctx, `SELECT word, ndoc FROM ts_stat('SELECT document FROM search_index')`)
+	if err != nil {
+		return nil, fmt.Errorf("list search terms: %w", err)
+	}
+	defer rows.Close()
+	return scanTermCounts(rows)
+}
@@ -0,0 +1,403 @@
+package db
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/marks-music-solutions/mms/internal/match"
+)
+
+// postgresMigrations mirrors migrations for PostgreSQL. Versions and names
+// must match the SQLite list so both report the same schema version.
+var postgresMigrations = []migration{
+	{
+		Version: 1,
+		Name:    "baseline schema",
+		Statements: []string{
+			// Artists table
+			`CREATE TABLE IF NOT EXISTS artists (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				sort_name TEXT NOT NULL,
+				image_path TEXT,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_artists_name ON artists(name)`,
+			`CREATE INDEX IF NOT EXISTS idx_artists_sort_name ON artists(sort_name)`,
+
+			// Albums table
+			`CREATE TABLE IF NOT EXISTS albums (
+				id TEXT PRIMARY KEY,
+				artist_id TEXT NOT NULL REFERENCES artists(id),
+				title TEXT NOT NULL,
+				sort_title TEXT NOT NULL,
+				year INTEGER,
+				genre TEXT,
+				cover_path TEXT,
+				track_count INTEGER DEFAULT 0,
+				disc_count INTEGER DEFAULT 1,
+				duration_seconds DOUBLE PRECISION DEFAULT 0,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_albums_artist_id ON albums(artist_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_albums_title ON albums(title)`,
+			`CREATE INDEX IF NOT EXISTS idx_albums_year ON albums(year)`,
+			`CREATE INDEX IF NOT EXISTS idx_albums_created_at ON albums(created_at)`,
+
+			// Tracks table
+			`CREATE TABLE IF NOT EXISTS tracks (
+				id TEXT PRIMARY KEY,
+				album_id TEXT NOT NULL REFERENCES albums(id),
+				artist_id TEXT NOT NULL REFERENCES artists(id),
+				title TEXT NOT NULL,
+				track_number INTEGER,
+				disc_number INTEGER DEFAULT 1,
+				duration_seconds DOUBLE PRECISION NOT NULL,
+				file_path TEXT NOT NULL UNIQUE,
+				file_size BIGINT NOT NULL,
+				format TEXT NOT NULL DEFAULT 'flac',
+				sample_rate INTEGER,
+				bit_depth INTEGER,
+				channels INTEGER DEFAULT 2,
+				bitrate INTEGER,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_tracks_album_id ON tracks(album_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_tracks_artist_id ON tracks(artist_id)`,
+
+			// Playlists table
+			`CREATE TABLE IF NOT EXISTS playlists (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				description TEXT,
+				cover_path TEXT,
+				track_count INTEGER DEFAULT 0,
+				duration_seconds DOUBLE PRECISION DEFAULT 0,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+
+			// Playlist tracks junction table
+			`CREATE TABLE IF NOT EXISTS playlist_tracks (
+				id TEXT PRIMARY KEY,
+				playlist_id TEXT NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+				track_id TEXT NOT NULL REFERENCES tracks(id),
+				position INTEGER NOT NULL,
+				added_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_playlist_tracks_playlist ON playlist_tracks(playlist_id, position)`,
+
+			// Play history
+			`CREATE TABLE IF NOT EXISTS play_history (
+				id TEXT PRIMARY KEY,
+				track_id TEXT NOT NULL REFERENCES tracks(id),
+				played_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				duration_listened DOUBLE PRECISION
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_play_history_track ON play_history(track_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_play_history_played_at ON play_history(played_at)`,
+
+			// Full-text search index; document holds the weighted, accent-folded
+			// title/artist/album text
+			`CREATE TABLE IF NOT EXISTS search_index (
+				entity_id TEXT NOT NULL,
+				entity_type TEXT NOT NULL,
+				title TEXT NOT NULL,
+				artist TEXT NOT NULL,
+				album TEXT NOT NULL,
+				document TSVECTOR NOT NULL,
+				PRIMARY KEY (entity_id, entity_type)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_search_index_document ON search_index USING GIN (document)`,
+
+			// System config
+			`CREATE TABLE IF NOT EXISTS system_config (
+				key TEXT PRIMARY KEY,
+				value TEXT NOT NULL,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "import history",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS import_history (
+				id TEXT PRIMARY KEY,
+				source TEXT NOT NULL,
+				file_name TEXT NOT NULL,
+				status TEXT NOT NULL,
+				reason TEXT,
+				dest_path TEXT,
+				imported_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_import_history_imported_at ON import_history(imported_at)`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "track integrity",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS track_integrity (
+				track_id TEXT PRIMARY KEY REFERENCES tracks(id) ON DELETE CASCADE,
+				status TEXT NOT NULL,
+				detail TEXT,
+				checked_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_track_integrity_status ON track_integrity(status)`,
+			`CREATE INDEX IF NOT EXISTS idx_track_integrity_checked_at ON track_integrity(checked_at)`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "duplicate detection",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS track_identifiers (
+				track_id TEXT PRIMARY KEY REFERENCES tracks(id) ON DELETE CASCADE,
+				mb_recording_id TEXT,
+				fingerprint TEXT,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_track_identifiers_mb ON track_identifiers(mb_recording_id)`,
+			`CREATE TABLE IF NOT EXISTS duplicate_groups (
+				id TEXT PRIMARY KEY,
+				kind TEXT NOT NULL,
+				reason TEXT NOT NULL,
+				preferred_id TEXT NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS duplicate_members (
+				group_id TEXT NOT NULL REFERENCES duplicate_groups(id) ON DELETE CASCADE,
+				entity_id TEXT NOT NULL,
+				preferred INTEGER NOT NULL DEFAULT 0,
+				quality_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+				PRIMARY KEY (group_id, entity_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_duplicate_members_entity ON duplicate_members(entity_id, preferred)`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "tag fallbacks",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS track_tag_fallbacks (
+				track_id TEXT PRIMARY KEY REFERENCES tracks(id) ON DELETE CASCADE,
+				title_from_filename INTEGER NOT NULL DEFAULT 0,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "track ratings",
+		Statements: []string{
+			`CREATE TABLE track_ratings (
+				track_id TEXT PRIMARY KEY REFERENCES tracks(id) ON DELETE CASCADE,
+				rating INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+				rated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "search index content",
+		Statements: []string{
+			// Artists and albums repeat their name in the artist/album
+			// column (weights B/C) so field qualifiers match them; the
+			// existing title lexemes (weight A) are copied across
+			`UPDATE search_index SET
+				artist = title,
+				document = document || setweight(ts_filter(document, '{a}'), 'B')
+			 WHERE entity_type = 'artist'`,
+			`UPDATE search_index SET
+				album = title,
+				document = document || setweight(ts_filter(document, '{a}'), 'C')
+			 WHERE entity_type = 'album'`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "search vocabulary",
+		// Nothing to create: ts_stat reads the vocabulary from search_index
+		Statements: []string{},
+	},
+	{
+		Version: 9,
+		Name:    "search index triggers",
+		Statements: []string{
+			// Triggers on the catalog keep search_index in step, so removed
+			// entities drop out. Documents are folded in SQL the same way
+			// match.Fold folds queries.
+			foldFunction(),
+			`CREATE OR REPLACE FUNCTION mms_search_document(title TEXT, artist TEXT, album TEXT) RETURNS TSVECTOR AS $$
+				SELECT setweight(to_tsvector('simple', mms_fold(title)), 'A') ||
+				       setweight(to_tsvector('simple', mms_fold(artist)), 'B') ||
+				       setweight(to_tsvector('simple', mms_fold(album)), 'C')
+			$$ LANGUAGE sql IMMUTABLE`,
+			`CREATE OR REPLACE FUNCTION mms_index_entry(TEXT, TEXT, TEXT, TEXT, TEXT) RETURNS VOID AS $$
+				INSERT INTO search_index (entity_id, entity_type, title, artist, album, document)
+				VALUES ($1, $2, $3, $4, $5, mms_search_document($3, $4, $5))
+				ON CONFLICT (entity_id, entity_type) DO UPDATE SET
+				  title = excluded.title,
+				  artist = excluded.artist,
+				  album = excluded.album,
+				  document = excluded.document
+			$$ LANGUAGE sql`,
+
+			// Artists and albums repeat their name in the artist/album
+			// column so field qualifiers match them
+			`CREATE OR REPLACE FUNCTION mms_index_artist() RETURNS TRIGGER AS $$
+			BEGIN
+				IF TG_OP = 'DELETE' THEN
+					DELETE FROM search_index WHERE entity_type = 'artist' AND entity_id = OLD.id;
+					RETURN OLD;
+				END IF;
+				PERFORM mms_index_entry(NEW.id, 'artist', NEW.name, NEW.name, '');
+				IF TG_OP = 'UPDATE' THEN
+					PERFORM mms_index_entry(al.id, 'album', al.title, NEW.name, al.title)
+					FROM albums al WHERE al.artist_id = NEW.id;
+					PERFORM mms_index_entry(t.id, 'track', t.title, NEW.name, al.title)
+					FROM tracks t JOIN albums al ON al.id = t.album_id WHERE t.artist_id = NEW.id;
+				END IF;
+				RETURN NEW;
+			END
+			$$ LANGUAGE plpgsql`,
+			`CREATE OR REPLACE FUNCTION mms_index_album() RETURNS TRIGGER AS $$
+			BEGIN
+				IF TG_OP = 'DELETE' THEN
+					DELETE FROM search_index WHERE entity_type = 'album' AND entity_id = OLD.id;
+					RETURN OLD;
+				END IF;
+				PERFORM mms_index_entry(NEW.id, 'album', NEW.title, ar.name, NEW.title)
+				FROM artists ar WHERE ar.id = NEW.artist_id;
+				IF TG_OP = 'UPDATE' THEN
+					PERFORM mms_index_entry(t.id, 'track', t.title, ar.name, NEW.title)
+					FROM tracks t JOIN artists ar ON ar.id = t.artist_id WHERE t.album_id = NEW.id;
+				END IF;
+				RETURN NEW;
+			END
+			$$ LANGUAGE plpgsql`,
+			`CREATE OR REPLACE FUNCTION mms_index_track() RETURNS TRIGGER AS $$
+			BEGIN
+				IF TG_OP = 'DELETE' THEN
+					DELETE FROM search_index WHERE entity_type = 'track' AND entity_id = OLD.id;
+					RETURN OLD;
+				END IF;
+				PERFORM mms_index_entry(NEW.id, 'track', NEW.title, ar.name, al.title)
+				FROM artists ar, albums al WHERE ar.id = NEW.artist_id AND al.id = NEW.album_id;
+				RETURN NEW;
+			END
+			$$ LANGUAGE plpgsql`,
+
+			// Upserts rewrite every column, so updates only reindex when
+			// indexed text changes
+			`CREATE TRIGGER artists_search_insert AFTER INSERT OR DELETE ON artists
+			 FOR EACH ROW EXECUTE FUNCTION mms_index_artist()`,
+			`CREATE TRIGGER artists_search_update AFTER UPDATE OF name ON artists
+			 FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name) EXECUTE FUNCTION mms_index_artist()`,
+			`CREATE TRIGGER albums_search_insert AFTER INSERT OR DELETE ON albums
+			 FOR EACH ROW EXECUTE FUNCTION mms_index_album()`,
+			`CREATE TRIGGER albums_search_update AFTER UPDATE OF title, artist_id ON albums
+			 FOR EACH ROW WHEN (OLD.title IS DISTINCT FROM NEW.title OR OLD.artist_id IS DISTINCT FROM NEW.artist_id)
+			 EXECUTE FUNCTION mms_index_album()`,
+			`CREATE TRIGGER tracks_search_insert AFTER INSERT OR DELETE ON tracks
+			 FOR EACH ROW EXECUTE FUNCTION mms_index_track()`,
+			`CREATE TRIGGER tracks_search_update AFTER UPDATE OF title, artist_id, album_id ON tracks
+			 FOR EACH ROW WHEN (OLD.title IS DISTINCT FROM NEW.title OR OLD.artist_id IS DISTINCT FROM NEW.artist_id
+			   OR OLD.album_id IS DISTINCT FROM NEW.album_id)
+			 EXECUTE FUNCTION mms_index_track()`,
+
+			// Drop entries for entities that no longer exist
+			`DELETE FROM search_index s WHERE NOT EXISTS (
+				SELECT 1 FROM artists WHERE s.entity_type = 'artist' AND id = s.entity_id
+				UNION ALL SELECT 1 FROM albums WHERE s.entity_type = 'album' AND id = s.entity_id
+				UNION ALL SELECT 1 FROM tracks WHERE s.entity_type = 'track' AND id = s.entity_id
+			)`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "smart playlists",
+		Statements: []string{
+			// Rules as JSON; NULL for regular playlists
+			`ALTER TABLE playlists ADD COLUMN rules TEXT`,
+			`ALTER TABLE playlists ADD COLUMN refreshed_at TIMESTAMPTZ`,
+		},
+	},
+	{
+		Version: 11,
+		Name:    "playlist covers",
+		Statements: []string{
+			// Set for uploaded covers, which generated collages must not replace
+			`ALTER TABLE playlists ADD COLUMN cover_custom INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		Version: 12,
+		Name:    "playlist folders",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS playlist_folders (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				parent_id TEXT REFERENCES playlist_folders(id),
+				position INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_playlist_folders_parent ON playlist_folders(parent_id, position)`,
+			`ALTER TABLE playlists ADD COLUMN folder_id TEXT REFERENCES playlist_folders(id)`,
+			`ALTER TABLE playlists ADD COLUMN position INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE playlists ADD COLUMN pinned_at TIMESTAMPTZ`,
+			`CREATE INDEX IF NOT EXISTS idx_playlists_folder ON playlists(folder_id, position)`,
+			// Keep the order playlists were listed in: most recently updated first
+			`UPDATE playlists SET position = (
+				SELECT COUNT(*) FROM playlists p2
+				WHERE p2.updated_at > playlists.updated_at
+				   OR (p2.updated_at = playlists.updated_at AND p2.id <= playlists.id))`,
+		},
+	},
+	{
+		Version: 13,
+		Name:    "playlist revisions",
+		Statements: []string{
+			// Deleted playlists are kept, with their entries, until purged
+			`ALTER TABLE playlists ADD COLUMN deleted_at TIMESTAMPTZ`,
+			`CREATE TABLE IF NOT EXISTS playlist_revisions (
+				id TEXT PRIMARY KEY,
+				playlist_id TEXT NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+				revision INTEGER NOT NULL,
+				action TEXT NOT NULL,
+				name TEXT NOT NULL,
+				track_count INTEGER NOT NULL,
+				snapshot TEXT NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (playlist_id, revision)
+			)`,
+		},
+	},
+}
+
+// foldFunction returns SQL creating mms_fold, a PostgreSQL port of
+// match.Fold: lower() followed by the same letter replacements.
+func foldFunction() string {
+	var from, to strings.Builder
+	expr := "lower(t)"
+	table := match.FoldTable()
+	for _, r := range slices.Sorted(maps.Keys(table)) {
+		if f := table[r]; len(f) == 1 {
+			from.WriteRune(r)
+			to.WriteString(f)
+		} else {
+			expr = fmt.Sprintf("replace(%s, '%c', '%s')", expr, r, f)
+		}
+	}
+	expr = fmt.Sprintf("translate(%s, '%s', '%s')", expr, from.String(), to.String())
+	return `CREATE OR REPLACE FUNCTION mms_fold(t TEXT) RETURNS TEXT AS $$
+				SELECT ` + expr + `
+			$$ LANGUAGE sql IMMUTABLE`
+}
@@ -3,20 +3,40 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/marks-music-solutions/mms/internal/search"
 )
 
 // Repository provides data access for the music library.
-// All queries use parameterized statements.
+// All queries use parameterized statements. Writes go through the single
+// writer connection; lookups and listings use the read pool so they don't
+// queue behind a scan.
 type Repository struct {
-	db *sql.DB
+	db  *conn // writer
+	rdb *conn // read-only pool
+
+	mu               sync.Mutex
+	onPlaylistChange []func(playlistID string)
 }
 
-// NewRepository creates a new data repository.
-func NewRepository(db *sql.DB) *Repository {
-	return &Repository{db: db}
+// NewRepository creates a new SQLite data repository. rdb may be nil, in
+// which case reads share the writer connection.
+func NewRepository(db, rdb *sql.DB) *Repository {
+	if rdb == nil {
+		rdb = db
+	}
+	return &Repository{
+		db:  &conn{DB: db, driver: DriverSQLite},
+		rdb: &conn{DB: rdb, driver: DriverSQLite},
+	}
 }
 
 // --- Artist Operations ---
@@ -41,7 +61,7 @@ func (r *Repository) UpsertArtist(ctx context.Context, name, sortName string) (*
 // GetArtistByID retrieves an artist by ID.
 func (r *Repository) GetArtistByID(ctx context.Context, id string) (*Artist, error) {
 	a := &Artist{}
-	err := r.db.QueryRowContext(ctx,
+	err := r.rdb.QueryRowContext(ctx,
 		`SELECT a.id, a.name, a.sort_name, a.image_path, a.created_at, a.updated_at,
 		        (SELECT COUNT(*) FROM albums WHERE artist_id = a.id) as album_count,
 		        (SELECT COUNT(*) FROM tracks WHERE artist_id = a.id) as track_count
@@ -54,27 +74,30 @@ func (r *Repository) GetArtistByID(ctx context.Context, id string) (*Artist, err
 	return a, nil
 }
 
-// ListArtists returns all artists sorted by name.
-func (r *Repository) ListArtists(ctx context.Context, limit, offset int) ([]*Artist, int64, error) {
-	if limit <= 0 || limit > 200 {
-		limit = 50
+// ListArtists returns a page of artists, sorted by name unless opts says
+// otherwise.
+func (r *Repository) ListArtists(ctx context.Context, opts *ListOptions) ([]*Artist, *Page, error) {
+	if err := opts.normalize(artistList); err != nil {
+		return nil, nil, err
 	}
-
-	var total int64
-	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM artists`).Scan(&total); err != nil {
-		return nil, 0, fmt.Errorf("count artists: %w", err)
+	ids, page, err := r.pageIDs(ctx, artistList, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("page artists: %w", err)
+	}
+	if len(ids) == 0 {
+		return []*Artist{}, page, nil
 	}
 
-	rows, err := r.db.QueryContext(ctx,
+	in, args := inClause(ids)
+	rows, err := r.rdb.QueryContext(ctx,
 		`SELECT a.id, a.name, a.sort_name, a.image_path, a.created_at, a.updated_at,
 		        (SELECT COUNT(*) FROM albums WHERE artist_id = a.id) as album_count,
 		        (SELECT COUNT(*) FROM tracks WHERE artist_id = a.id) as track_count
 		 FROM artists a
-		 ORDER BY a.sort_name ASC
-		 LIMIT ? OFFSET ?`, limit, offset,
+		 WHERE a.id IN `+in, args...,
 	)
 	if err != nil {
-		return nil, 0, fmt.Errorf("list artists: %w", err)
+		return nil, nil, fmt.Errorf("list artists: %w", err)
 	}
 	defer rows.Close()
 
@@ -83,14 +106,15 @@ func (r *Repository) ListArtists(ctx context.Context, limit, offset int) ([]*Art
 		a := &Artist{}
 		if err := rows.Scan(&a.ID, &a.Name, &a.SortName, &a.ImagePath, &a.CreatedAt, &a.UpdatedAt,
 			&a.AlbumCount, &a.TrackCount); err != nil {
-			return nil, 0, fmt.Errorf("scan artist: %w", err)
+			return nil, nil, fmt.Errorf("scan artist: %w", err)
 		}
 		artists = append(artists, a)
 	}
 	if artists == nil {
 		artists = []*Artist{}
 	}
-	return artists, total, nil
+	sortByIDs(artists, ids, func(a *Artist) string { return a.ID })
+	return artists, page, nil
 }
 
 // --- Album Operations ---
@@ -117,7 +141,7 @@ func (r *Repository) UpsertAlbum(ctx context.Context, artistID, title, sortTitle
 // GetAlbumByID retrieves an album by ID with artist name.
 func (r *Repository) GetAlbumByID(ctx context.Context, id string) (*Album, error) {
 	a := &Album{}
-	err := r.db.QueryRowContext(ctx,
+	err := r.rdb.QueryRowContext(ctx,
 		`SELECT al.id, al.artist_id, al.title, al.sort_title, al.year, al.genre,
 		        al.cover_path, al.track_count, al.disc_count, al.duration_seconds,
 		        al.created_at, al.updated_at,
@@ -134,38 +158,47 @@ func (r *Repository) GetAlbumByID(ctx context.Context, id string) (*Album, error
 	return a, nil
 }
 
-// ListAlbums returns albums with pagination.
-func (r *Repository) ListAlbums(ctx context.Context, limit, offset int) ([]*Album, int64, error) {
-	if limit <= 0 || limit > 200 {
-		limit = 50
+// ListAlbums returns a page of albums, sorted by title unless opts says
+// otherwise. With opts.HideDuplicates, albums detected as lower-quality
+// copies of another album are left out.
+func (r *Repository) ListAlbums(ctx context.Context, opts *ListOptions) ([]*Album, *Page, error) {
+	if err := opts.normalize(albumList); err != nil {
+		return nil, nil, err
 	}
-
-	var total int64
-	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM albums`).Scan(&total); err != nil {
-		return nil, 0, fmt.Errorf("count albums: %w", err)
+	ids, page, err := r.pageIDs(ctx, albumList, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("page albums: %w", err)
+	}
+	if len(ids) == 0 {
+		return []*Album{}, page, nil
 	}
 
-	rows, err := r.db.QueryContext(ctx,
+	in, args := inClause(ids)
+	rows, err := r.rdb.QueryContext(ctx,
 		`SELECT al.id, al.artist_id, al.title, al.sort_title, al.year, al.genre,
 		        al.cover_path, al.track_count, al.disc_count, al.duration_seconds,
 		        al.created_at, al.updated_at,
 		        ar.name as artist_name
 		 FROM albums al
 		 JOIN artists ar ON ar.id = al.artist_id
-		 ORDER BY al.sort_title ASC
-		 LIMIT ? OFFSET ?`, limit, offset,
+		 WHERE al.id IN `+in, args...,
 	)
 	if err != nil {
-		return nil, 0, fmt.Errorf("list albums: %w", err)
+		return nil, nil, fmt.Errorf("list albums: %w", err)
 	}
 	defer rows.Close()
 
-	return r.scanAlbums(rows)
+	albums, _, err := r.scanAlbums(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	sortByIDs(albums, ids, func(a *Album) string { return a.ID })
+	return albums, page, nil
 }
 
 // ListAlbumsByArtist returns all albums for a given artist.
 func (r *Repository) ListAlbumsByArtist(ctx context.Context, artistID string) ([]*Album, error) {
-	rows, err := r.db.QueryContext(ctx,
+	rows, err := r.rdb.QueryContext(ctx,
 		`SELECT al.id, al.artist_id, al.title, al.sort_title, al.year, al.genre,
 		        al.cover_path, al.track_count, al.disc_count, al.duration_seconds,
 		        al.created_at, al.updated_at,
@@ -185,17 +218,22 @@ func (r *Repository) ListAlbumsByArtist(ctx context.Context, artistID string) ([
 }
 
 // RecentAlbums returns the most recently added albums.
-func (r *Repository) RecentAlbums(ctx context.Context, limit int) ([]*Album, error) {
+func (r *Repository) RecentAlbums(ctx context.Context, limit int, hideDuplicates bool) ([]*Album, error) {
 	if limit <= 0 || limit > 100 {
 		limit = 20
 	}
-	rows, err := r.db.QueryContext(ctx,
+	where := ""
+	if hideDuplicates {
+		where = "WHERE " + notDuplicateAlbum
+	}
+	rows, err := r.rdb.QueryContext(ctx,
 		`SELECT al.id, al.artist_id, al.title, al.sort_title, al.year, al.genre,
 		        al.cover_path, al.track_count, al.disc_count, al.duration_seconds,
 		        al.created_at, al.updated_at,
 		        ar.name as artist_name
 		 FROM albums al
 		 JOIN artists ar ON ar.id = al.artist_id
+		 `+where+`
 		 ORDER BY al.created_at DESC
 		 LIMIT ?`, limit,
 	)
@@ -209,17 +247,22 @@ func (r *Repository) RecentAlbums(ctx context.Context, limit int) ([]*Album, err
 }
 
 // RandomAlbums returns random albums.
-func (r *Repository) RandomAlbums(ctx context.Context, limit int) ([]*Album, error) {
+func (r *Repository) RandomAlbums(ctx context.Context, limit int, hideDuplicates bool) ([]*Album, error) {
 	if limit <= 0 || limit > 100 {
 		limit = 20
 	}
-	rows, err := r.db.QueryContext(ctx,
+	where := ""
+	if hideDuplicates {
+		where = "WHERE " + notDuplicateAlbum
+	}
+	rows, err := r.rdb.QueryContext(ctx,
 		`SELECT al.id, al.artist_id, al.title, al.sort_title, al.year, al.genre,
 		        al.cover_path, al.track_count, al.disc_count, al.duration_seconds,
 		        al.created_at, al.updated_at,
 		        ar.name as artist_name
 		 FROM albums al
 		 JOIN artists ar ON ar.id = al.artist_id
+		 `+where+`
 		 ORDER BY RANDOM()
 		 LIMIT ?`, limit,
 	)
@@ -232,6 +275,14 @@ func (r *Repository) RandomAlbums(ctx context.Context, limit int) ([]*Album, err
 	return albums, err
 }
 
+// notDuplicateAlbum filters out albums that are non-preferred duplicates.
+const notDuplicateAlbum = `NOT EXISTS (SELECT 1 FROM duplicate_members dm
+	WHERE dm.entity_id = al.id AND dm.preferred = 0)`
+
+// notDuplicateTrack filters out tracks that are non-preferred duplicates.
+const notDuplicateTrack = `NOT EXISTS (SELECT 1 FROM duplicate_members dm
+	WHERE dm.entity_id = t.id AND dm.preferred = 0)`
+
 func (r *Repository) scanAlbums(rows *sql.Rows) ([]*Album, int64, error) {
 	var albums []*Album
 	for rows.Next() {
@@ -301,7 +352,7 @@ func (r *Repository) UpsertTrack(ctx context.Context, t *Track) error {
 // GetTrackByID retrieves a track with joined artist/album info.
 func (r *Repository) GetTrackByID(ctx context.Context, id string) (*Track, error) {
 	t := &Track{}
-	err := r.db.QueryRowContext(ctx,
+	err := r.rdb.QueryRowContext(ctx,
 		`SELECT t.id, t.album_id, t.artist_id, t.title, t.track_number, t.disc_number,
 		        t.duration_seconds, t.file_path, t.file_size, t.format,
 		        t.sample_rate, t.bit_depth, t.channels, t.bitrate,
@@ -323,8 +374,13 @@ func (r *Repository) GetTrackByID(ctx context.Context, id string) (*Track, error
 }
 
 // ListTracksByAlbum returns all tracks for an album, ordered by disc/track number.
-func (r *Repository) ListTracksByAlbum(ctx context.Context, albumID string) ([]*Track, error) {
-	rows, err := r.db.QueryContext(ctx,
+// When hideDuplicates is set, lower-quality copies of other tracks are left out.
+func (r *Repository) ListTracksByAlbum(ctx context.Context, albumID string, hideDuplicates bool) ([]*Track, error) {
+	where := "WHERE t.album_id = ?"
+	if hideDuplicates {
+		where += " AND " + notDuplicateTrack
+	}
+	rows, err := r.rdb.QueryContext(ctx,
 		`SELECT t.id, t.album_id, t.artist_id, t.title, t.track_number, t.disc_number,
 		        t.duration_seconds, t.file_path, t.file_size, t.format,
 		        t.sample_rate, t.bit_depth, t.channels, t.bitrate,
@@ -333,7 +389,7 @@ func (r *Repository) ListTracksByAlbum(ctx context.Context, albumID string) ([]*
 		 FROM tracks t
 		 JOIN artists ar ON ar.id = t.artist_id
 		 JOIN albums al ON al.id = t.album_id
-		 WHERE t.album_id = ?
+		 `+where+`
 		 ORDER BY t.disc_number ASC, t.track_number ASC`, albumID,
 	)
 	if err != nil {
@@ -344,6 +400,95 @@ func (r *Repository) ListTracksByAlbum(ctx context.Context, albumID string) ([]*
 	return r.scanTracks(rows)
 }
 
+// ListAllTracks returns every track in the library ordered by file path.
+func (r *Repository) ListAllTracks(ctx context.Context) ([]*Track, error) {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT t.id, t.album_id, t.artist_id, t.title, t.track_number, t.disc_number,
+		        t.duration_seconds, t.file_path, t.file_size, t.format,
+		        t.sample_rate, t.bit_depth, t.channels, t.bitrate,
+		        t.created_at, t.updated_at,
+		        ar.name as artist_name, al.title as album_title, al.cover_path
+		 FROM tracks t
+		 JOIN artists ar ON ar.id = t.artist_id
+		 JOIN albums al ON al.id = t.album_id
+		 ORDER BY t.file_path ASC`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list all tracks: %w", err)
+	}
+	defer rows.Close()
+
+	return r.scanTracks(rows)
+}
+
+// ListTracks returns a page of tracks across the library, sorted by title
+// unless opts says otherwise.
+func (r *Repository) ListTracks(ctx context.Context, opts *ListOptions) ([]*Track, *Page, error) {
+	if err := opts.normalize(trackList); err != nil {
+		return nil, nil, err
+	}
+	ids, page, err := r.pageIDs(ctx, trackList, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("page tracks: %w", err)
+	}
+	if len(ids) == 0 {
+		return []*Track{}, page, nil
+	}
+
+	tracks, err := r.tracksByIDs(ctx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	sortByIDs(tracks, ids, func(t *Track) string { return t.ID })
+	return tracks, page, nil
+}
+
+// tracksByIDs fetches tracks with joined artist/album info, in no particular
+// order. IDs that don't exist are skipped.
+func (r *Repository) tracksByIDs(ctx context.Context, ids []string) ([]*Track, error) {
+	in, args := inClause(ids)
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT t.id, t.album_id, t.artist_id, t.title, t.track_number, t.disc_number,
+		        t.duration_seconds, t.file_path, t.file_size, t.format,
+		        t.sample_rate, t.bit_depth, t.channels, t.bitrate,
+		        t.created_at, t.updated_at,
+		        ar.name as artist_name, al.title as album_title, al.cover_path
+		 FROM tracks t
+		 JOIN artists ar ON ar.id = t.artist_id
+		 JOIN albums al ON al.id = t.album_id
+		 WHERE t.id IN `+in, args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list tracks: %w", err)
+	}
+	defer rows.Close()
+	return r.scanTracks(rows)
+}
+
+// GetTrackIDByPath returns the ID of the track stored at the given file path.
+func (r *Repository) GetTrackIDByPath(ctx context.Context, path string) (string, error) {
+	var id string
+	err := r.rdb.QueryRowContext(ctx,
+		`SELECT id FROM tracks WHERE file_path = ?`, path,
+	).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("get track by path %s: %w", path, err)
+	}
+	return id, nil
+}
+
+// UpdateTrackPath points a track at a new file location, keeping its ID.
+func (r *Repository) UpdateTrackPath(ctx context.Context, id, path string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE tracks SET file_path = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		path, id,
+	)
+	if err != nil {
+		return fmt.Errorf("update track path %s: %w", id, err)
+	}
+	return nil
+}
+
 func (r *Repository) scanTracks(rows *sql.Rows) ([]*Track, error) {
 	var tracks []*Track
 	for rows.Next() {
@@ -365,48 +510,293 @@ func (r *Repository) scanTracks(rows *sql.Rows) ([]*Track, error) {
 
 // --- Search Operations ---
 
-// IndexTrack adds a track to the FTS5 search index.
-func (r *Repository) IndexTrack(ctx context.Context, entityID, entityType, title, artist, album string) error {
-	// Delete existing entry first (FTS5 doesn't support ON CONFLICT)
-	r.db.ExecContext(ctx,
-		`DELETE FROM search_index WHERE entity_id = ? AND entity_type = ?`,
-		entityID, entityType)
+// Search performs a full-text search across the library. Queries with only
+// filters (e.g. "genre:jazz year:1959") list matching entities unranked.
+// Results carry the listening signals used to re-rank them.
+func (r *Repository) Search(ctx context.Context, q *search.Query, limit int) ([]*SearchResult, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 30
+	}
+
+	conds, args := r.searchFilters(q)
+	rank, order := "rank", "ORDER BY rank"
+	if ftsQuery := q.FTS5(); ftsQuery != "" {
+		conds = append([]string{"search_index MATCH ?"}, conds...)
+		args = append([]any{ftsQuery}, args...)
+	} else {
+		rank, order = "0", "ORDER BY entity_type, title"
+	}
+	args = append(args, limit)
 
-	_, err := r.db.ExecContext(ctx,
-		`INSERT INTO search_index (entity_id, entity_type, title, artist, album)
-		 VALUES (?, ?, ?, ?, ?)`,
-		entityID, entityType, title, artist, album,
+	rows, err := r.rdb.QueryContext(ctx,
+		`WITH hits AS (
+		   SELECT entity_id, entity_type, title, artist, album, `+rank+` AS rank
+		   FROM search_index
+		   WHERE `+strings.Join(conds, " AND ")+`
+		   `+order+`
+		   LIMIT ?
+		 )
+		 `+searchSignalsQuery, args...,
 	)
-	return err
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	defer rows.Close()
+
+	return scanSearchResults(rows)
 }
 
-// Search performs a full-text search across the library.
-func (r *Repository) Search(ctx context.Context, query string, limit int) ([]*SearchResult, error) {
-	if limit <= 0 || limit > 100 {
-		limit = 30
+// searchSignalsQuery selects the hits CTE with each entity's plays, last play,
+// average rating and the size of its artist's catalog. Each signal is a
+// subquery per entity type so it can use the track, album or artist index.
+var searchSignalsQuery = `SELECT h.entity_id, h.entity_type, h.title, h.artist, h.album, h.rank,
+	` + perEntity("COUNT(*)", "FROM play_history ph JOIN tracks st ON st.id = ph.track_id") + ` AS plays,
+	` + perEntity("MAX(ph.played_at)", "FROM play_history ph JOIN tracks st ON st.id = ph.track_id") + ` AS last_played,
+	` + perEntity("AVG(tr.rating)", "FROM track_ratings tr JOIN tracks st ON st.id = tr.track_id") + ` AS rating,
+	(SELECT COUNT(*) FROM tracks at WHERE at.artist_id = CASE h.entity_type
+		WHEN 'artist' THEN h.entity_id
+		WHEN 'album' THEN (SELECT artist_id FROM albums WHERE id = h.entity_id)
+		ELSE (SELECT artist_id FROM tracks WHERE id = h.entity_id) END) AS artist_tracks
+	FROM hits h
+	ORDER BY h.rank`
+
+// perEntity returns a subquery computing sel over the tracks (aliased st) of
+// the hit's track, album or artist.
+func perEntity(sel, from string) string {
+	return `CASE h.entity_type
+		WHEN 'track' THEN (SELECT ` + sel + ` ` + from + ` WHERE st.id = h.entity_id)
+		WHEN 'album' THEN (SELECT ` + sel + ` ` + from + ` WHERE st.album_id = h.entity_id)
+		ELSE (SELECT ` + sel + ` ` + from + ` WHERE st.artist_id = h.entity_id) END`
+}
+
+// SearchTerms returns every word in the search index with the number of
+// index entries containing it.
+func (r *Repository) SearchTerms(ctx context.Context) (map[string]int64, error) {
+	rows, err := r.rdb.QueryContext(ctx, `SELECT term, doc FROM search_terms`)
+	if err != nil {
+		return nil, fmt.Errorf("list search terms: %w", err)
+	}
+	defer rows.Close()
+	return scanTermCounts(rows)
+}
+
+func scanTermCounts(rows *sql.Rows) (map[string]int64, error) {
+	terms := make(map[string]int64)
+	for rows.Next() {
+		var term string
+		var n int64
+		if err := rows.Scan(&term, &n); err != nil {
+			return nil, fmt.Errorf("scan search term: %w", err)
+		}
+		terms[term] = n
+	}
+	return terms, rows.Err()
+}
+
+// RebuildSearchIndex repopulates the search index from the catalog,
+// discarding whatever it held.
+func (r *Repository) RebuildSearchIndex(ctx context.Context) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin rebuild search index: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmts := append([]string{`DELETE FROM search_entries`}, searchEntrySources...)
+	// Rebuild the full-text index from its content table, as the delete
+	// triggers could not remove entries it was missing
+	stmts = append(stmts, `INSERT INTO search_index (search_index) VALUES ('rebuild')`)
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("rebuild search index: %w", err)
+		}
 	}
+	return tx.Commit()
+}
+
+// searchEntrySources fill search_entries from the catalog. Artists and
+// albums repeat their name in the artist/album column so field qualifiers
+// match them.
+var searchEntrySources = []string{
+	`INSERT INTO search_entries (entity_id, entity_type, title, artist, album)
+	 SELECT id, 'artist', name, name, '' FROM artists`,
+	`INSERT INTO search_entries (entity_id, entity_type, title, artist, album)
+	 SELECT al.id, 'album', al.title, ar.name, al.title
+	 FROM albums al JOIN artists ar ON ar.id = al.artist_id`,
+	`INSERT INTO search_entries (entity_id, entity_type, title, artist, album)
+	 SELECT t.id, 'track', t.title, ar.name, al.title
+	 FROM tracks t
+	 JOIN artists ar ON ar.id = t.artist_id
+	 JOIN albums al ON al.id = t.album_id`,
+}
+
+// CheckSearchIndex compares the search index with the catalog.
+func (r *Repository) CheckSearchIndex(ctx context.Context) (*SearchIndexReport, error) {
+	report, err := r.checkSearchEntries(ctx, "search_entries", "")
+	if err != nil {
+		return nil, err
+	}
+	// With rank 1, integrity-check also compares the full-text index
+	// against search_entries
+	if _, err := r.db.ExecContext(ctx,
+		`INSERT INTO search_index (search_index, rank) VALUES ('integrity-check', 1)`,
+	); err != nil {
+		report.IndexError = err.Error()
+	}
+	report.Consistent = report.Missing == 0 && report.Stale == 0 && report.IndexError == ""
+	return report, nil
+}
 
-	// Use FTS5 match syntax with prefix matching
-	ftsQuery := query + "*"
+// checkSearchEntries counts entries in table against the catalog. extra
+// adds a condition an up-to-date entry s must meet.
+func (r *Repository) checkSearchEntries(ctx context.Context, table, extra string) (*SearchIndexReport, error) {
+	if extra != "" {
+		extra = " AND " + extra
+	}
+	report := &SearchIndexReport{}
+	err := r.rdb.QueryRowContext(ctx,
+		`SELECT
+		   (SELECT COUNT(*) FROM `+table+`),
+		   (SELECT COUNT(*) FROM artists a WHERE NOT EXISTS (
+		      SELECT 1 FROM `+table+` s WHERE s.entity_type = 'artist' AND s.entity_id = a.id))
+		 + (SELECT COUNT(*) FROM albums a WHERE NOT EXISTS (
+		      SELECT 1 FROM `+table+` s WHERE s.entity_type = 'album' AND s.entity_id = a.id))
+		 + (SELECT COUNT(*) FROM tracks a WHERE NOT EXISTS (
+		      SELECT 1 FROM `+table+` s WHERE s.entity_type = 'track' AND s.entity_id = a.id)),
+		   (SELECT COUNT(*) FROM `+table+` s WHERE NOT EXISTS (
+		      SELECT 1 FROM artists ar
+		      WHERE s.entity_type = 'artist' AND ar.id = s.entity_id
+		        AND s.title = ar.name AND s.artist = ar.name AND s.album = ''
+		      UNION ALL
+		      SELECT 1 FROM albums al JOIN artists ar ON ar.id = al.artist_id
+		      WHERE s.entity_type = 'album' AND al.id = s.entity_id
+		        AND s.title = al.title AND s.artist = ar.name AND s.album = al.title
+		      UNION ALL
+		      SELECT 1 FROM tracks t
+		      JOIN artists ar ON ar.id = t.artist_id
+		      JOIN albums al ON al.id = t.album_id
+		      WHERE s.entity_type = 'track' AND t.id = s.entity_id
+		        AND s.title = t.title AND s.artist = ar.name AND s.album = al.title
+		   )`+extra+`)`,
+	).Scan(&report.Entries, &report.Missing, &report.Stale)
+	if err != nil {
+		return nil, fmt.Errorf("check search index: %w", err)
+	}
+	return report, nil
+}
 
-	rows, err := r.db.QueryContext(ctx,
-		`SELECT entity_id, entity_type, title, artist, album, rank
-		 FROM search_index
-		 WHERE search_index MATCH ?
-		 ORDER BY rank
-		 LIMIT ?`, ftsQuery, limit,
+// SearchSuggestions returns every artist, album and track with its play
+// count, for the in-memory completion index.
+func (r *Repository) SearchSuggestions(ctx context.Context) ([]search.Suggestion, error) {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT ar.id, 'artist', ar.name, '', COUNT(ph.id)
+		 FROM artists ar
+		 LEFT JOIN tracks t ON t.artist_id = ar.id
+		 LEFT JOIN play_history ph ON ph.track_id = t.id
+		 GROUP BY ar.id, ar.name
+		 UNION ALL
+		 SELECT al.id, 'album', al.title, ar.name, COUNT(ph.id)
+		 FROM albums al
+		 JOIN artists ar ON ar.id = al.artist_id
+		 LEFT JOIN tracks t ON t.album_id = al.id
+		 LEFT JOIN play_history ph ON ph.track_id = t.id
+		 GROUP BY al.id, al.title, ar.name
+		 UNION ALL
+		 SELECT t.id, 'track', t.title, ar.name, COUNT(ph.id)
+		 FROM tracks t
+		 JOIN artists ar ON ar.id = t.artist_id
+		 LEFT JOIN play_history ph ON ph.track_id = t.id
+		 GROUP BY t.id, t.title, ar.name`,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("search: %w", err)
+		return nil, fmt.Errorf("list search suggestions: %w", err)
 	}
 	defer rows.Close()
 
+	var out []search.Suggestion
+	for rows.Next() {
+		var s search.Suggestion
+		if err := rows.Scan(&s.ID, &s.Type, &s.Title, &s.Artist, &s.Plays); err != nil {
+			return nil, fmt.Errorf("scan search suggestion: %w", err)
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// timeValue converts an aggregated timestamp to a time. SQLite returns
+// MAX() over a DATETIME column as text; PostgreSQL keeps the type.
+func timeValue(v any) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		t, err := time.Parse(time.DateTime, v)
+		return t, err == nil
+	case []byte:
+		t, err := time.Parse(time.DateTime, string(v))
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// searchFilters returns conditions on search_index rows for the query's
+// genre, year and format filters. A track must match itself; albums and
+// artists match when any of their tracks does.
+func (r *Repository) searchFilters(q *search.Query) ([]string, []any) {
+	var conds []string
+	var args []any
+	for _, f := range q.Filters {
+		opts := &ListOptions{}
+		switch f.Field {
+		case search.FieldGenre:
+			opts.Genre = f.Value
+		case search.FieldYear:
+			opts.YearFrom, opts.YearTo = f.YearFrom, f.YearTo
+		case search.FieldFormat:
+			opts.Format = f.Value
+		}
+		albumConds, albumArgs := opts.albumConditions("fa")
+		trackConds, trackArgs := opts.trackConditions("ft")
+		match := strings.Join(append(albumConds, trackConds...), " AND ")
+		matchArgs := append(albumArgs, trackArgs...)
+
+		var entities []string
+		for _, e := range []struct{ entityType, key string }{
+			{"track", "ft.id"},
+			{"album", "fa.id"},
+			{"artist", "ft.artist_id"},
+		} {
+			entities = append(entities, `(search_index.entity_type = '`+e.entityType+`' AND EXISTS (
+				SELECT 1 FROM tracks ft JOIN albums fa ON fa.id = ft.album_id
+				WHERE `+e.key+` = search_index.entity_id AND `+match+`))`)
+			args = append(args, matchArgs...)
+		}
+		cond := "(" + strings.Join(entities, " OR ") + ")"
+		if f.Negate {
+			cond = "NOT " + cond
+		}
+		conds = append(conds, cond)
+	}
+	return conds, args
+}
+
+func scanSearchResults(rows *sql.Rows) ([]*SearchResult, error) {
 	var results []*SearchResult
 	for rows.Next() {
 		sr := &SearchResult{}
-		if err := rows.Scan(&sr.EntityID, &sr.EntityType, &sr.Title, &sr.Artist, &sr.Album, &sr.Rank); err != nil {
+		var lastPlayed any
+		var rating sql.NullFloat64
+		if err := rows.Scan(&sr.EntityID, &sr.EntityType, &sr.Title, &sr.Artist, &sr.Album, &sr.Rank,
+			&sr.Plays, &lastPlayed, &rating, &sr.ArtistTracks); err != nil {
 			return nil, fmt.Errorf("scan search result: %w", err)
 		}
+		if rating.Valid {
+			sr.Rating = &rating.Float64
+		}
+		if t, ok := timeValue(lastPlayed); ok {
+			sr.LastPlayed = &t
+		}
 		results = append(results, sr)
 	}
 	if results == nil {
@@ -417,40 +807,152 @@ func (r *Repository) Search(ctx context.Context, query string, limit int) ([]*Se
 
 // --- Playlist Operations ---
 
-// CreatePlaylist creates a new playlist.
-func (r *Repository) CreatePlaylist(ctx context.Context, name, description string) (*Playlist, error) {
+// OnPlaylistChange registers fn to run after a playlist's entries change or
+// the playlist is deleted, e.g. to redraw its cover.
+func (r *Repository) OnPlaylistChange(fn func(playlistID string)) {
+	r.mu.Lock()
+	r.onPlaylistChange = append(r.onPlaylistChange, fn)
+	r.mu.Unlock()
+}
+
+func (r *Repository) playlistChanged(id string) {
+	r.mu.Lock()
+	hooks := slices.Clone(r.onPlaylistChange)
+	r.mu.Unlock()
+	for _, fn := range hooks {
+		fn(id)
+	}
+}
+
+// ErrNotFound is returned by playlist edits when the playlist, entry or an
+// added track, album or artist does not exist.
+var ErrNotFound = errors.New("not found")
+
+// nextInFolder is the position after the last playlist in the folder bound
+// to its placeholder (NULL for the top level), where new playlists go.
+const nextInFolder = `(SELECT COALESCE(MAX(position), 0) + 1 FROM playlists WHERE COALESCE(folder_id, '') = COALESCE(?, ''))`
+
+// requireFolder returns ErrNotFound unless folderID is nil (the top level)
+// or an existing folder.
+func requireFolder(ctx context.Context, t *tx, folderID *string) error {
+	if folderID == nil {
+		return nil
+	}
+	if err := requireRow(ctx, t, "playlist_folders", *folderID); err != nil {
+		return fmt.Errorf("folder %s: %w", *folderID, err)
+	}
+	return nil
+}
+
+// CreatePlaylist creates a new playlist at the end of a folder, or of the
+// top level when folderID is nil.
+func (r *Repository) CreatePlaylist(ctx context.Context, name, description string, folderID *string) (*Playlist, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin create playlist: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := requireFolder(ctx, tx, folderID); err != nil {
+		return nil, err
+	}
 	id := uuid.New().String()
-	_, err := r.db.ExecContext(ctx,
-		`INSERT INTO playlists (id, name, description) VALUES (?, ?, ?)`,
-		id, name, description,
-	)
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO playlists (id, name, description, folder_id, position) VALUES (?, ?, ?, ?, `+nextInFolder+`)`,
+		id, name, description, folderID, folderID,
+	); err != nil {
+		return nil, fmt.Errorf("create playlist: %w", err)
+	}
+	if err := recordRevision(ctx, tx, id, RevisionCreate); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit create playlist: %w", err)
+	}
+	return r.GetPlaylistByID(ctx, id)
+}
+
+// CreateSmartPlaylist creates a smart playlist at the end of a folder, or of
+// the top level when folderID is nil, and fills it from its rules.
+func (r *Repository) CreateSmartPlaylist(ctx context.Context, name, description string, rules *SmartRules, folderID *string) (*Playlist, error) {
+	data, err := marshalRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
+		return nil, fmt.Errorf("begin create playlist: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := requireFolder(ctx, tx, folderID); err != nil {
+		return nil, err
+	}
+	id := uuid.New().String()
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO playlists (id, name, description, rules, folder_id, position)
+		 VALUES (?, ?, ?, ?, ?, `+nextInFolder+`)`,
+		id, name, description, data, folderID, folderID,
+	); err != nil {
 		return nil, fmt.Errorf("create playlist: %w", err)
 	}
+	if _, err := r.refreshSmartPlaylistTx(ctx, tx, id, RevisionCreate); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit create playlist: %w", err)
+	}
+	r.playlistChanged(id)
 	return r.GetPlaylistByID(ctx, id)
 }
 
-// GetPlaylistByID retrieves a playlist.
-func (r *Repository) GetPlaylistByID(ctx context.Context, id string) (*Playlist, error) {
+// playlistColumns are the columns scanPlaylist reads.
+const playlistColumns = `id, name, description, cover_path, cover_custom, track_count, duration_seconds,
+		        created_at, updated_at, rules, refreshed_at, folder_id, position, pinned_at, deleted_at`
+
+func scanPlaylist(row interface{ Scan(...any) error }) (*Playlist, error) {
 	p := &Playlist{}
-	err := r.db.QueryRowContext(ctx,
-		`SELECT id, name, description, cover_path, track_count, duration_seconds,
-		        created_at, updated_at
-		 FROM playlists WHERE id = ?`, id,
-	).Scan(&p.ID, &p.Name, &p.Description, &p.CoverPath, &p.TrackCount,
-		&p.DurationSeconds, &p.CreatedAt, &p.UpdatedAt)
+	var rules sql.NullString
+	if err := row.Scan(&p.ID, &p.Name, &p.Description, &p.CoverPath, &p.CoverCustom, &p.TrackCount,
+		&p.DurationSeconds, &p.CreatedAt, &p.UpdatedAt, &rules, &p.RefreshedAt,
+		&p.FolderID, &p.Position, &p.PinnedAt, &p.DeletedAt); err != nil {
+		return nil, err
+	}
+	if rules.Valid {
+		p.Rules = &SmartRules{}
+		if err := json.Unmarshal([]byte(rules.String), p.Rules); err != nil {
+			return nil, fmt.Errorf("playlist %s rules: %w", p.ID, err)
+		}
+	}
+	return p, nil
+}
+
+// marshalRules validates rules and encodes them for the rules column.
+func marshalRules(rules *SmartRules) (string, error) {
+	if err := rules.Validate(); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return "", fmt.Errorf("encode rules: %w", err)
+	}
+	return string(data), nil
+}
+
+// GetPlaylistByID retrieves a playlist that hasn't been deleted.
+func (r *Repository) GetPlaylistByID(ctx context.Context, id string) (*Playlist, error) {
+	p, err := scanPlaylist(r.rdb.QueryRowContext(ctx,
+		`SELECT `+playlistColumns+` FROM playlists WHERE id = ? AND deleted_at IS NULL`, id))
 	if err != nil {
 		return nil, fmt.Errorf("get playlist %s: %w", id, err)
 	}
 	return p, nil
 }
 
-// ListPlaylists returns all playlists.
+// ListPlaylists returns all playlists that haven't been deleted.
 func (r *Repository) ListPlaylists(ctx context.Context) ([]*Playlist, error) {
-	rows, err := r.db.QueryContext(ctx,
-		`SELECT id, name, description, cover_path, track_count, duration_seconds,
-		        created_at, updated_at
-		 FROM playlists ORDER BY updated_at DESC`,
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT `+playlistColumns+` FROM playlists WHERE deleted_at IS NULL ORDER BY updated_at DESC`,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("list playlists: %w", err)
@@ -459,9 +961,8 @@ func (r *Repository) ListPlaylists(ctx context.Context) ([]*Playlist, error) {
 
 	var playlists []*Playlist
 	for rows.Next() {
-		p := &Playlist{}
-		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.CoverPath, &p.TrackCount,
-			&p.DurationSeconds, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		p, err := scanPlaylist(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan playlist: %w", err)
 		}
 		playlists = append(playlists, p)
@@ -472,47 +973,700 @@ func (r *Repository) ListPlaylists(ctx context.Context) ([]*Playlist, error) {
 	return playlists, nil
 }
 
-// DeletePlaylist removes a playlist.
+// DeletePlaylist marks a playlist deleted. It keeps its entries, folder and
+// cover until purged, and RestorePlaylistRevision brings it back.
 func (r *Repository) DeletePlaylist(ctx context.Context, id string) error {
-	_, err := r.db.ExecContext(ctx, `DELETE FROM playlists WHERE id = ?`, id)
-	return err
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin delete playlist: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE playlists SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL`, id)
+	if err != nil {
+		return fmt.Errorf("delete playlist: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("playlist %s: %w", id, ErrNotFound)
+	}
+	if err := recordRevision(ctx, tx, id, RevisionDelete); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // AddTrackToPlaylist appends a track to a playlist.
 func (r *Repository) AddTrackToPlaylist(ctx context.Context, playlistID, trackID string) error {
-	id := uuid.New().String()
-	_, err := r.db.ExecContext(ctx,
-		`INSERT INTO playlist_tracks (id, playlist_id, track_id, position)
-		 VALUES (?, ?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM playlist_tracks WHERE playlist_id = ?))`,
-		id, playlistID, trackID, playlistID,
-	)
+	_, err := r.AddToPlaylist(ctx, playlistID, &PlaylistAdditions{TrackIDs: []string{trackID}})
+	return err
+}
+
+// AddToPlaylist appends tracks, albums and artists' tracks to a playlist in
+// one transaction and returns how many entries were added. Albums add their
+// tracks in disc and track order; artists add theirs album by album, oldest
+// first.
+func (r *Repository) AddToPlaylist(ctx context.Context, playlistID string, add *PlaylistAdditions) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin add to playlist: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := requireManualPlaylist(ctx, tx, playlistID); err != nil {
+		return 0, fmt.Errorf("playlist %s: %w", playlistID, err)
+	}
+
+	trackIDs := make([]string, 0, len(add.TrackIDs))
+	for _, id := range add.TrackIDs {
+		if err := requireRow(ctx, tx, "tracks", id); err != nil {
+			return 0, fmt.Errorf("track %s: %w", id, err)
+		}
+		trackIDs = append(trackIDs, id)
+	}
+	for _, id := range add.AlbumIDs {
+		if err := requireRow(ctx, tx, "albums", id); err != nil {
+			return 0, fmt.Errorf("album %s: %w", id, err)
+		}
+		ids, err := queryIDs(ctx, tx,
+			`SELECT id FROM tracks WHERE album_id = ?
+			 ORDER BY disc_number, track_number, title`, id)
+		if err != nil {
+			return 0, fmt.Errorf("list album tracks: %w", err)
+		}
+		trackIDs = append(trackIDs, ids...)
+	}
+	for _, id := range add.ArtistIDs {
+		if err := requireRow(ctx, tx, "artists", id); err != nil {
+			return 0, fmt.Errorf("artist %s: %w", id, err)
+		}
+		ids, err := queryIDs(ctx, tx,
+			`SELECT t.id FROM tracks t JOIN albums al ON al.id = t.album_id
+			 WHERE t.artist_id = ?
+			 ORDER BY al.year IS NULL, al.year, al.sort_title, al.id, t.disc_number, t.track_number, t.title`, id)
+		if err != nil {
+			return 0, fmt.Errorf("list artist tracks: %w", err)
+		}
+		trackIDs = append(trackIDs, ids...)
+	}
+
+	var last int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(position), 0) FROM playlist_tracks WHERE playlist_id = ?`, playlistID,
+	).Scan(&last); err != nil {
+		return 0, fmt.Errorf("playlist end: %w", err)
+	}
+	for i, trackID := range trackIDs {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO playlist_tracks (id, playlist_id, track_id, position) VALUES (?, ?, ?, ?)`,
+			uuid.New().String(), playlistID, trackID, last+i+1,
+		); err != nil {
+			return 0, fmt.Errorf("add track to playlist: %w", err)
+		}
+	}
+
+	if err := updatePlaylistStats(ctx, tx, playlistID); err != nil {
+		return 0, err
+	}
+	if err := recordRevision(ctx, tx, playlistID, RevisionAdd); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit add to playlist: %w", err)
+	}
+	r.playlistChanged(playlistID)
+	return len(trackIDs), nil
+}
+
+// UpdatePlaylist changes a playlist's name, description and, for smart
+// playlists, rules; nil leaves a field as it is. New rules refresh the
+// entries.
+func (r *Repository) UpdatePlaylist(ctx context.Context, id string, name, description *string, rules *SmartRules) (*Playlist, error) {
+	var data *string
+	if rules != nil {
+		p, err := r.GetPlaylistByID(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("playlist %s: %w", id, ErrNotFound)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p.Rules == nil {
+			return nil, fmt.Errorf("playlist %s: %w", id, ErrNotSmartPlaylist)
+		}
+		encoded, err := marshalRules(rules)
+		if err != nil {
+			return nil, err
+		}
+		data = &encoded
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("add track to playlist: %w", err)
+		return nil, fmt.Errorf("begin update playlist: %w", err)
 	}
+	defer tx.Rollback()
 
-	// Update playlist stats
-	_, err = r.db.ExecContext(ctx,
+	res, err := tx.ExecContext(ctx,
 		`UPDATE playlists SET
-		   track_count = (SELECT COUNT(*) FROM playlist_tracks WHERE playlist_id = ?),
-		   duration_seconds = (SELECT COALESCE(SUM(t.duration_seconds), 0) FROM playlist_tracks pt JOIN tracks t ON t.id = pt.track_id WHERE pt.playlist_id = ?),
+		   name = COALESCE(?, name),
+		   description = COALESCE(?, description),
+		   rules = COALESCE(?, rules),
 		   updated_at = CURRENT_TIMESTAMP
-		 WHERE id = ?`, playlistID, playlistID, playlistID,
+		 WHERE id = ? AND deleted_at IS NULL`, name, description, data, id,
 	)
-	return err
+	if err != nil {
+		return nil, fmt.Errorf("update playlist: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("playlist %s: %w", id, ErrNotFound)
+	}
+	// New rules are recorded with the entries they select
+	if rules != nil {
+		_, err = r.refreshSmartPlaylistTx(ctx, tx, id, RevisionEdit)
+	} else {
+		err = recordRevision(ctx, tx, id, RevisionEdit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit update playlist: %w", err)
+	}
+	if rules != nil {
+		r.playlistChanged(id)
+	}
+	return r.GetPlaylistByID(ctx, id)
 }
 
-// RemoveTrackFromPlaylist removes a track from a playlist by position.
-func (r *Repository) RemoveTrackFromPlaylist(ctx context.Context, playlistID string, position int) error {
-	_, err := r.db.ExecContext(ctx,
-		`DELETE FROM playlist_tracks WHERE playlist_id = ? AND position = ?`,
-		playlistID, position,
-	)
-	return err
+// RefreshSmartPlaylist updates a smart playlist's entries to the tracks its
+// rules select now. Tracks that stay keep their entry and the time they were
+// added. Live playlists are checked on the read pool first, so reading one
+// whose tracks haven't changed writes nothing.
+func (r *Repository) RefreshSmartPlaylist(ctx context.Context, id string) (*Playlist, error) {
+	p, err := r.GetPlaylistByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("playlist %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.Rules == nil {
+		return nil, fmt.Errorf("playlist %s: %w", id, ErrNotSmartPlaylist)
+	}
+	if p.Rules.Live() {
+		query, args, err := smartQuery(p.Rules, r.timeArg, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		selected, err := queryIDs(ctx, r.rdb, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("evaluate playlist rules: %w", err)
+		}
+		current, err := queryIDs(ctx, r.rdb,
+			`SELECT track_id FROM playlist_tracks WHERE playlist_id = ? ORDER BY position`, id)
+		if err != nil {
+			return nil, fmt.Errorf("list playlist entries: %w", err)
+		}
+		if slices.Equal(selected, current) {
+			return p, nil
+		}
+	}
+	return r.refreshSmartPlaylist(ctx, id, RevisionRefresh)
 }
 
-// --- Play History ---
+// refreshSmartPlaylist refreshes a smart playlist and records the result
+// as a revision with the given action.
+func (r *Repository) refreshSmartPlaylist(ctx context.Context, id, action string) (*Playlist, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin refresh playlist: %w", err)
+	}
+	defer tx.Rollback()
 
-// RecordPlay records a play event.
+	changed, err := r.refreshSmartPlaylistTx(ctx, tx, id, action)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit refresh playlist: %w", err)
+	}
+	if changed {
+		r.playlistChanged(id)
+	}
+	return r.GetPlaylistByID(ctx, id)
+}
+
+// refreshSmartPlaylistTx re-evaluates a smart playlist's rules within t,
+// touching only the entries that changed, and records the result as a
+// revision with the given action. It reports whether the entries changed.
+func (r *Repository) refreshSmartPlaylistTx(ctx context.Context, t *tx, id, action string) (bool, error) {
+	p, err := scanPlaylist(t.QueryRowContext(ctx,
+		`SELECT `+playlistColumns+` FROM playlists WHERE id = ? AND deleted_at IS NULL`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("playlist %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return false, fmt.Errorf("get playlist %s: %w", id, err)
+	}
+	if p.Rules == nil {
+		return false, fmt.Errorf("playlist %s: %w", id, ErrNotSmartPlaylist)
+	}
+	query, args, err := smartQuery(p.Rules, r.timeArg, time.Now())
+	if err != nil {
+		return false, err
+	}
+	trackIDs, err := queryIDs(ctx, t, query, args...)
+	if err != nil {
+		return false, fmt.Errorf("evaluate playlist rules: %w", err)
+	}
+
+	type entry struct {
+		id       string
+		position int
+	}
+	rows, err := t.QueryContext(ctx,
+		`SELECT id, track_id, position FROM playlist_tracks WHERE playlist_id = ?
+		 ORDER BY position, added_at, id`, id)
+	if err != nil {
+		return false, fmt.Errorf("list playlist entries: %w", err)
+	}
+	existing := make(map[string]entry)
+	var stale []string
+	for rows.Next() {
+		var e entry
+		var trackID string
+		if err := rows.Scan(&e.id, &trackID, &e.position); err != nil {
+			rows.Close()
+			return false, fmt.Errorf("scan playlist entry: %w", err)
+		}
+		if _, dup := existing[trackID]; dup {
+			stale = append(stale, e.id)
+			continue
+		}
+		existing[trackID] = e
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("list playlist entries: %w", err)
+	}
+
+	// Entries whose track is still selected only move; the rest go
+	kept := make(map[string]bool, len(trackIDs))
+	for _, trackID := range trackIDs {
+		kept[trackID] = true
+	}
+	for trackID, e := range existing {
+		if !kept[trackID] {
+			stale = append(stale, e.id)
+		}
+	}
+	changed := len(stale) > 0
+	for _, entryID := range stale {
+		if _, err := t.ExecContext(ctx, `DELETE FROM playlist_tracks WHERE id = ?`, entryID); err != nil {
+			return false, fmt.Errorf("remove playlist entry: %w", err)
+		}
+	}
+	now := r.timeArg(time.Now())
+	for i, trackID := range trackIDs {
+		e, ok := existing[trackID]
+		switch {
+		case !ok:
+			_, err = t.ExecContext(ctx,
+				`INSERT INTO playlist_tracks (id, playlist_id, track_id, position, added_at) VALUES (?, ?, ?, ?, ?)`,
+				uuid.New().String(), id, trackID, i+1, now)
+		case e.position != i+1:
+			_, err = t.ExecContext(ctx,
+				`UPDATE playlist_tracks SET position = ? WHERE id = ?`, i+1, e.id)
+		default:
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("update playlist entry: %w", err)
+		}
+		changed = true
+	}
+
+	if changed {
+		if err := updatePlaylistStats(ctx, t, id); err != nil {
+			return false, err
+		}
+	}
+	// Scheduled playlists note every evaluation so they aren't due again
+	// until the next interval
+	if changed || !p.Rules.Live() {
+		if _, err := t.ExecContext(ctx,
+			`UPDATE playlists SET refreshed_at = CURRENT_TIMESTAMP WHERE id = ?`, id,
+		); err != nil {
+			return false, fmt.Errorf("mark playlist refreshed: %w", err)
+		}
+	}
+	return changed, recordRevision(ctx, t, id, action)
+}
+
+// ListPlaylistEntries returns a playlist's entries in order.
+func (r *Repository) ListPlaylistEntries(ctx context.Context, playlistID string) ([]*PlaylistTrack, error) {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT id, playlist_id, track_id, position, added_at
+		 FROM playlist_tracks WHERE playlist_id = ?
+		 ORDER BY position ASC`, playlistID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list playlist entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*PlaylistTrack
+	for rows.Next() {
+		pt := &PlaylistTrack{}
+		if err := rows.Scan(&pt.ID, &pt.PlaylistID, &pt.TrackID, &pt.Position, &pt.AddedAt); err != nil {
+			return nil, fmt.Errorf("scan playlist entry: %w", err)
+		}
+		entries = append(entries, pt)
+	}
+	if entries == nil {
+		entries = []*PlaylistTrack{}
+	}
+	return entries, nil
+}
+
+// missingTrackTitle stands in for the title of playlist entries whose track
+// is no longer in the library.
+const missingTrackTitle = "Unavailable track"
+
+// ListPlaylistTracks returns a page of a playlist's entries in order, each
+// with its track, artist, album and cover. Entries whose track is gone are
+// marked Missing and carry a placeholder track.
+func (r *Repository) ListPlaylistTracks(ctx context.Context, playlistID string, limit, offset int) ([]*PlaylistTrack, error) {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT id, playlist_id, track_id, position, added_at
+		 FROM playlist_tracks WHERE playlist_id = ?
+		 ORDER BY position ASC
+		 LIMIT ? OFFSET ?`, playlistID, limit, offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list playlist tracks: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []*PlaylistTrack{}
+	var trackIDs []string
+	for rows.Next() {
+		pt := &PlaylistTrack{}
+		if err := rows.Scan(&pt.ID, &pt.PlaylistID, &pt.TrackID, &pt.Position, &pt.AddedAt); err != nil {
+			return nil, fmt.Errorf("scan playlist entry: %w", err)
+		}
+		entries = append(entries, pt)
+		trackIDs = append(trackIDs, pt.TrackID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list playlist tracks: %w", err)
+	}
+	if len(entries) == 0 {
+		return entries, nil
+	}
+
+	tracks, err := r.tracksByIDs(ctx, trackIDs)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*Track, len(tracks))
+	for _, t := range tracks {
+		byID[t.ID] = t
+	}
+	for _, pt := range entries {
+		if pt.Track = byID[pt.TrackID]; pt.Track == nil {
+			pt.Track = &Track{ID: pt.TrackID, Title: missingTrackTitle}
+			pt.Missing = true
+		}
+	}
+	return entries, nil
+}
+
+// RestorePlaylist recreates a playlist with its original timestamps, entries
+// and smart playlist rules in one transaction. It goes last in its folder
+// (FolderID, nil for the top level) and stays pinned if PinnedAt is set.
+func (r *Repository) RestorePlaylist(ctx context.Context, p *Playlist, entries []*PlaylistTrack) (*Playlist, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin restore playlist: %w", err)
+	}
+	defer tx.Rollback()
+
+	var rules *string
+	if p.Rules != nil {
+		data, err := marshalRules(p.Rules)
+		if err != nil {
+			return nil, err
+		}
+		rules = &data
+	}
+	var pinnedAt any
+	if p.PinnedAt != nil {
+		pinnedAt = r.timeArg(*p.PinnedAt)
+	}
+	id := uuid.New().String()
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO playlists (id, name, description, rules, created_at, updated_at, folder_id, pinned_at, position)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, `+nextInFolder+`)`,
+		id, p.Name, p.Description, rules, r.timeArg(p.CreatedAt), r.timeArg(p.UpdatedAt),
+		p.FolderID, pinnedAt, p.FolderID,
+	); err != nil {
+		return nil, fmt.Errorf("restore playlist: %w", err)
+	}
+	for i, e := range entries {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO playlist_tracks (id, playlist_id, track_id, position, added_at) VALUES (?, ?, ?, ?, ?)`,
+			uuid.New().String(), id, e.TrackID, i+1, r.timeArg(e.AddedAt),
+		); err != nil {
+			return nil, fmt.Errorf("restore playlist entry: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE playlists SET
+		   track_count = (SELECT COUNT(*) FROM playlist_tracks WHERE playlist_id = ?),
+		   duration_seconds = (SELECT COALESCE(SUM(t.duration_seconds), 0) FROM playlist_tracks pt JOIN tracks t ON t.id = pt.track_id WHERE pt.playlist_id = ?)
+		 WHERE id = ?`, id, id, id,
+	); err != nil {
+		return nil, fmt.Errorf("restore playlist stats: %w", err)
+	}
+	if err := recordRevision(ctx, tx, id, RevisionImport); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit restore playlist: %w", err)
+	}
+	r.playlistChanged(id)
+	return r.GetPlaylistByID(ctx, id)
+}
+
+// RemovePlaylistEntry removes an entry from a playlist and closes the gap
+// it leaves.
+func (r *Repository) RemovePlaylistEntry(ctx context.Context, playlistID, entryID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin remove playlist entry: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := requireManualPlaylist(ctx, tx, playlistID); err != nil {
+		return fmt.Errorf("playlist %s: %w", playlistID, err)
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`DELETE FROM playlist_tracks WHERE id = ? AND playlist_id = ?`, entryID, playlistID)
+	if err != nil {
+		return fmt.Errorf("remove playlist entry: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("playlist entry %s: %w", entryID, ErrNotFound)
+	}
+
+	order, err := playlistOrder(ctx, tx, playlistID)
+	if err != nil {
+		return err
+	}
+	if err := renumberPlaylist(ctx, tx, order); err != nil {
+		return err
+	}
+	if err := updatePlaylistStats(ctx, tx, playlistID); err != nil {
+		return err
+	}
+	if err := recordRevision(ctx, tx, playlistID, RevisionRemove); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.playlistChanged(playlistID)
+	return nil
+}
+
+// MovePlaylistEntry moves an entry to a 1-based position, shifting the
+// entries in between. Positions past either end move it to that end.
+func (r *Repository) MovePlaylistEntry(ctx context.Context, playlistID, entryID string, position int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin move playlist entry: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := requireManualPlaylist(ctx, tx, playlistID); err != nil {
+		return fmt.Errorf("playlist %s: %w", playlistID, err)
+	}
+
+	order, err := playlistOrder(ctx, tx, playlistID)
+	if err != nil {
+		return err
+	}
+	from := slices.Index(order, entryID)
+	if from < 0 {
+		return fmt.Errorf("playlist entry %s: %w", entryID, ErrNotFound)
+	}
+	to := min(max(position, 1), len(order)) - 1
+	order = slices.Insert(slices.Delete(order, from, from+1), to, entryID)
+
+	if err := renumberPlaylist(ctx, tx, order); err != nil {
+		return err
+	}
+	if err := updatePlaylistStats(ctx, tx, playlistID); err != nil {
+		return err
+	}
+	if err := recordRevision(ctx, tx, playlistID, RevisionMove); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.playlistChanged(playlistID)
+	return nil
+}
+
+// PlaylistCoverArt returns the cover paths of the first n distinct albums in
+// a playlist that have one, in playlist order.
+func (r *Repository) PlaylistCoverArt(ctx context.Context, playlistID string, n int) ([]string, error) {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT al.cover_path
+		 FROM playlist_tracks pt
+		 JOIN tracks t ON t.id = pt.track_id
+		 JOIN albums al ON al.id = t.album_id
+		 WHERE pt.playlist_id = ? AND al.cover_path IS NOT NULL
+		 GROUP BY al.id, al.cover_path
+		 ORDER BY MIN(pt.position)
+		 LIMIT ?`, playlistID, n,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list playlist cover art: %w", err)
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, fmt.Errorf("scan playlist cover art: %w", err)
+		}
+		paths = append(paths, p)
+	}
+	return paths, rows.Err()
+}
+
+// SetPlaylistCover records a playlist's cover image; nil clears it. custom
+// marks an uploaded cover.
+func (r *Repository) SetPlaylistCover(ctx context.Context, id string, path *string, custom bool) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE playlists SET cover_path = ?, cover_custom = ? WHERE id = ?`, path, custom, id)
+	if err != nil {
+		return fmt.Errorf("set playlist cover: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("playlist %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// playlistOrder returns a playlist's entry IDs in play order.
+func playlistOrder(ctx context.Context, t *tx, playlistID string) ([]string, error) {
+	ids, err := queryIDs(ctx, t,
+		`SELECT id FROM playlist_tracks WHERE playlist_id = ? ORDER BY position, added_at, id`, playlistID)
+	if err != nil {
+		return nil, fmt.Errorf("list playlist entries: %w", err)
+	}
+	return ids, nil
+}
+
+// renumberPlaylist sets entry positions to 1..n in the given order.
+func renumberPlaylist(ctx context.Context, t *tx, order []string) error {
+	for i, id := range order {
+		if _, err := t.ExecContext(ctx,
+			`UPDATE playlist_tracks SET position = ? WHERE id = ? AND position <> ?`, i+1, id, i+1,
+		); err != nil {
+			return fmt.Errorf("renumber playlist: %w", err)
+		}
+	}
+	return nil
+}
+
+// updatePlaylistStats recomputes a playlist's track count and duration after
+// an edit.
+func updatePlaylistStats(ctx context.Context, t *tx, playlistID string) error {
+	_, err := t.ExecContext(ctx,
+		`UPDATE playlists SET
+		   track_count = (SELECT COUNT(*) FROM playlist_tracks WHERE playlist_id = ?),
+		   duration_seconds = (SELECT COALESCE(SUM(t.duration_seconds), 0) FROM playlist_tracks pt JOIN tracks t ON t.id = pt.track_id WHERE pt.playlist_id = ?),
+		   updated_at = CURRENT_TIMESTAMP
+		 WHERE id = ?`, playlistID, playlistID, playlistID,
+	)
+	if err != nil {
+		return fmt.Errorf("update playlist stats: %w", err)
+	}
+	return nil
+}
+
+// requireRow returns ErrNotFound unless table has a row with id.
+func requireRow(ctx context.Context, t *tx, table, id string) error {
+	var n int
+	if err := t.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+` WHERE id = ?`, id).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// requirePlaylist returns ErrNotFound unless the playlist exists and hasn't
+// been deleted.
+func requirePlaylist(ctx context.Context, t *tx, id string) error {
+	var n int
+	if err := t.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM playlists WHERE id = ? AND deleted_at IS NULL`, id).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// requireManualPlaylist returns ErrNotFound unless the playlist exists and
+// ErrSmartPlaylist if its entries come from rules.
+func requireManualPlaylist(ctx context.Context, t *tx, id string) error {
+	var rules sql.NullString
+	err := t.QueryRowContext(ctx,
+		`SELECT rules FROM playlists WHERE id = ? AND deleted_at IS NULL`, id).Scan(&rules)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if rules.Valid {
+		return ErrSmartPlaylist
+	}
+	return nil
+}
+
+// queryIDs returns the single string column of a query.
+func queryIDs(ctx context.Context, q querier, query string, args ...any) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// --- Play History ---
+
+// RecordPlay records a play event.
 func (r *Repository) RecordPlay(ctx context.Context, trackID string, durationListened *float64) error {
 	id := uuid.New().String()
 	_, err := r.db.ExecContext(ctx,
@@ -522,11 +1676,660 @@ func (r *Repository) RecordPlay(ctx context.Context, trackID string, durationLis
 	return err
 }
 
+// ListPlayHistory returns every play event, oldest first.
+func (r *Repository) ListPlayHistory(ctx context.Context) ([]*PlayHistory, error) {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT id, track_id, played_at, duration_listened
+		 FROM play_history ORDER BY played_at ASC`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list play history: %w", err)
+	}
+	defer rows.Close()
+
+	var plays []*PlayHistory
+	for rows.Next() {
+		p := &PlayHistory{}
+		if err := rows.Scan(&p.ID, &p.TrackID, &p.PlayedAt, &p.DurationListened); err != nil {
+			return nil, fmt.Errorf("scan play: %w", err)
+		}
+		plays = append(plays, p)
+	}
+	if plays == nil {
+		plays = []*PlayHistory{}
+	}
+	return plays, nil
+}
+
+// RestorePlay inserts a historical play event unless the same track already
+// has a play at that time. It reports whether a row was added.
+func (r *Repository) RestorePlay(ctx context.Context, trackID string, playedAt time.Time, durationListened *float64) (bool, error) {
+	res, err := r.db.ExecContext(ctx,
+		`INSERT INTO play_history (id, track_id, played_at, duration_listened)
+		 SELECT ?, ?, ?, ?
+		 WHERE NOT EXISTS (SELECT 1 FROM play_history WHERE track_id = ? AND played_at = ?)`,
+		uuid.New().String(), trackID, r.timeArg(playedAt), durationListened,
+		trackID, r.timeArg(playedAt),
+	)
+	if err != nil {
+		return false, fmt.Errorf("restore play: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// --- Ratings ---
+
+// SetTrackRating stores a 1-5 rating for a track; 0 clears it.
+func (r *Repository) SetTrackRating(ctx context.Context, trackID string, rating int, ratedAt time.Time) error {
+	if rating == 0 {
+		if _, err := r.db.ExecContext(ctx, `DELETE FROM track_ratings WHERE track_id = ?`, trackID); err != nil {
+			return fmt.Errorf("clear rating %s: %w", trackID, err)
+		}
+		return nil
+	}
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO track_ratings (track_id, rating, rated_at)
+		 VALUES (?, ?, ?)
+		 ON CONFLICT(track_id) DO UPDATE SET
+		   rating = excluded.rating,
+		   rated_at = excluded.rated_at`,
+		trackID, rating, r.timeArg(ratedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("set rating %s: %w", trackID, err)
+	}
+	return nil
+}
+
+// ListRatings returns every track rating.
+func (r *Repository) ListRatings(ctx context.Context) ([]*TrackRating, error) {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT track_id, rating, rated_at FROM track_ratings ORDER BY rated_at ASC`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list ratings: %w", err)
+	}
+	defer rows.Close()
+
+	var ratings []*TrackRating
+	for rows.Next() {
+		tr := &TrackRating{}
+		if err := rows.Scan(&tr.TrackID, &tr.Rating, &tr.RatedAt); err != nil {
+			return nil, fmt.Errorf("scan rating: %w", err)
+		}
+		ratings = append(ratings, tr)
+	}
+	if ratings == nil {
+		ratings = []*TrackRating{}
+	}
+	return ratings, nil
+}
+
+// --- System Config ---
+
+// ListSystemConfig returns all stored settings.
+func (r *Repository) ListSystemConfig(ctx context.Context) (map[string]string, error) {
+	rows, err := r.rdb.QueryContext(ctx, `SELECT key, value FROM system_config`)
+	if err != nil {
+		return nil, fmt.Errorf("list system config: %w", err)
+	}
+	defer rows.Close()
+
+	settings := make(map[string]string)
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, fmt.Errorf("scan system config: %w", err)
+		}
+		settings[k] = v
+	}
+	return settings, nil
+}
+
+// SetSystemConfig stores a setting.
+func (r *Repository) SetSystemConfig(ctx context.Context, key, value string) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO system_config (key, value, updated_at)
+		 VALUES (?, ?, CURRENT_TIMESTAMP)
+		 ON CONFLICT(key) DO UPDATE SET
+		   value = excluded.value,
+		   updated_at = CURRENT_TIMESTAMP`,
+		key, value,
+	)
+	if err != nil {
+		return fmt.Errorf("set system config %s: %w", key, err)
+	}
+	return nil
+}
+
+// --- Import History ---
+
+// RecordImport stores the outcome of importing one file from the inbox.
+func (r *Repository) RecordImport(ctx context.Context, rec *ImportRecord) error {
+	if rec.ID == "" {
+		rec.ID = uuid.New().String()
+	}
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO import_history (id, source, file_name, status, reason, dest_path)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		rec.ID, rec.Source, rec.FileName, rec.Status, rec.Reason, rec.DestPath,
+	)
+	if err != nil {
+		return fmt.Errorf("record import: %w", err)
+	}
+	return nil
+}
+
+// ListImports returns import history, newest first, optionally filtered by status.
+func (r *Repository) ListImports(ctx context.Context, status string, limit, offset int) ([]*ImportRecord, int64, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	var total int64
+	if err := r.rdb.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM import_history WHERE ? = '' OR status = ?`, status, status,
+	).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count imports: %w", err)
+	}
+
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT id, source, file_name, status, reason, dest_path, imported_at
+		 FROM import_history
+		 WHERE ? = '' OR status = ?
+		 ORDER BY imported_at DESC
+		 LIMIT ? OFFSET ?`, status, status, limit, offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list imports: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*ImportRecord
+	for rows.Next() {
+		rec := &ImportRecord{}
+		if err := rows.Scan(&rec.ID, &rec.Source, &rec.FileName, &rec.Status, &rec.Reason,
+			&rec.DestPath, &rec.ImportedAt); err != nil {
+			return nil, 0, fmt.Errorf("scan import: %w", err)
+		}
+		records = append(records, rec)
+	}
+	if records == nil {
+		records = []*ImportRecord{}
+	}
+	return records, total, nil
+}
+
+// --- Integrity ---
+
+// SaveIntegrityResult stores the latest verification outcome for a track.
+func (r *Repository) SaveIntegrityResult(ctx context.Context, trackID, status, detail string) error {
+	// Stamped with the app's clock, as ListTracksDueForVerification's cutoff
+	// is, so a database clock running behind can't leave checked tracks due
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO track_integrity (track_id, status, detail, checked_at)
+		 VALUES (?, ?, ?, ?)
+		 ON CONFLICT(track_id) DO UPDATE SET
+		   status = excluded.status,
+		   detail = excluded.detail,
+		   checked_at = excluded.checked_at`,
+		trackID, status, detail, r.timeArg(time.Now()),
+	)
+	if err != nil {
+		return fmt.Errorf("save integrity result: %w", err)
+	}
+	return nil
+}
+
+// ListTracksDueForVerification returns tracks never verified or last verified
+// before the given time, unverified ones first, then oldest first.
+func (r *Repository) ListTracksDueForVerification(ctx context.Context, before time.Time, limit int) ([]*Track, error) {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT t.id, t.album_id, t.artist_id, t.title, t.track_number, t.disc_number,
+		        t.duration_seconds, t.file_path, t.file_size, t.format,
+		        t.sample_rate, t.bit_depth, t.channels, t.bitrate,
+		        t.created_at, t.updated_at,
+		        ar.name as artist_name, al.title as album_title, al.cover_path
+		 FROM tracks t
+		 JOIN artists ar ON ar.id = t.artist_id
+		 JOIN albums al ON al.id = t.album_id
+		 LEFT JOIN track_integrity ti ON ti.track_id = t.id
+		 WHERE ti.checked_at IS NULL OR ti.checked_at < ?
+		 ORDER BY ti.checked_at IS NOT NULL, ti.checked_at
+		 LIMIT ?`, r.timeArg(before), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list tracks due for verification: %w", err)
+	}
+	defer rows.Close()
+
+	return r.scanTracks(rows)
+}
+
+// ListIntegrityResults returns verification results, problems first,
+// optionally filtered by status.
+func (r *Repository) ListIntegrityResults(ctx context.Context, status string, limit, offset int) ([]*IntegrityResult, int64, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	var total int64
+	if err := r.rdb.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM track_integrity WHERE ? = '' OR status = ?`, status, status,
+	).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count integrity results: %w", err)
+	}
+
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT ti.track_id, ti.status, ti.detail, ti.checked_at,
+		        t.title, ar.name, al.title, t.format, t.file_path
+		 FROM track_integrity ti
+		 JOIN tracks t ON t.id = ti.track_id
+		 JOIN artists ar ON ar.id = t.artist_id
+		 JOIN albums al ON al.id = t.album_id
+		 WHERE ? = '' OR ti.status = ?
+		 ORDER BY CASE ti.status WHEN 'corrupt' THEN 0 WHEN 'truncated' THEN 1 WHEN 'missing' THEN 2 ELSE 3 END,
+		          ti.checked_at DESC
+		 LIMIT ? OFFSET ?`, status, status, limit, offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list integrity results: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*IntegrityResult
+	for rows.Next() {
+		ir := &IntegrityResult{}
+		if err := rows.Scan(&ir.TrackID, &ir.Status, &ir.Detail, &ir.CheckedAt,
+			&ir.Title, &ir.ArtistName, &ir.AlbumTitle, &ir.Format, &ir.FilePath); err != nil {
+			return nil, 0, fmt.Errorf("scan integrity result: %w", err)
+		}
+		results = append(results, ir)
+	}
+	if results == nil {
+		results = []*IntegrityResult{}
+	}
+	return results, total, nil
+}
+
+// CountIntegrityStatuses returns the number of tracks per verification status,
+// including tracks that were never verified under "unchecked".
+func (r *Repository) CountIntegrityStatuses(ctx context.Context) (map[string]int64, error) {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT COALESCE(ti.status, 'unchecked'), COUNT(*)
+		 FROM tracks t
+		 LEFT JOIN track_integrity ti ON ti.track_id = t.id
+		 GROUP BY 1`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("count integrity statuses: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var status string
+		var n int64
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, fmt.Errorf("scan integrity status: %w", err)
+		}
+		counts[status] = n
+	}
+	return counts, nil
+}
+
+// --- Duplicates ---
+
+// SaveMusicBrainzID stores the MusicBrainz recording ID read from a track's tags.
+func (r *Repository) SaveMusicBrainzID(ctx context.Context, trackID, mbid string) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO track_identifiers (track_id, mb_recording_id)
+		 VALUES (?, ?)
+		 ON CONFLICT(track_id) DO UPDATE SET
+		   mb_recording_id = excluded.mb_recording_id,
+		   updated_at = CURRENT_TIMESTAMP`,
+		trackID, mbid,
+	)
+	if err != nil {
+		return fmt.Errorf("save musicbrainz id: %w", err)
+	}
+	return nil
+}
+
+// SaveFingerprint stores a track's raw audio fingerprint.
+func (r *Repository) SaveFingerprint(ctx context.Context, trackID, fingerprint string) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO track_identifiers (track_id, fingerprint)
+		 VALUES (?, ?)
+		 ON CONFLICT(track_id) DO UPDATE SET
+		   fingerprint = excluded.fingerprint,
+		   updated_at = CURRENT_TIMESTAMP`,
+		trackID, fingerprint,
+	)
+	if err != nil {
+		return fmt.Errorf("save fingerprint: %w", err)
+	}
+	return nil
+}
+
+// ListTrackIdentifiers returns the stored identifiers of all tracks, keyed by track ID.
+func (r *Repository) ListTrackIdentifiers(ctx context.Context) (map[string]*TrackIdentifiers, error) {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT track_id, COALESCE(mb_recording_id, ''), COALESCE(fingerprint, '')
+		 FROM track_identifiers`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list track identifiers: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]*TrackIdentifiers)
+	for rows.Next() {
+		ti := &TrackIdentifiers{}
+		if err := rows.Scan(&ti.TrackID, &ti.MBRecordingID, &ti.Fingerprint); err != nil {
+			return nil, fmt.Errorf("scan track identifiers: %w", err)
+		}
+		ids[ti.TrackID] = ti
+	}
+	return ids, nil
+}
+
+// ReplaceDuplicateGroups swaps the stored duplicate groups of one kind for a
+// freshly computed set.
+func (r *Repository) ReplaceDuplicateGroups(ctx context.Context, kind string, groups []*DuplicateGroup) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM duplicate_groups WHERE kind = ?`, kind); err != nil {
+		return fmt.Errorf("clear duplicate groups: %w", err)
+	}
+	for _, g := range groups {
+		if g.ID == "" {
+			g.ID = uuid.New().String()
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO duplicate_groups (id, kind, reason, preferred_id) VALUES (?, ?, ?, ?)`,
+			g.ID, kind, g.Reason, g.PreferredID,
+		); err != nil {
+			return fmt.Errorf("insert duplicate group: %w", err)
+		}
+		for _, m := range g.Members {
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO duplicate_members (group_id, entity_id, preferred, quality_score)
+				 VALUES (?, ?, ?, ?)`,
+				g.ID, m.EntityID, m.Preferred, m.QualityScore,
+			); err != nil {
+				return fmt.Errorf("insert duplicate member: %w", err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// ListDuplicateGroups returns duplicate groups of one kind with their members
+// joined to the underlying tracks or albums, preferred copy first.
+func (r *Repository) ListDuplicateGroups(ctx context.Context, kind string, limit, offset int) ([]*DuplicateGroup, int64, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	var total int64
+	if err := r.rdb.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM duplicate_groups WHERE kind = ?`, kind,
+	).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count duplicate groups: %w", err)
+	}
+
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT id, kind, reason, preferred_id, created_at
+		 FROM duplicate_groups
+		 WHERE kind = ?
+		 ORDER BY created_at DESC, id ASC
+		 LIMIT ? OFFSET ?`, kind, limit, offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list duplicate groups: %w", err)
+	}
+	var groups []*DuplicateGroup
+	for rows.Next() {
+		g := &DuplicateGroup{}
+		if err := rows.Scan(&g.ID, &g.Kind, &g.Reason, &g.PreferredID, &g.CreatedAt); err != nil {
+			rows.Close()
+			return nil, 0, fmt.Errorf("scan duplicate group: %w", err)
+		}
+		groups = append(groups, g)
+	}
+	rows.Close()
+
+	for _, g := range groups {
+		if err := r.loadDuplicateMembers(ctx, g); err != nil {
+			return nil, 0, err
+		}
+	}
+	if groups == nil {
+		groups = []*DuplicateGroup{}
+	}
+	return groups, total, nil
+}
+
+func (r *Repository) loadDuplicateMembers(ctx context.Context, g *DuplicateGroup) error {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT entity_id, preferred, quality_score
+		 FROM duplicate_members
+		 WHERE group_id = ?
+		 ORDER BY preferred DESC, quality_score DESC`, g.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("list duplicate members: %w", err)
+	}
+	for rows.Next() {
+		m := &DuplicateMember{}
+		if err := rows.Scan(&m.EntityID, &m.Preferred, &m.QualityScore); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan duplicate member: %w", err)
+		}
+		g.Members = append(g.Members, m)
+	}
+	rows.Close()
+
+	for _, m := range g.Members {
+		switch g.Kind {
+		case "track":
+			m.Track, _ = r.GetTrackByID(ctx, m.EntityID)
+		case "album":
+			m.Album, _ = r.GetAlbumByID(ctx, m.EntityID)
+		}
+	}
+	return nil
+}
+
+// --- Library Health ---
+
+// SetTitleFromFilename records whether a track's title fell back to its file name.
+func (r *Repository) SetTitleFromFilename(ctx context.Context, trackID string, fromFilename bool) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO track_tag_fallbacks (track_id, title_from_filename, updated_at)
+		 VALUES (?, ?, CURRENT_TIMESTAMP)
+		 ON CONFLICT(track_id) DO UPDATE SET
+		   title_from_filename = excluded.title_from_filename,
+		   updated_at = CURRENT_TIMESTAMP`,
+		trackID, fromFilename,
+	)
+	if err != nil {
+		return fmt.Errorf("set title fallback %s: %w", trackID, err)
+	}
+	return nil
+}
+
+// healthQueries selects the issues of each type. Every query yields the same
+// named columns so they can be combined into one paginated report. Albums that fell
+// back to "Unknown Album" are skipped by the album checks, since their tracks
+// are already reported individually.
+var healthQueries = map[string]string{
+	IssueUnknownArtist: `SELECT 'unknown_artist' AS issue, 'track' AS entity_type, t.id AS entity_id,
+		        t.title AS title, ar.id AS artist_id, ar.name AS artist_name,
+		        al.title AS detail, t.file_path AS file_path
+		 FROM tracks t
+		 JOIN artists ar ON ar.id = t.artist_id
+		 JOIN albums al ON al.id = t.album_id
+		 WHERE ar.name = 'Unknown Artist'`,
+	IssueUnknownAlbum: `SELECT 'unknown_album' AS issue, 'track' AS entity_type, t.id AS entity_id,
+		        t.title AS title, ar.id AS artist_id, ar.name AS artist_name,
+		        CAST(NULL AS TEXT) AS detail, t.file_path AS file_path
+		 FROM tracks t
+		 JOIN artists ar ON ar.id = t.artist_id
+		 JOIN albums al ON al.id = t.album_id
+		 WHERE al.title = 'Unknown Album'`,
+	IssueTitleFromFilename: `SELECT 'title_from_filename' AS issue, 'track' AS entity_type, t.id AS entity_id,
+		        t.title AS title, ar.id AS artist_id, ar.name AS artist_name,
+		        al.title AS detail, t.file_path AS file_path
+		 FROM tracks t
+		 JOIN track_tag_fallbacks f ON f.track_id = t.id
+		 JOIN artists ar ON ar.id = t.artist_id
+		 JOIN albums al ON al.id = t.album_id
+		 WHERE f.title_from_filename = 1`,
+	IssueMissingCover: `SELECT 'missing_cover' AS issue, 'album' AS entity_type, al.id AS entity_id,
+		        al.title AS title, ar.id AS artist_id, ar.name AS artist_name,
+		        CAST(al.track_count AS TEXT) || ' tracks' AS detail, CAST(NULL AS TEXT) AS file_path
+		 FROM albums al
+		 JOIN artists ar ON ar.id = al.artist_id
+		 WHERE al.cover_path IS NULL AND al.track_count > 0 AND al.title <> 'Unknown Album'`,
+	IssueTrackGaps: `SELECT 'track_gaps' AS issue, 'album' AS entity_type, al.id AS entity_id,
+		        al.title AS title, ar.id AS artist_id, ar.name AS artist_name,
+		        'disc ' || CAST(t.disc_number AS TEXT) || ': ' ||
+		        CAST(COUNT(DISTINCT t.track_number) AS TEXT) || ' of ' || CAST(MAX(t.track_number) AS TEXT) || ' tracks' AS detail, CAST(NULL AS TEXT) AS file_path
+		 FROM tracks t
+		 JOIN albums al ON al.id = t.album_id
+		 JOIN artists ar ON ar.id = al.artist_id
+		 WHERE t.track_number IS NOT NULL AND al.title <> 'Unknown Album'
+		 GROUP BY al.id, al.title, ar.id, ar.name, t.disc_number
+		 HAVING COUNT(DISTINCT t.track_number) < MAX(t.track_number)`,
+	IssueMixedFormats: `SELECT 'mixed_formats' AS issue, 'album' AS entity_type, al.id AS entity_id,
+		        al.title AS title, ar.id AS artist_id, ar.name AS artist_name,
+		        CAST(COUNT(DISTINCT t.format) AS TEXT) || ' formats' AS detail, CAST(NULL AS TEXT) AS file_path
+		 FROM tracks t
+		 JOIN albums al ON al.id = t.album_id
+		 JOIN artists ar ON ar.id = al.artist_id
+		 WHERE al.title <> 'Unknown Album'
+		 GROUP BY al.id, al.title, ar.id, ar.name
+		 HAVING COUNT(DISTINCT t.format) > 1`,
+	IssueMixedSampleRates: `SELECT 'mixed_sample_rates' AS issue, 'album' AS entity_type, al.id AS entity_id,
+		        al.title AS title, ar.id AS artist_id, ar.name AS artist_name,
+		        CAST(MIN(t.sample_rate) AS TEXT) || '-' || CAST(MAX(t.sample_rate) AS TEXT) || ' Hz' AS detail, CAST(NULL AS TEXT) AS file_path
+		 FROM tracks t
+		 JOIN albums al ON al.id = t.album_id
+		 JOIN artists ar ON ar.id = al.artist_id
+		 WHERE al.title <> 'Unknown Album'
+		 GROUP BY al.id, al.title, ar.id, ar.name
+		 HAVING COUNT(DISTINCT t.sample_rate) > 1`,
+}
+
+// healthReportQuery combines the queries for the given issue types, or for
+// all types when issueType is empty.
+func healthReportQuery(issueType string) (string, error) {
+	if issueType != "" {
+		q, ok := healthQueries[issueType]
+		if !ok {
+			return "", fmt.Errorf("unknown issue type %q", issueType)
+		}
+		return q, nil
+	}
+	parts := make([]string, 0, len(HealthIssueTypes))
+	for _, t := range HealthIssueTypes {
+		parts = append(parts, healthQueries[t])
+	}
+	return strings.Join(parts, "\n UNION ALL\n "), nil
+}
+
+// ListHealthIssues returns library problems, optionally filtered by issue type
+// and artist, ordered by type, artist and title.
+func (r *Repository) ListHealthIssues(ctx context.Context, issueType, artistID string, limit, offset int) ([]*HealthIssue, int64, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	report, err := healthReportQuery(issueType)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	if err := r.rdb.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM (`+report+`) issues WHERE ? = '' OR artist_id = ?`, artistID, artistID,
+	).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count health issues: %w", err)
+	}
+
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT issue, entity_type, entity_id, title, artist_id, artist_name, detail, file_path
+		 FROM (`+report+`) issues
+		 WHERE ? = '' OR artist_id = ?
+		 ORDER BY issue, artist_name, title, entity_id, detail
+		 LIMIT ? OFFSET ?`, artistID, artistID, limit, offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list health issues: %w", err)
+	}
+	defer rows.Close()
+
+	var issues []*HealthIssue
+	for rows.Next() {
+		hi := &HealthIssue{}
+		if err := rows.Scan(&hi.Type, &hi.EntityType, &hi.EntityID, &hi.Title,
+			&hi.ArtistID, &hi.ArtistName, &hi.Detail, &hi.FilePath); err != nil {
+			return nil, 0, fmt.Errorf("scan health issue: %w", err)
+		}
+		issues = append(issues, hi)
+	}
+	if issues == nil {
+		issues = []*HealthIssue{}
+	}
+	return issues, total, nil
+}
+
+// CountHealthIssues returns the number of issues of each type.
+func (r *Repository) CountHealthIssues(ctx context.Context) (map[string]int64, error) {
+	report, _ := healthReportQuery("")
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT issue, COUNT(*) FROM (`+report+`) issues GROUP BY issue`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("count health issues: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64, len(HealthIssueTypes))
+	for _, t := range HealthIssueTypes {
+		counts[t] = 0
+	}
+	for rows.Next() {
+		var issue string
+		var n int64
+		if err := rows.Scan(&issue, &n); err != nil {
+			return nil, fmt.Errorf("scan health issue count: %w", err)
+		}
+		counts[issue] = n
+	}
+	return counts, nil
+}
+
 // --- Stats ---
 
 // CountEntities returns total counts of artists, albums, and tracks.
 func (r *Repository) CountEntities(ctx context.Context, artists, albums, tracks *int64) {
-	r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM artists`).Scan(artists)
-	r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM albums`).Scan(albums)
-	r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM tracks`).Scan(tracks)
+	r.rdb.QueryRowContext(ctx, `SELECT COUNT(*) FROM artists`).Scan(artists)
+	r.rdb.QueryRowContext(ctx, `SELECT COUNT(*) FROM albums`).Scan(albums)
+	r.rdb.QueryRowContext(ctx, `SELECT COUNT(*) FROM tracks`).Scan(tracks)
+}
+
+// timeArg binds a time for comparison with timestamp columns. SQLite stores
+// CURRENT_TIMESTAMP as UTC text, so times are formatted the same way there.
+func (r *Repository) timeArg(t time.Time) any {
+	if r.db.driver == DriverPostgres {
+		return t.UTC()
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
 }
@@ -0,0 +1,479 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Every change to a playlist's name, description, rules or entries is
+// recorded as a revision holding a full snapshot of that state, written in
+// the same transaction as the change. Changes that leave the snapshot as it
+// was (e.g. a live smart playlist refresh that selects the same tracks) are
+// not recorded. Deleting a playlist only marks it deleted; restoring any
+// revision, including the one recorded on delete, brings it back. Deleted
+// playlists and their revisions are purged after a retention period.
+
+// Revision actions.
+const (
+	RevisionBaseline = "baseline" // state found when revisions were introduced
+	RevisionCreate   = "create"
+	RevisionEdit     = "edit" // name, description or rules
+	RevisionAdd      = "add"
+	RevisionRemove   = "remove"
+	RevisionMove     = "move"
+	RevisionRefresh  = "refresh" // smart playlist re-evaluated
+	RevisionImport   = "import"
+	RevisionDelete   = "delete"
+	RevisionRestore  = "restore"
+)
+
+// PlaylistSnapshot is a playlist's content at one revision.
+type PlaylistSnapshot struct {
+	Name        string           `json:"name"`
+	Description *string          `json:"description,omitempty"`
+	Rules       *SmartRules      `json:"rules,omitempty"`
+	Entries     []*SnapshotEntry `json:"entries"`
+}
+
+// SnapshotEntry is one entry of a playlist snapshot, in order.
+type SnapshotEntry struct {
+	TrackID string    `json:"track_id"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// PlaylistDiff lists what changed from one revision to another. Entries are
+// compared by track, so moving a track shows as reordering rather than a
+// removal and an addition.
+type PlaylistDiff struct {
+	From        int          `json:"from"`
+	To          int          `json:"to"`
+	Name        *FieldChange `json:"name,omitempty"`
+	Description *FieldChange `json:"description,omitempty"`
+	Rules       *FieldChange `json:"rules,omitempty"`
+	Added       []*DiffEntry `json:"added"`
+	Removed     []*DiffEntry `json:"removed"`
+	Reordered   bool         `json:"reordered"` // tracks in both revisions are in a different order
+}
+
+// FieldChange is a playlist field's value before and after.
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// DiffEntry is an added or removed entry with its 1-based position in the
+// revision that has it. Missing marks tracks no longer in the library.
+type DiffEntry struct {
+	Position int    `json:"position"`
+	Track    *Track `json:"track"`
+	Missing  bool   `json:"missing,omitempty"`
+}
+
+// loadSnapshot reads a playlist's current content, deleted or not.
+func loadSnapshot(ctx context.Context, t *tx, id string) (*PlaylistSnapshot, error) {
+	s := &PlaylistSnapshot{Entries: []*SnapshotEntry{}}
+	var rules sql.NullString
+	err := t.QueryRowContext(ctx,
+		`SELECT name, description, rules FROM playlists WHERE id = ?`, id,
+	).Scan(&s.Name, &s.Description, &rules)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("playlist %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get playlist %s: %w", id, err)
+	}
+	if rules.Valid {
+		s.Rules = &SmartRules{}
+		if err := json.Unmarshal([]byte(rules.String), s.Rules); err != nil {
+			return nil, fmt.Errorf("playlist %s rules: %w", id, err)
+		}
+	}
+
+	rows, err := t.QueryContext(ctx,
+		`SELECT track_id, added_at FROM playlist_tracks WHERE playlist_id = ?
+		 ORDER BY position, added_at, id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("list playlist entries: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &SnapshotEntry{}
+		if err := rows.Scan(&e.TrackID, &e.AddedAt); err != nil {
+			return nil, fmt.Errorf("scan playlist entry: %w", err)
+		}
+		e.AddedAt = e.AddedAt.UTC()
+		s.Entries = append(s.Entries, e)
+	}
+	return s, rows.Err()
+}
+
+// recordRevision snapshots a playlist as a new revision unless its content
+// is unchanged since the last one. Deletes and restores are always
+// recorded.
+func recordRevision(ctx context.Context, t *tx, id, action string) error {
+	snap, err := loadSnapshot(ctx, t, id)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("encode playlist snapshot: %w", err)
+	}
+
+	var last int
+	var lastData string
+	err = t.QueryRowContext(ctx,
+		`SELECT revision, snapshot FROM playlist_revisions WHERE playlist_id = ?
+		 ORDER BY revision DESC LIMIT 1`, id,
+	).Scan(&last, &lastData)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("last playlist revision: %w", err)
+	}
+	if lastData == string(data) && action != RevisionDelete && action != RevisionRestore {
+		return nil
+	}
+
+	if _, err := t.ExecContext(ctx,
+		`INSERT INTO playlist_revisions (id, playlist_id, revision, action, name, track_count, snapshot)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		uuid.New().String(), id, last+1, action, snap.Name, len(snap.Entries), string(data),
+	); err != nil {
+		return fmt.Errorf("record playlist revision: %w", err)
+	}
+	return nil
+}
+
+// RecordBaselineRevisions gives every playlist without revisions one holding
+// its current state, so changes made since can be undone.
+func (r *Repository) RecordBaselineRevisions(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin baseline revisions: %w", err)
+	}
+	defer tx.Rollback()
+
+	ids, err := queryIDs(ctx, tx,
+		`SELECT id FROM playlists p
+		 WHERE NOT EXISTS (SELECT 1 FROM playlist_revisions pr WHERE pr.playlist_id = p.id)`)
+	if err != nil {
+		return 0, fmt.Errorf("list playlists without revisions: %w", err)
+	}
+	for _, id := range ids {
+		if err := recordRevision(ctx, tx, id, RevisionBaseline); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), tx.Commit()
+}
+
+// revisionColumns are the columns scanRevision reads.
+const revisionColumns = `playlist_id, revision, action, name, track_count, created_at`
+
+func scanRevision(row interface{ Scan(...any) error }) (*PlaylistRevision, error) {
+	rev := &PlaylistRevision{}
+	if err := row.Scan(&rev.PlaylistID, &rev.Revision, &rev.Action, &rev.Name, &rev.TrackCount, &rev.CreatedAt); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+// ListPlaylistRevisions returns a playlist's revisions, newest first. Deleted
+// playlists keep theirs until purged.
+func (r *Repository) ListPlaylistRevisions(ctx context.Context, playlistID string) ([]*PlaylistRevision, error) {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT `+revisionColumns+` FROM playlist_revisions WHERE playlist_id = ?
+		 ORDER BY revision DESC`, playlistID)
+	if err != nil {
+		return nil, fmt.Errorf("list playlist revisions: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := []*PlaylistRevision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan playlist revision: %w", err)
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list playlist revisions: %w", err)
+	}
+	if len(revisions) == 0 {
+		var n int
+		if err := r.rdb.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM playlists WHERE id = ?`, playlistID,
+		).Scan(&n); err != nil {
+			return nil, fmt.Errorf("get playlist %s: %w", playlistID, err)
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("playlist %s: %w", playlistID, ErrNotFound)
+		}
+	}
+	return revisions, nil
+}
+
+// GetPlaylistRevision returns one revision with its snapshot; revision 0 is
+// the latest.
+func (r *Repository) GetPlaylistRevision(ctx context.Context, playlistID string, revision int) (*PlaylistRevision, error) {
+	query := `SELECT ` + revisionColumns + `, snapshot FROM playlist_revisions WHERE playlist_id = ?`
+	args := []any{playlistID}
+	if revision > 0 {
+		query += ` AND revision = ?`
+		args = append(args, revision)
+	}
+	query += ` ORDER BY revision DESC LIMIT 1`
+
+	rev := &PlaylistRevision{}
+	var data string
+	err := r.rdb.QueryRowContext(ctx, query, args...).Scan(
+		&rev.PlaylistID, &rev.Revision, &rev.Action, &rev.Name, &rev.TrackCount, &rev.CreatedAt, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("playlist %s revision %d: %w", playlistID, revision, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get playlist revision: %w", err)
+	}
+	rev.Snapshot = &PlaylistSnapshot{}
+	if err := json.Unmarshal([]byte(data), rev.Snapshot); err != nil {
+		return nil, fmt.Errorf("decode playlist revision %d: %w", rev.Revision, err)
+	}
+	return rev, nil
+}
+
+// DiffPlaylistRevisions compares two revisions of a playlist. to 0 is the
+// latest revision; from 0 is an empty playlist and a negative from the
+// revision before to.
+func (r *Repository) DiffPlaylistRevisions(ctx context.Context, playlistID string, from, to int) (*PlaylistDiff, error) {
+	after, err := r.GetPlaylistRevision(ctx, playlistID, to)
+	if err != nil {
+		return nil, err
+	}
+	if from < 0 {
+		from = after.Revision - 1
+	}
+	before := &PlaylistSnapshot{}
+	if from > 0 {
+		rev, err := r.GetPlaylistRevision(ctx, playlistID, from)
+		if err != nil {
+			return nil, err
+		}
+		before = rev.Snapshot
+	}
+
+	diff := diffSnapshots(before, after.Snapshot)
+	diff.From, diff.To = from, after.Revision
+
+	changed := slices.Concat(diff.Added, diff.Removed)
+	if len(changed) == 0 {
+		return diff, nil
+	}
+	trackIDs := make([]string, len(changed))
+	for i, e := range changed {
+		trackIDs[i] = e.Track.ID
+	}
+	tracks, err := r.tracksByIDs(ctx, trackIDs)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*Track, len(tracks))
+	for _, t := range tracks {
+		byID[t.ID] = t
+	}
+	for _, e := range changed {
+		if t := byID[e.Track.ID]; t != nil {
+			e.Track = t
+		} else {
+			e.Track.Title = missingTrackTitle
+			e.Missing = true
+		}
+	}
+	return diff, nil
+}
+
+// diffSnapshots compares two snapshots. Entries carry only a track ID.
+func diffSnapshots(before, after *PlaylistSnapshot) *PlaylistDiff {
+	diff := &PlaylistDiff{Added: []*DiffEntry{}, Removed: []*DiffEntry{}}
+	if before.Name != after.Name {
+		diff.Name = &FieldChange{From: before.Name, To: after.Name}
+	}
+	if deref(before.Description) != deref(after.Description) {
+		diff.Description = &FieldChange{From: before.Description, To: after.Description}
+	}
+	rulesBefore, _ := json.Marshal(before.Rules)
+	rulesAfter, _ := json.Marshal(after.Rules)
+	if string(rulesBefore) != string(rulesAfter) {
+		diff.Rules = &FieldChange{From: before.Rules, To: after.Rules}
+	}
+
+	// unmatched pairs each entry with one of the same track on the other
+	// side, so duplicates are counted; the rest were added or removed
+	unmatched := func(entries, other []*SnapshotEntry) (kept []string, changed []*DiffEntry) {
+		left := make(map[string]int, len(other))
+		for _, e := range other {
+			left[e.TrackID]++
+		}
+		for i, e := range entries {
+			if left[e.TrackID] > 0 {
+				left[e.TrackID]--
+				kept = append(kept, e.TrackID)
+				continue
+			}
+			changed = append(changed, &DiffEntry{Position: i + 1, Track: &Track{ID: e.TrackID}})
+		}
+		return kept, changed
+	}
+	keptBefore, removed := unmatched(before.Entries, after.Entries)
+	keptAfter, added := unmatched(after.Entries, before.Entries)
+	diff.Removed = append(diff.Removed, removed...)
+	diff.Added = append(diff.Added, added...)
+	diff.Reordered = !slices.Equal(keptBefore, keptAfter)
+	return diff
+}
+
+// RestorePlaylistRevision returns a playlist to a revision's name,
+// description, rules and entries, undeleting it if needed; revision 0 is the
+// latest, which undoes a delete. Entries whose track is gone from the library
+// are left out. The restore is recorded as a new revision.
+func (r *Repository) RestorePlaylistRevision(ctx context.Context, playlistID string, revision int) (*Playlist, error) {
+	rev, err := r.GetPlaylistRevision(ctx, playlistID, revision)
+	if err != nil {
+		return nil, err
+	}
+	snap := rev.Snapshot
+	var rules *string
+	if snap.Rules != nil {
+		data, err := marshalRules(snap.Rules)
+		if err != nil {
+			return nil, err
+		}
+		rules = &data
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin restore revision: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE playlists SET name = ?, description = ?, rules = ?, deleted_at = NULL,
+		   updated_at = CURRENT_TIMESTAMP
+		 WHERE id = ?`, snap.Name, snap.Description, rules, playlistID)
+	if err != nil {
+		return nil, fmt.Errorf("restore playlist: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("playlist %s: %w", playlistID, ErrNotFound)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM playlist_tracks WHERE playlist_id = ?`, playlistID); err != nil {
+		return nil, fmt.Errorf("clear playlist: %w", err)
+	}
+	position := 0
+	for _, e := range snap.Entries {
+		if err := requireRow(ctx, tx, "tracks", e.TrackID); errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		position++
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO playlist_tracks (id, playlist_id, track_id, position, added_at) VALUES (?, ?, ?, ?, ?)`,
+			uuid.New().String(), playlistID, e.TrackID, position, r.timeArg(e.AddedAt),
+		); err != nil {
+			return nil, fmt.Errorf("restore playlist entry: %w", err)
+		}
+	}
+	if err := updatePlaylistStats(ctx, tx, playlistID); err != nil {
+		return nil, err
+	}
+	if err := recordRevision(ctx, tx, playlistID, RevisionRestore); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit restore revision: %w", err)
+	}
+	r.playlistChanged(playlistID)
+	return r.GetPlaylistByID(ctx, playlistID)
+}
+
+// ListDeletedPlaylists returns playlists awaiting purge, most recently
+// deleted first.
+func (r *Repository) ListDeletedPlaylists(ctx context.Context) ([]*Playlist, error) {
+	rows, err := r.rdb.QueryContext(ctx,
+		`SELECT `+playlistColumns+` FROM playlists WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("list deleted playlists: %w", err)
+	}
+	defer rows.Close()
+
+	playlists := []*Playlist{}
+	for rows.Next() {
+		p, err := scanPlaylist(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan playlist: %w", err)
+		}
+		playlists = append(playlists, p)
+	}
+	return playlists, rows.Err()
+}
+
+// PurgePlaylist deletes a playlist, deleted or not, with its entries and
+// revisions for good.
+func (r *Repository) PurgePlaylist(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM playlists WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("purge playlist: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("playlist %s: %w", id, ErrNotFound)
+	}
+	r.playlistChanged(id)
+	return nil
+}
+
+// PurgeDeletedPlaylists purges playlists deleted before the given time and
+// returns how many there were.
+func (r *Repository) PurgeDeletedPlaylists(ctx context.Context, before time.Time) (int, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id FROM playlists WHERE deleted_at IS NOT NULL AND deleted_at < ?`, r.timeArg(before))
+	if err != nil {
+		return 0, fmt.Errorf("list expired playlists: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan playlist: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("list expired playlists: %w", err)
+	}
+
+	// One at a time, rechecked, so a playlist restored meanwhile is kept
+	purged := 0
+	for _, id := range ids {
+		res, err := r.db.ExecContext(ctx,
+			`DELETE FROM playlists WHERE id = ? AND deleted_at IS NOT NULL AND deleted_at < ?`,
+			id, r.timeArg(before))
+		if err != nil {
+			return purged, fmt.Errorf("purge playlist: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			purged++
+			r.playlistChanged(id)
+		}
+	}
+	return purged, nil
+}
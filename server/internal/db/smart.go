@@ -0,0 +1,379 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Smart playlists hold rules instead of hand-picked entries. The rules are
+// stored as JSON on the playlist and compiled to a query over tracks,
+// albums, play history and ratings. Refreshing a smart playlist replaces its
+// entries with the tracks the query selects, so every playlist endpoint reads
+// it like any other.
+
+// ErrInvalidRules is returned for smart playlist rules that don't compile.
+var ErrInvalidRules = errors.New("invalid smart playlist rules")
+
+// ErrSmartPlaylist is returned when editing the entries of a smart playlist,
+// which follow its rules.
+var ErrSmartPlaylist = errors.New("smart playlist entries follow its rules")
+
+// ErrNotSmartPlaylist is returned when setting rules on a regular playlist.
+var ErrNotSmartPlaylist = errors.New("not a smart playlist")
+
+// RefreshLive re-evaluates a smart playlist whenever it is read.
+const RefreshLive = "live"
+
+// Limits on smart playlist rules.
+const (
+	maxSmartDepth   = 4 // nested groups
+	minSmartRefresh = time.Minute
+)
+
+// SmartRules define a smart playlist: the tracks matching all (or any) of
+// the rules, sorted and optionally limited. For example, rated 4 or more and
+// played in the last 90 days, 50 at random, reshuffled daily:
+//
+//	{"rules": [{"field": "rating", "op": "gte", "value": 4},
+//	           {"field": "last_played", "op": "in_last", "value": "90d"}],
+//	 "sort": "random", "limit": 50, "refresh": "1d"}
+type SmartRules struct {
+	Match string      `json:"match,omitempty"` // "all" (the default) or "any"
+	Rules []SmartRule `json:"rules"`
+	Sort  string      `json:"sort,omitempty"`  // a field or "random"; artist and album order by default
+	Order string      `json:"order,omitempty"` // "asc" (the default) or "desc"
+	Limit int         `json:"limit,omitempty"` // 0 for every match
+
+	HideDuplicates bool `json:"hide_duplicates,omitempty"`
+
+	// Refresh is "live" to re-evaluate whenever the playlist is read, or
+	// the time between scheduled refreshes, such as "1h" or "7d".
+	Refresh string `json:"refresh,omitempty"`
+}
+
+// SmartRule compares a field with a value, e.g. genre is Jazz. A rule with
+// Rules instead is a nested group, matched like the top level.
+type SmartRule struct {
+	Field string          `json:"field,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+
+	Match string      `json:"match,omitempty"`
+	Rules []SmartRule `json:"rules,omitempty"`
+}
+
+type smartKind int
+
+const (
+	smartText smartKind = iota
+	smartNumber
+	smartDate
+)
+
+type smartField struct {
+	expr string
+	kind smartKind
+	sort string // sort expression when it differs from expr
+}
+
+// smartFields are the fields rules can test and sort by, against smartFrom.
+var smartFields = map[string]smartField{
+	"title":        {expr: "t.title", kind: smartText},
+	"artist":       {expr: "ar.name", kind: smartText, sort: "ar.sort_name"},
+	"album":        {expr: "al.title", kind: smartText, sort: "al.sort_title"},
+	"genre":        {expr: "al.genre", kind: smartText},
+	"format":       {expr: "t.format", kind: smartText},
+	"path":         {expr: "t.file_path", kind: smartText},
+	"year":         {expr: "al.year", kind: smartNumber},
+	"duration":     {expr: "t.duration_seconds", kind: smartNumber},
+	"track_number": {expr: "t.track_number", kind: smartNumber},
+	"disc_number":  {expr: "t.disc_number", kind: smartNumber},
+	"sample_rate":  {expr: "t.sample_rate", kind: smartNumber},
+	"bit_depth":    {expr: "t.bit_depth", kind: smartNumber},
+	"bitrate":      {expr: "t.bitrate", kind: smartNumber},
+	"play_count":   {expr: "COALESCE(ph.plays, 0)", kind: smartNumber},
+	"rating":       {expr: "tr.rating", kind: smartNumber},
+	"added":        {expr: "t.created_at", kind: smartDate},
+	"last_played":  {expr: "ph.last_played", kind: smartDate},
+	"rated":        {expr: "tr.rated_at", kind: smartDate},
+}
+
+const smartFrom = `FROM tracks t
+		 JOIN artists ar ON ar.id = t.artist_id
+		 JOIN albums al ON al.id = t.album_id
+		 LEFT JOIN track_ratings tr ON tr.track_id = t.id
+		 LEFT JOIN (SELECT track_id, COUNT(*) AS plays, MAX(played_at) AS last_played
+		            FROM play_history GROUP BY track_id) ph ON ph.track_id = t.id`
+
+// smartDefaultOrder lists tracks album by album when the rules don't sort.
+const smartDefaultOrder = "ar.sort_name, al.year IS NULL, al.year, al.sort_title, al.id, t.disc_number, t.track_number, t.id"
+
+// Live reports whether the playlist is re-evaluated on every read.
+func (s *SmartRules) Live() bool {
+	return s.Refresh == "" || s.Refresh == RefreshLive
+}
+
+// Due reports whether a scheduled smart playlist last refreshed at
+// refreshedAt should be refreshed now. Live playlists are never due.
+func (s *SmartRules) Due(refreshedAt *time.Time, now time.Time) bool {
+	if s.Live() {
+		return false
+	}
+	if refreshedAt == nil {
+		return true
+	}
+	since, err := periodBefore(now, s.Refresh)
+	return err == nil && !refreshedAt.After(since)
+}
+
+// Validate checks that the rules compile and fills in defaults.
+func (s *SmartRules) Validate() error {
+	if s.Refresh == "" {
+		s.Refresh = RefreshLive
+	}
+	// A live shuffle would rewrite the playlist on every read
+	if s.Live() && s.Sort == SortRandom {
+		return fmt.Errorf("%w: random order needs a refresh interval such as \"1d\", not live", ErrInvalidRules)
+	}
+	if !s.Live() {
+		now := time.Now()
+		since, err := periodBefore(now, s.Refresh)
+		if err != nil {
+			return fmt.Errorf("%w: refresh: %v", ErrInvalidRules, err)
+		}
+		if now.Sub(since) < minSmartRefresh {
+			return fmt.Errorf("%w: refresh must be live or at least %s", ErrInvalidRules, minSmartRefresh)
+		}
+	}
+	_, _, err := smartQuery(s, func(t time.Time) any { return t }, time.Now())
+	return err
+}
+
+// smartQuery compiles rules to a query for the matching track IDs in
+// playlist order. timeArg binds times as the driver compares them.
+func smartQuery(s *SmartRules, timeArg func(time.Time) any, now time.Time) (string, []any, error) {
+	c := &smartCompiler{timeArg: timeArg, now: now}
+	where, err := c.group(s.Match, s.Rules, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	if s.HideDuplicates {
+		where = "(" + where + ") AND " + notDuplicateTrack
+	}
+
+	var dir string
+	switch strings.ToLower(s.Order) {
+	case "", "asc":
+		dir = "ASC"
+	case "desc":
+		dir = "DESC"
+	default:
+		return "", nil, fmt.Errorf("%w: order must be asc or desc", ErrInvalidRules)
+	}
+	order := smartDefaultOrder
+	switch s.Sort {
+	case "":
+	case SortRandom:
+		order = "RANDOM()"
+	default:
+		f, ok := smartFields[s.Sort]
+		if !ok {
+			return "", nil, fmt.Errorf("%w: cannot sort by %q", ErrInvalidRules, s.Sort)
+		}
+		expr := f.expr
+		if f.sort != "" {
+			expr = f.sort
+		}
+		// Tracks without the value go last either way
+		order = expr + " IS NULL, " + expr + " " + dir + ", " + smartDefaultOrder
+	}
+
+	query := `SELECT t.id ` + smartFrom + ` WHERE ` + where + ` ORDER BY ` + order
+	args := c.args
+	if s.Limit < 0 {
+		return "", nil, fmt.Errorf("%w: limit cannot be negative", ErrInvalidRules)
+	}
+	if s.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, s.Limit)
+	}
+	return query, args, nil
+}
+
+type smartCompiler struct {
+	timeArg func(time.Time) any
+	now     time.Time
+	args    []any
+}
+
+func (c *smartCompiler) group(match string, rules []SmartRule, depth int) (string, error) {
+	if depth > maxSmartDepth {
+		return "", fmt.Errorf("%w: groups nested more than %d deep", ErrInvalidRules, maxSmartDepth)
+	}
+	join := " AND "
+	switch strings.ToLower(match) {
+	case "", "all":
+	case "any":
+		join = " OR "
+	default:
+		return "", fmt.Errorf("%w: match must be all or any", ErrInvalidRules)
+	}
+	if len(rules) == 0 {
+		return "1 = 1", nil
+	}
+	conds := make([]string, 0, len(rules))
+	for i := range rules {
+		var cond string
+		var err error
+		if rules[i].Rules != nil {
+			cond, err = c.group(rules[i].Match, rules[i].Rules, depth+1)
+		} else {
+			cond, err = c.rule(&rules[i])
+		}
+		if err != nil {
+			return "", err
+		}
+		conds = append(conds, "("+cond+")")
+	}
+	return strings.Join(conds, join), nil
+}
+
+func (c *smartCompiler) rule(r *SmartRule) (string, error) {
+	f, ok := smartFields[r.Field]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown field %q", ErrInvalidRules, r.Field)
+	}
+	x := f.expr
+	bad := func(what string) error {
+		return fmt.Errorf("%w: %s %s needs %s", ErrInvalidRules, r.Field, r.Op, what)
+	}
+
+	switch r.Op {
+	case "empty":
+		return x + " IS NULL", nil
+	case "not_empty":
+		return x + " IS NOT NULL", nil
+	}
+
+	switch f.kind {
+	case smartText:
+		var v string
+		if err := json.Unmarshal(r.Value, &v); err != nil {
+			return "", bad("a string")
+		}
+		switch r.Op {
+		case "is":
+			c.args = append(c.args, v)
+			return "LOWER(" + x + ") = LOWER(?)", nil
+		case "is_not":
+			c.args = append(c.args, v)
+			return "LOWER(COALESCE(" + x + ", '')) <> LOWER(?)", nil
+		case "contains", "not_contains", "starts_with", "ends_with":
+			pattern := likeEscape(v)
+			switch r.Op {
+			case "starts_with":
+				pattern += "%"
+			case "ends_with":
+				pattern = "%" + pattern
+			default:
+				pattern = "%" + pattern + "%"
+			}
+			c.args = append(c.args, pattern)
+			if r.Op == "not_contains" {
+				return "LOWER(COALESCE(" + x + ", '')) NOT LIKE LOWER(?) ESCAPE '!'", nil
+			}
+			return "LOWER(" + x + ") LIKE LOWER(?) ESCAPE '!'", nil
+		}
+
+	case smartNumber:
+		if r.Op == "between" {
+			var v [2]float64
+			if err := json.Unmarshal(r.Value, &v); err != nil {
+				return "", bad("[low, high]")
+			}
+			c.args = append(c.args, v[0], v[1])
+			return x + " BETWEEN ? AND ?", nil
+		}
+		var v float64
+		if err := json.Unmarshal(r.Value, &v); err != nil {
+			return "", bad("a number")
+		}
+		ops := map[string]string{"eq": "=", "lt": "<", "lte": "<=", "gt": ">", "gte": ">="}
+		if op, ok := ops[r.Op]; ok {
+			c.args = append(c.args, v)
+			return x + " " + op + " ?", nil
+		}
+		if r.Op == "ne" {
+			c.args = append(c.args, v)
+			return x + " IS NULL OR " + x + " <> ?", nil
+		}
+
+	case smartDate:
+		var v string
+		if err := json.Unmarshal(r.Value, &v); err != nil {
+			return "", bad("a string")
+		}
+		switch r.Op {
+		case "before", "after":
+			t, err := parseSmartDate(v)
+			if err != nil {
+				return "", bad("a date such as 2006-01-02")
+			}
+			c.args = append(c.args, c.timeArg(t))
+			if r.Op == "before" {
+				return x + " < ?", nil
+			}
+			return x + " >= ?", nil
+		case "in_last", "not_in_last":
+			since, err := periodBefore(c.now, v)
+			if err != nil {
+				return "", bad("a period such as 90d")
+			}
+			c.args = append(c.args, c.timeArg(since))
+			if r.Op == "in_last" {
+				return x + " >= ?", nil
+			}
+			// Never played counts as not played lately
+			return x + " IS NULL OR " + x + " < ?", nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s does not support op %q", ErrInvalidRules, r.Field, r.Op)
+}
+
+// likeEscape escapes LIKE wildcards in s with "!".
+func likeEscape(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+func parseSmartDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// periodBefore returns now less a period: whole days, weeks, months or
+// years ("90d", "2w", "6mo", "1y"), or a Go duration such as "12h".
+func periodBefore(now time.Time, s string) (time.Time, error) {
+	for _, u := range []struct {
+		suffix              string
+		years, months, days int
+	}{
+		{"d", 0, 0, 1}, {"w", 0, 0, 7}, {"mo", 0, 1, 0}, {"y", 1, 0, 0},
+	} {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			if k, err := strconv.Atoi(n); err == nil && k >= 0 {
+				return now.AddDate(-k*u.years, -k*u.months, -k*u.days), nil
+			}
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid period %q", s)
+	}
+	return now.Add(-d), nil
+}
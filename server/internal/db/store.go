@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/marks-music-solutions/mms/internal/search"
+)
+
+// Store is the data access interface used by the API, scanner and background
+// jobs. Repository implements it on SQLite and PostgresRepository on
+// PostgreSQL.
+type Store interface {
+	// Artists
+	UpsertArtist(ctx context.Context, name, sortName string) (*Artist, error)
+	GetArtistByID(ctx context.Context, id string) (*Artist, error)
+	ListArtists(ctx context.Context, opts *ListOptions) ([]*Artist, *Page, error)
+
+	// Albums
+	UpsertAlbum(ctx context.Context, artistID, title, sortTitle string, year *int, genre *string) (*Album, error)
+	GetAlbumByID(ctx context.Context, id string) (*Album, error)
+	ListAlbums(ctx context.Context, opts *ListOptions) ([]*Album, *Page, error)
+	ListAlbumsByArtist(ctx context.Context, artistID string) ([]*Album, error)
+	RecentAlbums(ctx context.Context, limit int, hideDuplicates bool) ([]*Album, error)
+	RandomAlbums(ctx context.Context, limit int, hideDuplicates bool) ([]*Album, error)
+	UpdateAlbumStats(ctx context.Context, albumID string) error
+	UpdateAlbumCover(ctx context.Context, albumID, coverPath string) error
+
+	// Tracks
+	UpsertTrack(ctx context.Context, t *Track) error
+	GetTrackByID(ctx context.Context, id string) (*Track, error)
+	ListTracksByAlbum(ctx context.Context, albumID string, hideDuplicates bool) ([]*Track, error)
+	ListAllTracks(ctx context.Context) ([]*Track, error)
+	ListTracks(ctx context.Context, opts *ListOptions) ([]*Track, *Page, error)
+	GetTrackIDByPath(ctx context.Context, path string) (string, error)
+	UpdateTrackPath(ctx context.Context, id, path string) error
+
+	// Search
+	Search(ctx context.Context, q *search.Query, limit int) ([]*SearchResult, error)
+	SearchTerms(ctx context.Context) (map[string]int64, error)
+	SearchSuggestions(ctx context.Context) ([]search.Suggestion, error)
+	RebuildSearchIndex(ctx context.Context) error
+	CheckSearchIndex(ctx context.Context) (*SearchIndexReport, error)
+
+	// Playlists
+	CreatePlaylist(ctx context.Context, name, description string, folderID *string) (*Playlist, error)
+	GetPlaylistByID(ctx context.Context, id string) (*Playlist, error)
+	ListPlaylists(ctx context.Context) ([]*Playlist, error)
+	DeletePlaylist(ctx context.Context, id string) error
+	CreateSmartPlaylist(ctx context.Context, name, description string, rules *SmartRules, folderID *string) (*Playlist, error)
+	UpdatePlaylist(ctx context.Context, id string, name, description *string, rules *SmartRules) (*Playlist, error)
+	RefreshSmartPlaylist(ctx context.Context, id string) (*Playlist, error)
+	AddTrackToPlaylist(ctx context.Context, playlistID, trackID string) error
+	AddToPlaylist(ctx context.Context, playlistID string, add *PlaylistAdditions) (int, error)
+	RemovePlaylistEntry(ctx context.Context, playlistID, entryID string) error
+	MovePlaylistEntry(ctx context.Context, playlistID, entryID string, position int) error
+	ListPlaylistEntries(ctx context.Context, playlistID string) ([]*PlaylistTrack, error)
+	ListPlaylistTracks(ctx context.Context, playlistID string, limit, offset int) ([]*PlaylistTrack, error)
+	RestorePlaylist(ctx context.Context, p *Playlist, entries []*PlaylistTrack) (*Playlist, error)
+	PlaylistCoverArt(ctx context.Context, playlistID string, n int) ([]string, error)
+	SetPlaylistCover(ctx context.Context, id string, path *string, custom bool) error
+	OnPlaylistChange(fn func(playlistID string))
+	MovePlaylist(ctx context.Context, id string, folderID *string, position int) error
+	PinPlaylist(ctx context.Context, id string, pinned bool) error
+
+	// Playlist folders
+	CreatePlaylistFolder(ctx context.Context, name string, parentID *string) (*PlaylistFolder, error)
+	GetPlaylistFolder(ctx context.Context, id string) (*PlaylistFolder, error)
+	ListPlaylistFolders(ctx context.Context) ([]*PlaylistFolder, error)
+	RenamePlaylistFolder(ctx context.Context, id, name string) (*PlaylistFolder, error)
+	DeletePlaylistFolder(ctx context.Context, id string) error
+	MovePlaylistFolder(ctx context.Context, id string, parentID *string, position int) error
+
+	// Playlist revisions and deleted playlists
+	ListPlaylistRevisions(ctx context.Context, playlistID string) ([]*PlaylistRevision, error)
+	GetPlaylistRevision(ctx context.Context, playlistID string, revision int) (*PlaylistRevision, error)
+	DiffPlaylistRevisions(ctx context.Context, playlistID string, from, to int) (*PlaylistDiff, error)
+	RestorePlaylistRevision(ctx context.Context, playlistID string, revision int) (*Playlist, error)
+	RecordBaselineRevisions(ctx context.Context) (int, error)
+	ListDeletedPlaylists(ctx context.Context) ([]*Playlist, error)
+	PurgePlaylist(ctx context.Context, id string) error
+	PurgeDeletedPlaylists(ctx context.Context, before time.Time) (int, error)
+
+	// Play history
+	RecordPlay(ctx context.Context, trackID string, durationListened *float64) error
+	ListPlayHistory(ctx context.Context) ([]*PlayHistory, error)
+	RestorePlay(ctx context.Context, trackID string, playedAt time.Time, durationListened *float64) (bool, error)
+
+	// Ratings
+	SetTrackRating(ctx context.Context, trackID string, rating int, ratedAt time.Time) error
+	ListRatings(ctx context.Context) ([]*TrackRating, error)
+
+	// System config
+	ListSystemConfig(ctx context.Context) (map[string]string, error)
+	SetSystemConfig(ctx context.Context, key, value string) error
+
+	// Import history
+	RecordImport(ctx context.Context, rec *ImportRecord) error
+	ListImports(ctx context.Context, status string, limit, offset int) ([]*ImportRecord, int64, error)
+
+	// Integrity
+	SaveIntegrityResult(ctx context.Context, trackID, status, detail string) error
+	ListTracksDueForVerification(ctx context.Context, before time.Time, limit int) ([]*Track, error)
+	ListIntegrityResults(ctx context.Context, status string, limit, offset int) ([]*IntegrityResult, int64, error)
+	CountIntegrityStatuses(ctx context.Context) (map[string]int64, error)
+
+	// Duplicates
+	SaveMusicBrainzID(ctx context.Context, trackID, mbid string) error
+	SaveFingerprint(ctx context.Context, trackID, fingerprint string) error
+	ListTrackIdentifiers(ctx context.Context) (map[string]*TrackIdentifiers, error)
+	ReplaceDuplicateGroups(ctx context.Context, kind string, groups []*DuplicateGroup) error
+	ListDuplicateGroups(ctx context.Context, kind string, limit, offset int) ([]*DuplicateGroup, int64, error)
+
+	// Library health
+	SetTitleFromFilename(ctx context.Context, trackID string, fromFilename bool) error
+	ListHealthIssues(ctx context.Context, issueType, artistID string, limit, offset int) ([]*HealthIssue, int64, error)
+	CountHealthIssues(ctx context.Context) (map[string]int64, error)
+
+	// Stats
+	CountEntities(ctx context.Context, artists, albums, tracks *int64)
+}
+
+var (
+	_ Store = (*Repository)(nil)
+	_ Store = (*PostgresRepository)(nil)
+)
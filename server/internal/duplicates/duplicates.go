@@ -0,0 +1,478 @@
+package duplicates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/marks-music-solutions/mms/internal/match"
+	"github.com/rs/zerolog/log"
+)
+
+// Match reasons recorded on duplicate groups.
+const (
+	ReasonMusicBrainz = "musicbrainz"
+	ReasonMetadata    = "metadata"
+	ReasonFingerprint = "fingerprint"
+	ReasonTracks      = "tracks" // albums sharing most of their tracks
+	ReasonTitle       = "title"  // albums with the same artist and normalized title
+)
+
+// Matching thresholds.
+const (
+	durationTolerance     = 3.0  // seconds between metadata matches
+	fingerprintTolerance  = 2.0  // seconds between fingerprint candidates
+	fingerprintSimilarity = 0.85 // fraction of matching fingerprint bits
+	fingerprintCompare    = 120  // fingerprint values compared (~15s of audio)
+	albumOverlap          = 0.8  // share of an album's tracks duplicated elsewhere
+)
+
+// unknownArtist is the name the scanner gives tracks without artist tags.
+const unknownArtist = "Unknown Artist"
+
+// Finder detects duplicate tracks and albums and stores them as groups.
+type Finder struct {
+	repo       db.Store
+	fpcalcPath string
+	mu         sync.Mutex
+	running    bool
+	lastRun    time.Time
+}
+
+// NewFinder creates a duplicate finder. fpcalcPath points at Chromaprint's
+// fpcalc tool; an empty path disables fingerprint matching.
+func NewFinder(repo db.Store, fpcalcPath string) *Finder {
+	return &Finder{
+		repo:       repo,
+		fpcalcPath: fpcalcPath,
+	}
+}
+
+// IsRunning returns whether a duplicate scan is in progress.
+func (f *Finder) IsRunning() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.running
+}
+
+// LastRun returns when the last duplicate scan finished.
+func (f *Finder) LastRun() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastRun
+}
+
+// Run fingerprints tracks that need it, then recomputes all duplicate groups.
+func (f *Finder) Run(ctx context.Context) error {
+	f.mu.Lock()
+	if f.running {
+		f.mu.Unlock()
+		return fmt.Errorf("duplicate scan already in progress")
+	}
+	f.running = true
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.running = false
+		f.lastRun = time.Now()
+		f.mu.Unlock()
+	}()
+
+	tracks, err := f.repo.ListAllTracks(ctx)
+	if err != nil {
+		return err
+	}
+	ids, err := f.repo.ListTrackIdentifiers(ctx)
+	if err != nil {
+		return err
+	}
+
+	if f.fpcalcPath != "" {
+		f.fingerprintMissing(ctx, tracks, ids)
+	}
+
+	trackGroups := groupTracks(tracks, ids)
+	if err := f.repo.ReplaceDuplicateGroups(ctx, "track", trackGroups); err != nil {
+		return err
+	}
+
+	albumGroups, err := f.groupAlbums(ctx, tracks, trackGroups)
+	if err != nil {
+		return err
+	}
+	if err := f.repo.ReplaceDuplicateGroups(ctx, "album", albumGroups); err != nil {
+		return err
+	}
+
+	log.Info().Int("track_groups", len(trackGroups)).Int("album_groups", len(albumGroups)).
+		Msg("duplicate scan complete")
+	return nil
+}
+
+// fingerprintMissing runs fpcalc for tracks without a stored fingerprint.
+func (f *Finder) fingerprintMissing(ctx context.Context, tracks []*db.Track, ids map[string]*db.TrackIdentifiers) {
+	for _, t := range tracks {
+		if ctx.Err() != nil {
+			return
+		}
+		if id, ok := ids[t.ID]; ok && id.Fingerprint != "" {
+			continue
+		}
+		fp, err := f.fingerprint(ctx, t.FilePath)
+		if err != nil {
+			log.Debug().Err(err).Str("path", t.FilePath).Msg("fingerprint failed")
+			continue
+		}
+		if err := f.repo.SaveFingerprint(ctx, t.ID, fp); err != nil {
+			log.Warn().Err(err).Str("track", t.ID).Msg("failed to save fingerprint")
+			continue
+		}
+		if ids[t.ID] == nil {
+			ids[t.ID] = &db.TrackIdentifiers{TrackID: t.ID}
+		}
+		ids[t.ID].Fingerprint = fp
+	}
+}
+
+// fingerprint returns the raw Chromaprint fingerprint of a file.
+func (f *Finder) fingerprint(ctx context.Context, path string) (string, error) {
+	out, err := exec.CommandContext(ctx, f.fpcalcPath, "-raw", "-json", path).Output()
+	if err != nil {
+		return "", fmt.Errorf("run fpcalc: %w", err)
+	}
+	var res struct {
+		Fingerprint []uint32 `json:"fingerprint"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return "", fmt.Errorf("parse fpcalc output: %w", err)
+	}
+	if len(res.Fingerprint) == 0 {
+		return "", fmt.Errorf("empty fingerprint")
+	}
+	parts := make([]string, len(res.Fingerprint))
+	for i, v := range res.Fingerprint {
+		parts[i] = strconv.FormatUint(uint64(v), 10)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// groupTracks links tracks sharing a MusicBrainz recording, a normalized
+// artist/title with a similar duration, or a similar audio fingerprint.
+func groupTracks(tracks []*db.Track, ids map[string]*db.TrackIdentifiers) []*db.DuplicateGroup {
+	uf := newUnionFind()
+
+	// MusicBrainz recording ID
+	byMBID := make(map[string][]string)
+	for _, t := range tracks {
+		if id := ids[t.ID]; id != nil && id.MBRecordingID != "" {
+			byMBID[id.MBRecordingID] = append(byMBID[id.MBRecordingID], t.ID)
+		}
+	}
+	for _, group := range byMBID {
+		for _, id := range group[1:] {
+			uf.union(group[0], id, ReasonMusicBrainz)
+		}
+	}
+
+	// Normalized artist/title within a duration tolerance
+	byKey := make(map[string][]*db.Track)
+	for _, t := range tracks {
+		if t.ArtistName == unknownArtist {
+			continue // untagged files have too little metadata to compare
+		}
+		key := match.Key(t.ArtistName, t.Title)
+		byKey[key] = append(byKey[key], t)
+	}
+	for _, group := range byKey {
+		linkByDuration(uf, group, durationTolerance, ReasonMetadata, nil)
+	}
+
+	// Audio fingerprints of tracks with similar durations
+	var printed []*db.Track
+	prints := make(map[string][]uint32)
+	for _, t := range tracks {
+		if id := ids[t.ID]; id != nil && id.Fingerprint != "" {
+			prints[t.ID] = parseFingerprint(id.Fingerprint)
+			printed = append(printed, t)
+		}
+	}
+	linkByDuration(uf, printed, fingerprintTolerance, ReasonFingerprint, func(a, b *db.Track) bool {
+		return fingerprintMatch(prints[a.ID], prints[b.ID])
+	})
+
+	byID := make(map[string]*db.Track, len(tracks))
+	for _, t := range tracks {
+		byID[t.ID] = t
+	}
+
+	var groups []*db.DuplicateGroup
+	for _, set := range uf.sets() {
+		g := &db.DuplicateGroup{Kind: "track", Reason: uf.reason(set[0])}
+		for _, id := range set {
+			g.Members = append(g.Members, &db.DuplicateMember{
+				EntityID:     id,
+				QualityScore: QualityScore(byID[id]),
+				Track:        byID[id],
+			})
+		}
+		choosePreferred(g)
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// linkByDuration unions tracks whose durations are within tolerance and that
+// satisfy same (when given). Tracks are compared in a sliding window.
+func linkByDuration(uf *unionFind, tracks []*db.Track, tolerance float64, reason string,
+	same func(a, b *db.Track) bool) {
+	if len(tracks) < 2 {
+		return
+	}
+	sorted := append([]*db.Track(nil), tracks...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].DurationSeconds < sorted[j].DurationSeconds })
+	for i, a := range sorted {
+		for _, b := range sorted[i+1:] {
+			if b.DurationSeconds-a.DurationSeconds > tolerance {
+				break
+			}
+			if same == nil || same(a, b) {
+				uf.union(a.ID, b.ID, reason)
+			}
+		}
+	}
+}
+
+// groupAlbums links albums with the same artist and normalized title, and
+// albums whose tracks are mostly duplicated on another album.
+func (f *Finder) groupAlbums(ctx context.Context, tracks []*db.Track, trackGroups []*db.DuplicateGroup) ([]*db.DuplicateGroup, error) {
+	uf := newUnionFind()
+
+	albumTracks := make(map[string][]*db.Track)
+	for _, t := range tracks {
+		albumTracks[t.AlbumID] = append(albumTracks[t.AlbumID], t)
+	}
+
+	albums := make(map[string]*db.Album, len(albumTracks))
+	byTitle := make(map[string][]string)
+	for id := range albumTracks {
+		album, err := f.repo.GetAlbumByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		albums[id] = album
+		if album.ArtistName == unknownArtist {
+			continue
+		}
+		key := match.Key(album.ArtistName, album.Title)
+		byTitle[key] = append(byTitle[key], id)
+	}
+	for _, ids := range byTitle {
+		sort.Strings(ids)
+		for _, id := range ids[1:] {
+			uf.union(ids[0], id, ReasonTitle)
+		}
+	}
+
+	// Count, per album pair, how many of their tracks are duplicates of each other
+	trackAlbum := make(map[string]string, len(tracks))
+	for _, t := range tracks {
+		trackAlbum[t.ID] = t.AlbumID
+	}
+	shared := make(map[[2]string]int)
+	for _, g := range trackGroups {
+		seen := make(map[[2]string]bool)
+		for i, a := range g.Members {
+			for _, b := range g.Members[i+1:] {
+				pair := [2]string{trackAlbum[a.EntityID], trackAlbum[b.EntityID]}
+				if pair[0] == pair[1] {
+					continue
+				}
+				if pair[0] > pair[1] {
+					pair[0], pair[1] = pair[1], pair[0]
+				}
+				if !seen[pair] {
+					seen[pair] = true
+					shared[pair]++
+				}
+			}
+		}
+	}
+	for pair, n := range shared {
+		smaller := min(len(albumTracks[pair[0]]), len(albumTracks[pair[1]]))
+		if smaller > 0 && float64(n)/float64(smaller) >= albumOverlap {
+			uf.union(pair[0], pair[1], ReasonTracks)
+		}
+	}
+
+	var groups []*db.DuplicateGroup
+	for _, set := range uf.sets() {
+		g := &db.DuplicateGroup{Kind: "album", Reason: uf.reason(set[0])}
+		for _, id := range set {
+			var score float64
+			for _, t := range albumTracks[id] {
+				score += QualityScore(t)
+			}
+			score /= float64(len(albumTracks[id]))
+			// A fuller copy of the album beats a partial one of equal quality
+			score += float64(len(albumTracks[id])) / 100
+			g.Members = append(g.Members, &db.DuplicateMember{
+				EntityID:     id,
+				QualityScore: score,
+				Album:        albums[id],
+			})
+		}
+		choosePreferred(g)
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// QualityScore ranks copies of the same recording: lossless beats lossy, then
+// higher bit depth and sample rate, then higher bitrate.
+func QualityScore(t *db.Track) float64 {
+	if t == nil {
+		return 0
+	}
+	var score float64
+	if isLossless(t) {
+		score = 10000
+		if t.BitDepth != nil {
+			score += float64(*t.BitDepth) * 100
+		}
+		if t.SampleRate != nil {
+			score += float64(*t.SampleRate) / 1000
+		}
+		return score
+	}
+	if t.Bitrate != nil {
+		score = float64(*t.Bitrate) / 1000
+	}
+	return score
+}
+
+func isLossless(t *db.Track) bool {
+	switch strings.ToLower(t.Format) {
+	case "flac", "wav", "aiff", "alac", "ape", "wv":
+		return true
+	case "m4a":
+		return t.BitDepth != nil // ALAC reports a bit depth, AAC does not
+	}
+	return false
+}
+
+// choosePreferred marks the highest-scoring member as the copy to keep.
+func choosePreferred(g *db.DuplicateGroup) {
+	sort.SliceStable(g.Members, func(i, j int) bool {
+		a, b := g.Members[i], g.Members[j]
+		if a.QualityScore != b.QualityScore {
+			return a.QualityScore > b.QualityScore
+		}
+		return a.EntityID < b.EntityID
+	})
+	g.Members[0].Preferred = true
+	g.PreferredID = g.Members[0].EntityID
+}
+
+func parseFingerprint(s string) []uint32 {
+	parts := strings.Split(s, ",")
+	fp := make([]uint32, 0, len(parts))
+	for _, p := range parts {
+		v, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil
+		}
+		fp = append(fp, uint32(v))
+	}
+	return fp
+}
+
+// fingerprintMatch compares the start of two raw fingerprints bit by bit.
+func fingerprintMatch(a, b []uint32) bool {
+	n := min(len(a), len(b), fingerprintCompare)
+	if n < 10 {
+		return false
+	}
+	var differing int
+	for i := 0; i < n; i++ {
+		differing += bits.OnesCount32(a[i] ^ b[i])
+	}
+	return 1-float64(differing)/float64(n*32) >= fingerprintSimilarity
+}
+
+// unionFind groups IDs into disjoint sets, remembering why they were joined.
+type unionFind struct {
+	parent  map[string]string
+	reasons map[string]map[string]bool // root -> reasons
+}
+
+func newUnionFind() *unionFind {
+	return &unionFind{parent: make(map[string]string), reasons: make(map[string]map[string]bool)}
+}
+
+func (u *unionFind) find(id string) string {
+	p, ok := u.parent[id]
+	if !ok {
+		u.parent[id] = id
+		return id
+	}
+	if p == id {
+		return id
+	}
+	root := u.find(p)
+	u.parent[id] = root
+	return root
+}
+
+func (u *unionFind) union(a, b, reason string) {
+	ra, rb := u.find(a), u.find(b)
+	if ra != rb {
+		u.parent[rb] = ra
+		for r := range u.reasons[rb] {
+			u.addReason(ra, r)
+		}
+		delete(u.reasons, rb)
+	}
+	u.addReason(ra, reason)
+}
+
+func (u *unionFind) addReason(root, reason string) {
+	if u.reasons[root] == nil {
+		u.reasons[root] = make(map[string]bool)
+	}
+	u.reasons[root][reason] = true
+}
+
+func (u *unionFind) reason(id string) string {
+	var reasons []string
+	for r := range u.reasons[u.find(id)] {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, ",")
+}
+
+// sets returns every set with more than one member, in a stable order.
+func (u *unionFind) sets() [][]string {
+	byRoot := make(map[string][]string)
+	for id := range u.parent {
+		root := u.find(id)
+		byRoot[root] = append(byRoot[root], id)
+	}
+	var sets [][]string
+	for _, set := range byRoot {
+		if len(set) > 1 {
+			sort.Strings(set)
+			sets = append(sets, set)
+		}
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i][0] < sets[j][0] })
+	return sets
+}
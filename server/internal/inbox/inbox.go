@@ -0,0 +1,388 @@
+package inbox
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhowden/tag"
+	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/marks-music-solutions/mms/internal/organizer"
+	"github.com/marks-music-solutions/mms/internal/scanner"
+	"github.com/mewkiz/flac"
+	"github.com/rs/zerolog/log"
+)
+
+// Import statuses stored in the history.
+const (
+	StatusImported    = "imported"
+	StatusQuarantined = "quarantined"
+)
+
+// settleTime is how long a dropped item must stay unmodified before it is
+// picked up, so files still being copied or downloaded are left alone.
+const settleTime = 10 * time.Second
+
+// stagingDirName holds extracted archives while they are processed.
+const stagingDirName = ".staging"
+
+// Inbox watches a drop folder and imports new music into the library.
+type Inbox struct {
+	repo          db.Store
+	scanner       *scanner.Scanner
+	dir           string
+	quarantineDir string
+	libraryDir    string
+	tmpl          *organizer.Template
+	interval      time.Duration
+
+	mu          sync.Mutex
+	pendingScan []string
+}
+
+// NewInbox creates an inbox that moves valid imports into libraryDir using tmpl.
+func NewInbox(repo db.Store, sc *scanner.Scanner, dir, quarantineDir, libraryDir string,
+	tmpl *organizer.Template, interval time.Duration) *Inbox {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &Inbox{
+		repo:          repo,
+		scanner:       sc,
+		dir:           dir,
+		quarantineDir: quarantineDir,
+		libraryDir:    libraryDir,
+		tmpl:          tmpl,
+		interval:      interval,
+	}
+}
+
+// Run polls the inbox until ctx is cancelled.
+func (in *Inbox) Run(ctx context.Context) {
+	os.MkdirAll(in.dir, 0755)
+	os.MkdirAll(in.quarantineDir, 0755)
+	log.Info().Str("dir", in.dir).Dur("interval", in.interval).Msg("import inbox watching")
+
+	ticker := time.NewTicker(in.interval)
+	defer ticker.Stop()
+	for {
+		in.ProcessOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessOnce imports every settled item currently in the inbox and
+// triggers a scan of the imported files.
+func (in *Inbox) ProcessOnce(ctx context.Context) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	entries, err := os.ReadDir(in.dir)
+	if err != nil {
+		log.Warn().Err(err).Str("dir", in.dir).Msg("read inbox failed")
+		return
+	}
+
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		name := e.Name()
+		if name == stagingDirName || strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(in.dir, name)
+		if !settled(path) {
+			continue
+		}
+
+		switch {
+		case e.IsDir():
+			// Whatever couldn't be moved stays for the next poll
+			if in.importDir(ctx, name, path) {
+				os.RemoveAll(path)
+			}
+		case strings.EqualFold(filepath.Ext(name), ".zip"):
+			in.importZip(ctx, name, path)
+		default:
+			in.importDir(ctx, name, path)
+		}
+	}
+
+	if len(in.pendingScan) > 0 {
+		if err := in.scanner.ScanFiles(in.pendingScan); err != nil {
+			// A scan or organize is running; retry on the next poll
+			log.Info().Err(err).Int("files", len(in.pendingScan)).Msg("deferring scan of imported files")
+			return
+		}
+		in.pendingScan = nil
+	}
+}
+
+// importZip extracts an archive into the staging area and imports its
+// contents. The archive is kept unless every file in it was moved.
+func (in *Inbox) importZip(ctx context.Context, source, path string) {
+	staging := filepath.Join(in.dir, stagingDirName, strings.TrimSuffix(source, filepath.Ext(source)))
+	os.RemoveAll(staging)
+	defer os.RemoveAll(staging)
+
+	if err := extractZip(path, staging); err != nil {
+		in.quarantine(ctx, source, path, path, fmt.Sprintf("invalid archive: %v", err))
+		return
+	}
+	if in.importDir(ctx, source, staging) {
+		os.Remove(path)
+	}
+}
+
+// importDir imports every audio file below root (or root itself when it is a
+// file). Artwork follows the first imported track; other files are
+// quarantined. It reports whether every file was moved out of root.
+func (in *Inbox) importDir(ctx context.Context, source, root string) bool {
+	var audio, other []string
+	filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if scanner.IsAudioFile(path) {
+			audio = append(audio, path)
+		} else {
+			other = append(other, path)
+		}
+		return nil
+	})
+
+	moved := true
+	var albumDir string
+	for _, path := range audio {
+		dest, ok := in.importFile(ctx, source, root, path)
+		if !ok {
+			moved = false
+		} else if dest != "" && albumDir == "" {
+			albumDir = filepath.Dir(dest)
+		}
+	}
+
+	for _, path := range other {
+		ext := strings.ToLower(filepath.Ext(path))
+		if albumDir != "" && (ext == ".jpg" || ext == ".jpeg" || ext == ".png") {
+			if err := organizer.MoveFile(path, filepath.Join(albumDir, filepath.Base(path))); err == nil {
+				continue
+			}
+		}
+		if !in.quarantine(ctx, source, root, path, "not an audio file") {
+			moved = false
+		}
+	}
+	return moved
+}
+
+// importFile validates one audio file and moves it into the library,
+// returning where it went, or "" if it was quarantined instead. ok is false
+// if the file couldn't be moved at all.
+func (in *Inbox) importFile(ctx context.Context, source, root, path string) (dest string, ok bool) {
+	fields, err := validate(path)
+	if err != nil {
+		return "", in.quarantine(ctx, source, root, path, err.Error())
+	}
+
+	dest = filepath.Join(in.libraryDir, in.tmpl.Render(fields))
+	if _, err := os.Stat(dest); err == nil {
+		return "", in.quarantine(ctx, source, root, path, "already in library: "+dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", in.quarantine(ctx, source, root, path, fmt.Sprintf("create library directory: %v", err))
+	}
+	if err := organizer.MoveFile(path, dest); err != nil {
+		return "", in.quarantine(ctx, source, root, path, err.Error())
+	}
+
+	in.pendingScan = append(in.pendingScan, dest)
+	in.record(ctx, &db.ImportRecord{
+		Source:   source,
+		FileName: filepath.Base(path),
+		Status:   StatusImported,
+		DestPath: &dest,
+	})
+	log.Info().Str("source", source).Str("dest", dest).Msg("imported file")
+	return dest, true
+}
+
+// quarantine moves a rejected file aside, keeping its path below the drop's
+// root so same-named files from different folders don't collide, and records
+// why. It reports whether the file was moved.
+func (in *Inbox) quarantine(ctx context.Context, source, root, path, reason string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		rel = filepath.Base(path)
+	}
+	dest := filepath.Join(in.quarantineDir, time.Now().Format("20060102-150405")+"-"+source, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("failed to quarantine file")
+		dest = ""
+	} else if err := organizer.MoveFile(path, dest); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("failed to quarantine file")
+		dest = ""
+	}
+
+	rec := &db.ImportRecord{
+		Source:   source,
+		FileName: filepath.Base(path),
+		Status:   StatusQuarantined,
+		Reason:   &reason,
+	}
+	if dest != "" {
+		rec.DestPath = &dest
+	}
+	in.record(ctx, rec)
+	log.Warn().Str("source", source).Str("file", filepath.Base(path)).Str("reason", reason).Msg("quarantined import")
+	return dest != ""
+}
+
+func (in *Inbox) record(ctx context.Context, rec *db.ImportRecord) {
+	if err := in.repo.RecordImport(ctx, rec); err != nil {
+		log.Error().Err(err).Str("file", rec.FileName).Msg("failed to record import")
+	}
+}
+
+// validate checks that a file decodes and carries the tags needed to place it.
+func validate(path string) (organizer.Fields, error) {
+	var fields organizer.Fields
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fields, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fields, fmt.Errorf("stat file: %w", err)
+	}
+	if fi.Size() == 0 {
+		return fields, fmt.Errorf("file is empty")
+	}
+
+	metadata, err := tag.ReadFrom(f)
+	if err != nil {
+		return fields, fmt.Errorf("read metadata: %w", err)
+	}
+
+	fields.Artist = metadata.Artist()
+	fields.AlbumArtist = metadata.AlbumArtist()
+	if fields.AlbumArtist == "" {
+		fields.AlbumArtist = fields.Artist
+	}
+	if fields.Artist == "" {
+		fields.Artist = fields.AlbumArtist
+	}
+	fields.Album = metadata.Album()
+	fields.Title = metadata.Title()
+	fields.Genre = metadata.Genre()
+	fields.Year = metadata.Year()
+	fields.Track, _ = metadata.Track()
+	fields.Disc, _ = metadata.Disc()
+	if fields.Disc == 0 {
+		fields.Disc = 1
+	}
+	fields.Ext = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+
+	var missing []string
+	if fields.Artist == "" {
+		missing = append(missing, "artist")
+	}
+	if fields.Album == "" {
+		missing = append(missing, "album")
+	}
+	if fields.Title == "" {
+		missing = append(missing, "title")
+	}
+	if len(missing) > 0 {
+		return fields, fmt.Errorf("missing tags: %s", strings.Join(missing, ", "))
+	}
+
+	if fields.Ext == "flac" {
+		stream, err := flac.ParseFile(path)
+		if err != nil {
+			return fields, fmt.Errorf("invalid FLAC stream: %w", err)
+		}
+		defer stream.Close()
+		if stream.Info.NSamples == 0 {
+			return fields, fmt.Errorf("FLAC stream has no samples")
+		}
+	}
+
+	return fields, nil
+}
+
+// extractZip unpacks an archive into dest, rejecting entries that would
+// escape it.
+func extractZip(path, dest string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() || strings.HasPrefix(zf.Name, "__MACOSX/") {
+			continue
+		}
+		if !filepath.IsLocal(zf.Name) {
+			return fmt.Errorf("unsafe path %q in archive", zf.Name)
+		}
+		target := filepath.Join(dest, zf.Name)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := extractFile(zf, target); err != nil {
+			return fmt.Errorf("extract %s: %w", zf.Name, err)
+		}
+	}
+	return nil
+}
+
+func extractFile(zf *zip.File, target string) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// settled reports whether nothing under path changed within settleTime.
+func settled(path string) bool {
+	cutoff := time.Now().Add(-settleTime)
+	ok := true
+	filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().After(cutoff) {
+			ok = false
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return ok
+}
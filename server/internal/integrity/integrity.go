@@ -0,0 +1,107 @@
+package integrity
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+// batchSize is how many tracks are fetched per verification round trip.
+const batchSize = 100
+
+// Verifier runs background integrity checks over the library.
+type Verifier struct {
+	repo     db.Store
+	interval time.Duration
+	mu       sync.Mutex
+	running  bool
+}
+
+// NewVerifier creates a verifier that re-checks each track once per interval.
+func NewVerifier(repo db.Store, interval time.Duration) *Verifier {
+	return &Verifier{
+		repo:     repo,
+		interval: interval,
+	}
+}
+
+// IsRunning returns whether a verification pass is in progress.
+func (v *Verifier) IsRunning() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.running
+}
+
+// Run periodically verifies tracks whose last check is older than the
+// interval, until ctx is cancelled. A zero interval disables scheduling.
+func (v *Verifier) Run(ctx context.Context) {
+	if v.interval <= 0 {
+		return
+	}
+
+	// Wake up often enough to pick up newly scanned tracks
+	tick := min(v.interval, time.Hour)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		if err := v.VerifyDue(ctx, time.Now().Add(-v.interval)); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("scheduled integrity verification skipped")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// VerifyDue verifies every track not checked since the given time. Passing
+// the current time re-verifies the whole library.
+func (v *Verifier) VerifyDue(ctx context.Context, before time.Time) error {
+	v.mu.Lock()
+	if v.running {
+		v.mu.Unlock()
+		return fmt.Errorf("integrity verification already in progress")
+	}
+	v.running = true
+	v.mu.Unlock()
+	defer func() {
+		v.mu.Lock()
+		v.running = false
+		v.mu.Unlock()
+	}()
+
+	counts := make(map[string]int)
+	for {
+		tracks, err := v.repo.ListTracksDueForVerification(ctx, before, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(tracks) == 0 {
+			break
+		}
+		for _, t := range tracks {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			check := VerifyFile(t.FilePath, t.Format)
+			counts[check.Status]++
+			if check.Status == StatusCorrupt || check.Status == StatusTruncated {
+				log.Warn().Str("track", t.ID).Str("path", t.FilePath).Str("status", check.Status).
+					Str("detail", check.Detail).Msg("integrity problem found")
+			}
+			if err := v.repo.SaveIntegrityResult(ctx, t.ID, check.Status, check.Detail); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(counts) > 0 {
+		log.Info().Interface("results", counts).Msg("integrity verification complete")
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package integrity
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mewkiz/flac"
+)
+
+// Verification statuses stored per track.
+const (
+	StatusOK          = "ok"          // audio decoded and matched its checksums
+	StatusNoChecksum  = "no_checksum" // decoded cleanly, but the file carries no checksum to compare
+	StatusCorrupt     = "corrupt"     // checksum mismatch or undecodable data
+	StatusTruncated   = "truncated"   // stream ends before its declared length
+	StatusMissing     = "missing"     // file no longer exists
+	StatusUnsupported = "unsupported" // format cannot be verified
+)
+
+// Check is the outcome of verifying one file.
+type Check struct {
+	Status string
+	Detail string
+}
+
+// VerifyFile checks the audio data of a file according to its format.
+func VerifyFile(path, format string) Check {
+	if _, err := os.Stat(path); err != nil {
+		return Check{Status: StatusMissing, Detail: err.Error()}
+	}
+	switch strings.ToLower(format) {
+	case "flac":
+		return VerifyFLAC(path)
+	case "mp3":
+		return VerifyMP3(path)
+	default:
+		return Check{Status: StatusUnsupported, Detail: "no verifier for " + format}
+	}
+}
+
+// VerifyFLAC fully decodes a FLAC file, checking every frame CRC and
+// comparing the decoded audio against the STREAMINFO MD5.
+func VerifyFLAC(path string) Check {
+	stream, err := flac.Open(path)
+	if err != nil {
+		return Check{Status: StatusCorrupt, Detail: fmt.Sprintf("parse stream: %v", err)}
+	}
+	defer stream.Close()
+
+	sum := md5.New()
+	var decoded uint64
+	for {
+		frame, err := stream.ParseNext()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return Check{Status: StatusTruncated,
+					Detail: fmt.Sprintf("stream ends after %d of %d samples", decoded, stream.Info.NSamples)}
+			}
+			return Check{Status: StatusCorrupt,
+				Detail: fmt.Sprintf("frame at sample %d: %v", decoded, err)}
+		}
+		frame.Hash(sum)
+		decoded += uint64(frame.BlockSize)
+	}
+
+	info := stream.Info
+	if info.NSamples > 0 && decoded < info.NSamples {
+		return Check{Status: StatusTruncated,
+			Detail: fmt.Sprintf("stream ends after %d of %d samples", decoded, info.NSamples)}
+	}
+
+	var zero [md5.Size]byte
+	if info.MD5sum == zero {
+		return Check{Status: StatusNoChecksum, Detail: "STREAMINFO has no MD5; all frame CRCs valid"}
+	}
+	var got [md5.Size]byte
+	copy(got[:], sum.Sum(nil))
+	if got != info.MD5sum {
+		return Check{Status: StatusCorrupt,
+			Detail: fmt.Sprintf("audio MD5 %x does not match STREAMINFO %x", got, info.MD5sum)}
+	}
+	return Check{Status: StatusOK, Detail: fmt.Sprintf("%d samples, MD5 verified", decoded)}
+}
+
+// MPEG audio bitrates in kbit/s, indexed by [mpeg1?][layer-1][index].
+var mp3Bitrates = [2][3][16]int{
+	{ // MPEG-2 / 2.5
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	},
+	{ // MPEG-1
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	},
+}
+
+// MPEG audio sample rates indexed by [version bits][index].
+var mp3SampleRates = [4][3]int{
+	{11025, 12000, 8000},  // MPEG-2.5
+	{0, 0, 0},             // reserved
+	{22050, 24000, 16000}, // MPEG-2
+	{44100, 48000, 32000}, // MPEG-1
+}
+
+// maxResyncBytes is how much junk between frames is tolerated before a file
+// is considered corrupt (padding and stray tag bytes are common).
+const maxResyncBytes = 4096
+
+// VerifyMP3 walks every MPEG audio frame, checking frame boundaries and the
+// CRC-16 of Layer III frames that carry one.
+func VerifyMP3(path string) Check {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Check{Status: StatusCorrupt, Detail: err.Error()}
+	}
+
+	end := len(data)
+	if end >= 128 && string(data[end-128:end-125]) == "TAG" {
+		end -= 128 // ID3v1
+	}
+	pos := skipID3v2(data)
+
+	var frames, crcFrames, crcErrors, skipped int
+	for pos+4 <= end {
+		h, ok := parseMP3Header(data[pos:])
+		if !ok {
+			if string(data[pos:min(pos+8, end)]) == "APETAGEX" {
+				break
+			}
+			pos++
+			skipped++
+			if frames > 0 && skipped > maxResyncBytes {
+				return Check{Status: StatusCorrupt,
+					Detail: fmt.Sprintf("lost frame sync after %d frames", frames)}
+			}
+			continue
+		}
+		if pos+h.length > end {
+			return Check{Status: StatusTruncated,
+				Detail: fmt.Sprintf("frame %d needs %d bytes, %d left", frames+1, h.length, end-pos)}
+		}
+		skipped = 0
+		if h.protected && h.layer == 3 {
+			crcFrames++
+			if !h.crcValid(data[pos : pos+h.length]) {
+				crcErrors++
+			}
+		}
+		frames++
+		pos += h.length
+	}
+
+	switch {
+	case frames == 0:
+		return Check{Status: StatusCorrupt, Detail: "no MPEG audio frames found"}
+	case crcErrors > 0:
+		return Check{Status: StatusCorrupt,
+			Detail: fmt.Sprintf("%d of %d frame CRCs failed", crcErrors, crcFrames)}
+	case crcFrames == 0:
+		return Check{Status: StatusNoChecksum,
+			Detail: fmt.Sprintf("%d frames intact; frames carry no CRC", frames)}
+	}
+	return Check{Status: StatusOK, Detail: fmt.Sprintf("%d frames, %d CRCs verified", frames, crcFrames)}
+}
+
+type mp3Header struct {
+	mpeg1     bool
+	layer     int
+	protected bool
+	mono      bool
+	length    int
+}
+
+func parseMP3Header(b []byte) (mp3Header, bool) {
+	var h mp3Header
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return h, false
+	}
+	version := int(b[1]>>3) & 0x03
+	layerBits := int(b[1]>>1) & 0x03
+	bitrateIdx := int(b[2] >> 4)
+	rateIdx := int(b[2]>>2) & 0x03
+	if version == 1 || layerBits == 0 || bitrateIdx == 0 || bitrateIdx == 15 || rateIdx == 3 {
+		return h, false // reserved values or free-format bitrate
+	}
+
+	h.mpeg1 = version == 3
+	h.layer = 4 - layerBits
+	h.protected = b[1]&0x01 == 0
+	h.mono = b[3]>>6 == 3
+	padding := int(b[2]>>1) & 0x01
+
+	v := 0
+	if h.mpeg1 {
+		v = 1
+	}
+	bitrate := mp3Bitrates[v][h.layer-1][bitrateIdx] * 1000
+	sampleRate := mp3SampleRates[version][rateIdx]
+
+	switch {
+	case h.layer == 1:
+		h.length = (12*bitrate/sampleRate + padding) * 4
+	case h.layer == 3 && !h.mpeg1:
+		h.length = 72*bitrate/sampleRate + padding
+	default:
+		h.length = 144*bitrate/sampleRate + padding
+	}
+	return h, h.length > 4
+}
+
+// sideInfoSize returns the Layer III side information length in bytes.
+func (h mp3Header) sideInfoSize() int {
+	switch {
+	case h.mpeg1 && h.mono:
+		return 17
+	case h.mpeg1:
+		return 32
+	case h.mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// crcValid checks the CRC-16 that follows a protected Layer III header. The
+// checksum covers the last two header bytes and the side information.
+func (h mp3Header) crcValid(frame []byte) bool {
+	side := h.sideInfoSize()
+	if len(frame) < 6+side {
+		return false
+	}
+	want := binary.BigEndian.Uint16(frame[4:6])
+
+	crc := uint16(0xFFFF)
+	update := func(b byte) {
+		for i := 7; i >= 0; i-- {
+			bit := (b>>uint(i))&1 == 1
+			top := crc&0x8000 != 0
+			crc <<= 1
+			if top != bit {
+				crc ^= 0x8005
+			}
+		}
+	}
+	update(frame[2])
+	update(frame[3])
+	for _, b := range frame[6 : 6+side] {
+		update(b)
+	}
+	return crc == want
+}
+
+// skipID3v2 returns the offset of the first byte after a leading ID3v2 tag.
+func skipID3v2(data []byte) int {
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return 0
+	}
+	size := int(data[6]&0x7F)<<21 | int(data[7]&0x7F)<<14 | int(data[8]&0x7F)<<7 | int(data[9]&0x7F)
+	size += 10
+	if data[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	if size > len(data) {
+		return len(data)
+	}
+	return size
+}
@@ -0,0 +1,132 @@
+package match
+
+// Package match provides text normalization used to compare metadata from
+// different sources (duplicate detection, playlist and library imports).
+
+import (
+	"maps"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// foldTable maps accented Latin letters to their ASCII base letters.
+var foldTable = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l", 'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe",
+	'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ß': "ss", 'ť': "t", 'ţ': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// FoldTable returns a copy of the letters Fold replaces after lowercasing,
+// for reproducing it elsewhere (e.g. in SQL).
+func FoldTable() map[rune]string {
+	return maps.Clone(foldTable)
+}
+
+// Fold lowercases s and strips diacritics from Latin letters.
+func Fold(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range strings.ToLower(s) {
+		if f, ok := foldTable[r]; ok {
+			b.WriteString(f)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// editionPattern matches bracketed qualifiers that don't change the recording,
+// e.g. "(2011 Remaster)", "[Deluxe Edition]", "(Explicit)".
+var editionPattern = regexp.MustCompile(`(?i)\s*[\(\[][^\)\]]*\b(remaster(ed)?|deluxe|edition|expanded|anniversary|bonus|explicit|clean|album version|single version|mono|stereo)\b[^\)\]]*[\)\]]`)
+
+// featPattern matches featured-artist credits in titles and artist names.
+var featPattern = regexp.MustCompile(`(?i)\s*[\(\[]?\b(feat\.?|ft\.?|featuring)\s+[^\)\]]*[\)\]]?`)
+
+// remasterSuffix matches dash-separated qualifiers such as "Song - 2009 Remaster".
+var remasterSuffix = regexp.MustCompile(`(?i)\s+-\s+(\d{4}\s+)?(remaster(ed)?|mono|stereo|single version|album version|live)\b.*$`)
+
+// Normalize reduces a title or name to a comparable form: folded case, no
+// edition qualifiers or featured artists, and punctuation collapsed to spaces.
+func Normalize(s string) string {
+	s = editionPattern.ReplaceAllString(s, "")
+	s = featPattern.ReplaceAllString(s, "")
+	s = remasterSuffix.ReplaceAllString(s, "")
+	s = Fold(s)
+	s = strings.ReplaceAll(s, "&", " and ")
+
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			space = false
+			continue
+		}
+		if r == '\'' || r == '’' {
+			continue // "don't" and "dont" compare equal
+		}
+		space = true
+	}
+
+	out := b.String()
+	out = strings.TrimPrefix(out, "the ")
+	return out
+}
+
+// Key builds a normalized artist/title key for exact-match lookups.
+func Key(artist, title string) string {
+	return Normalize(artist) + "\x00" + Normalize(title)
+}
+
+// Similarity returns a 0..1 score of how alike two strings are after
+// normalization, based on Levenshtein distance.
+func Similarity(a, b string) float64 {
+	a, b = Normalize(a), Normalize(b)
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(Distance(ra, rb))/float64(longest)
+}
+
+// Distance returns the Levenshtein edit distance between two rune slices.
+func Distance(a, b []rune) int {
+	if len(a) == 0 {
+		return len(b)
+	}
+	if len(b) == 0 {
+		return len(a)
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
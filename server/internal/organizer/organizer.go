@@ -0,0 +1,478 @@
+package organizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/marks-music-solutions/mms/internal/scanner"
+	"github.com/rs/zerolog/log"
+)
+
+// Conflict policies for targets that already exist.
+const (
+	ConflictSkip   = "skip"
+	ConflictRename = "rename"
+)
+
+// Move statuses.
+const (
+	StatusPending  = "pending"
+	StatusConflict = "conflict"
+	StatusMoved    = "moved"
+	StatusFailed   = "failed"
+)
+
+// Sidecar extensions that travel with a single track (same base name).
+var trackSidecarExts = []string{".lrc", ".cue"}
+
+// Sidecar extensions that belong to the whole directory (cover art, cue sheets).
+var dirSidecarExts = []string{".jpg", ".jpeg", ".png", ".webp", ".gif", ".cue"}
+
+// Move describes relocating one track and its sidecar files.
+type Move struct {
+	TrackID  string     `json:"track_id"`
+	Title    string     `json:"title"`
+	From     string     `json:"from"`
+	To       string     `json:"to"`
+	Sidecars []*Sidecar `json:"sidecars,omitempty"`
+	Status   string     `json:"status"`
+	Reason   string     `json:"reason,omitempty"`
+}
+
+// Sidecar is a non-audio file moved alongside a track.
+type Sidecar struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Result summarizes an organize run.
+type Result struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Moved      int       `json:"moved"`
+	Conflicts  int       `json:"conflicts"`
+	Failed     int       `json:"failed"`
+	Moves      []*Move   `json:"moves"`
+}
+
+// Organizer renames and moves library files into a configured layout.
+type Organizer struct {
+	repo       db.Store
+	dirs       []string
+	tmpl       *Template
+	onConflict string
+	lock       *scanner.LibraryLock
+	mu         sync.Mutex
+	running    bool
+	last       *Result
+}
+
+// NewOrganizer creates a library organizer for the given music directories.
+// Runs take lock, the one the scanner shares, so they never overlap a scan.
+func NewOrganizer(repo db.Store, dirs []string, template, onConflict string, lock *scanner.LibraryLock) (*Organizer, error) {
+	tmpl, err := ParseTemplate(template)
+	if err != nil {
+		return nil, fmt.Errorf("parse organizer template: %w", err)
+	}
+	if onConflict != ConflictSkip && onConflict != ConflictRename {
+		return nil, fmt.Errorf("unknown conflict policy %q", onConflict)
+	}
+	return &Organizer{
+		repo:       repo,
+		dirs:       dirs,
+		tmpl:       tmpl,
+		onConflict: onConflict,
+		lock:       lock,
+	}, nil
+}
+
+// Template returns the layout the organizer renders paths with.
+func (o *Organizer) Template() *Template {
+	return o.tmpl
+}
+
+// IsRunning returns whether an organize run is in progress.
+func (o *Organizer) IsRunning() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.running
+}
+
+// LastResult returns the summary of the most recent run, or nil.
+func (o *Organizer) LastResult() *Result {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.last
+}
+
+// Plan computes the moves needed to bring the library into the configured
+// layout without touching any files. Tracks already in place are omitted.
+func (o *Organizer) Plan(ctx context.Context) ([]*Move, error) {
+	tracks, err := o.repo.ListAllTracks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	albums := make(map[string]*db.Album)
+	claimed := make(map[string]bool) // destination paths taken by this plan
+	dirSidecarsTaken := make(map[string]bool)
+	var moves []*Move
+
+	for _, t := range tracks {
+		root := o.rootFor(t.FilePath)
+		if root == "" {
+			continue
+		}
+
+		album, ok := albums[t.AlbumID]
+		if !ok {
+			album, err = o.repo.GetAlbumByID(ctx, t.AlbumID)
+			if err != nil {
+				return nil, err
+			}
+			albums[t.AlbumID] = album
+		}
+
+		dest := filepath.Join(root, o.tmpl.Render(fieldsFor(t, album)))
+		if samePath(dest, t.FilePath) {
+			claimed[pathKey(dest)] = true
+			continue
+		}
+
+		m := &Move{TrackID: t.ID, Title: t.Title, From: t.FilePath, To: dest, Status: StatusPending}
+		if taken(dest, claimed) {
+			if o.onConflict == ConflictRename {
+				m.To = nextFree(dest, claimed)
+			} else {
+				m.Status = StatusConflict
+				m.Reason = "target already exists"
+			}
+		}
+		if m.Status == StatusPending {
+			claimed[pathKey(m.To)] = true
+			m.Sidecars = o.sidecarsFor(m, dirSidecarsTaken)
+		}
+		moves = append(moves, m)
+	}
+
+	if moves == nil {
+		moves = []*Move{}
+	}
+	return moves, nil
+}
+
+// Apply executes a plan. Each track's database path is updated right after its
+// file is moved, so track IDs (and therefore playlists and play history) are kept.
+// It returns scanner.ErrBusy if a scan or organize is running.
+func (o *Organizer) Apply(ctx context.Context, moves []*Move) (*Result, error) {
+	if err := o.begin(); err != nil {
+		return nil, err
+	}
+	return o.apply(ctx, moves)
+}
+
+// Start takes the library lock, then plans and applies a run in the
+// background. It returns scanner.ErrBusy if a scan or organize is running.
+func (o *Organizer) Start() error {
+	if err := o.begin(); err != nil {
+		return err
+	}
+	go func() {
+		ctx := context.Background()
+		moves, err := o.Plan(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("organize plan failed")
+			o.end(nil)
+			return
+		}
+		if _, err := o.apply(ctx, moves); err != nil {
+			log.Error().Err(err).Msg("library organize failed")
+		}
+	}()
+	return nil
+}
+
+// begin takes the library lock for a run.
+func (o *Organizer) begin() error {
+	if err := o.lock.TryLock(); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	o.running = true
+	o.mu.Unlock()
+	return nil
+}
+
+// end releases the library lock, keeping res as the last result if a run
+// got that far.
+func (o *Organizer) end(res *Result) {
+	o.mu.Lock()
+	o.running = false
+	if res != nil {
+		o.last = res
+	}
+	o.mu.Unlock()
+	o.lock.Unlock()
+}
+
+// apply executes a plan with the library lock held and releases it.
+func (o *Organizer) apply(ctx context.Context, moves []*Move) (*Result, error) {
+	res := &Result{StartedAt: time.Now(), Moves: moves}
+	defer func() {
+		res.FinishedAt = time.Now()
+		o.end(res)
+	}()
+
+	sourceDirs := make(map[string]bool)
+	for _, m := range moves {
+		if m.Status != StatusPending {
+			if m.Status == StatusConflict {
+				res.Conflicts++
+			}
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		if err := o.applyMove(ctx, m); err != nil {
+			m.Status = StatusFailed
+			m.Reason = err.Error()
+			res.Failed++
+			log.Warn().Err(err).Str("from", m.From).Str("to", m.To).Msg("organize move failed")
+			continue
+		}
+		m.Status = StatusMoved
+		res.Moved++
+		sourceDirs[filepath.Dir(m.From)] = true
+	}
+
+	for dir := range sourceDirs {
+		o.removeEmptyDirs(dir)
+	}
+
+	log.Info().Int("moved", res.Moved).Int("conflicts", res.Conflicts).
+		Int("failed", res.Failed).Msg("library organize complete")
+	return res, nil
+}
+
+func (o *Organizer) applyMove(ctx context.Context, m *Move) error {
+	// Re-check the target: the filesystem may have changed since planning
+	if _, err := os.Lstat(m.To); err == nil {
+		return fmt.Errorf("target already exists")
+	}
+	if err := os.MkdirAll(filepath.Dir(m.To), 0755); err != nil {
+		return fmt.Errorf("create target directory: %w", err)
+	}
+	if err := MoveFile(m.From, m.To); err != nil {
+		return err
+	}
+	if err := o.repo.UpdateTrackPath(ctx, m.TrackID, m.To); err != nil {
+		// Put the file back so the database stays truthful
+		if rerr := MoveFile(m.To, m.From); rerr != nil {
+			log.Error().Err(rerr).Str("path", m.To).Msg("failed to roll back organize move")
+		}
+		return err
+	}
+
+	for _, sc := range m.Sidecars {
+		if _, err := os.Lstat(sc.To); err == nil {
+			log.Warn().Str("from", sc.From).Str("to", sc.To).Msg("sidecar target exists, leaving in place")
+			continue
+		}
+		if err := MoveFile(sc.From, sc.To); err != nil {
+			log.Warn().Err(err).Str("from", sc.From).Msg("failed to move sidecar")
+		}
+	}
+	return nil
+}
+
+// sidecarsFor finds lyrics/cue files named after the track, and directory-level
+// artwork and cue sheets. Directory sidecars follow the first track moved out
+// of their directory.
+func (o *Organizer) sidecarsFor(m *Move, dirTaken map[string]bool) []*Sidecar {
+	srcDir := filepath.Dir(m.From)
+	dstDir := filepath.Dir(m.To)
+	srcBase := strings.TrimSuffix(filepath.Base(m.From), filepath.Ext(m.From))
+	dstBase := strings.TrimSuffix(filepath.Base(m.To), filepath.Ext(m.To))
+
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return nil
+	}
+
+	// Files named after some other audio file belong to that track
+	audioBases := make(map[string]bool)
+	for _, e := range entries {
+		if !e.IsDir() && scanner.IsAudioFile(e.Name()) {
+			audioBases[strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))] = true
+		}
+	}
+
+	var sidecars []*Sidecar
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+
+		switch {
+		case audioBases[base] && base != srcBase:
+			continue
+		case base == srcBase && contains(trackSidecarExts, ext):
+			sidecars = append(sidecars, &Sidecar{
+				From: filepath.Join(srcDir, name),
+				To:   filepath.Join(dstDir, dstBase+filepath.Ext(name)),
+			})
+		case contains(dirSidecarExts, ext) && !dirTaken[filepath.Join(srcDir, name)]:
+			if srcDir == dstDir {
+				continue
+			}
+			from := filepath.Join(srcDir, name)
+			dirTaken[from] = true
+			sidecars = append(sidecars, &Sidecar{From: from, To: filepath.Join(dstDir, name)})
+		}
+	}
+	return sidecars
+}
+
+// rootFor returns the configured music directory containing path.
+func (o *Organizer) rootFor(path string) string {
+	var best string
+	for _, dir := range o.dirs {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(dir) > len(best) {
+			best = dir
+		}
+	}
+	return best
+}
+
+// removeEmptyDirs deletes dir and its parents while they are empty,
+// stopping at the music directory root.
+func (o *Organizer) removeEmptyDirs(dir string) {
+	root := o.rootFor(dir)
+	for dir != "" && !samePath(dir, root) && o.rootFor(dir) != "" {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func fieldsFor(t *db.Track, album *db.Album) Fields {
+	f := Fields{
+		AlbumArtist: album.ArtistName,
+		Artist:      t.ArtistName,
+		Album:       album.Title,
+		Title:       t.Title,
+		Disc:        t.DiscNumber,
+		Ext:         strings.TrimPrefix(filepath.Ext(t.FilePath), "."),
+	}
+	if album.Year != nil {
+		f.Year = *album.Year
+	}
+	if album.Genre != nil {
+		f.Genre = *album.Genre
+	}
+	if t.TrackNumber != nil {
+		f.Track = *t.TrackNumber
+	}
+	return f
+}
+
+// MoveFile moves src to dst, falling back to copy+delete across devices. It
+// never replaces an existing dst: the file is hard-linked into place, or
+// copied into a file created with O_EXCL where links aren't possible.
+func MoveFile(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		if err := os.Remove(src); err != nil {
+			os.Remove(dst)
+			return fmt.Errorf("remove source: %w", err)
+		}
+		return nil
+	}
+	if errors.Is(err, fs.ErrExist) || errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("move file: %w", err)
+	}
+
+	// Across devices, or on filesystems without hard links
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open source: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("create target: %w", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("copy file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("close target: %w", err)
+	}
+	in.Close()
+	return os.Remove(src)
+}
+
+// taken reports whether dest is already used on disk or by the plan.
+func taken(dest string, claimed map[string]bool) bool {
+	if claimed[pathKey(dest)] {
+		return true
+	}
+	_, err := os.Lstat(dest)
+	return err == nil
+}
+
+// nextFree appends " (2)", " (3)", ... until the path is unused.
+func nextFree(dest string, claimed map[string]bool) string {
+	ext := filepath.Ext(dest)
+	base := strings.TrimSuffix(dest, ext)
+	for i := 2; ; i++ {
+		candidate := base + " (" + strconv.Itoa(i) + ")" + ext
+		if !taken(candidate, claimed) {
+			return candidate
+		}
+	}
+}
+
+// pathKey normalizes a path for collision checks; the organizer treats paths
+// case-insensitively so plans stay valid on case-insensitive filesystems.
+func pathKey(p string) string {
+	return strings.ToLower(filepath.Clean(p))
+}
+
+func samePath(a, b string) bool {
+	return filepath.Clean(a) == filepath.Clean(b)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
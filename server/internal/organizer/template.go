@@ -0,0 +1,171 @@
+package organizer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Fields holds the metadata values a Template can reference.
+type Fields struct {
+	AlbumArtist string
+	Artist      string
+	Album       string
+	Title       string
+	Genre       string
+	Year        int
+	Disc        int
+	Track       int
+	Ext         string // without the leading dot
+}
+
+// placeholders lists every name accepted inside {braces}.
+var placeholders = map[string]func(f Fields) string{
+	"albumartist": func(f Fields) string { return f.AlbumArtist },
+	"artist":      func(f Fields) string { return f.Artist },
+	"album":       func(f Fields) string { return f.Album },
+	"title":       func(f Fields) string { return f.Title },
+	"genre":       func(f Fields) string { return f.Genre },
+	"year":        func(f Fields) string { return formatNumber(f.Year, 4) },
+	"disc":        func(f Fields) string { return formatNumber(f.Disc, 1) },
+	"track":       func(f Fields) string { return formatNumber(f.Track, 2) },
+	"ext":         func(f Fields) string { return strings.ToLower(f.Ext) },
+}
+
+// Template renders library-relative file paths from track metadata.
+// Path components are separated by "/" regardless of the host OS.
+type Template struct {
+	raw   string
+	parts [][]segment // one slice of segments per path component
+}
+
+type segment struct {
+	literal     string
+	placeholder string
+}
+
+// ParseTemplate validates a layout such as
+// "{albumartist}/{year} - {album}/{disc}-{track} {title}.{ext}".
+func ParseTemplate(raw string) (*Template, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, fmt.Errorf("template is empty")
+	}
+	if strings.HasPrefix(raw, "/") || filepath.IsAbs(raw) {
+		return nil, fmt.Errorf("template must be relative to the music directory")
+	}
+
+	t := &Template{raw: raw}
+	for _, component := range strings.Split(raw, "/") {
+		if component == "" || component == "." || component == ".." {
+			return nil, fmt.Errorf("template contains an invalid path component %q", component)
+		}
+
+		var segs []segment
+		for component != "" {
+			open := strings.IndexByte(component, '{')
+			if open < 0 {
+				segs = append(segs, segment{literal: component})
+				break
+			}
+			if open > 0 {
+				segs = append(segs, segment{literal: component[:open]})
+			}
+			end := strings.IndexByte(component[open:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed placeholder in %q", component)
+			}
+			name := strings.ToLower(component[open+1 : open+end])
+			if _, ok := placeholders[name]; !ok {
+				return nil, fmt.Errorf("unknown placeholder {%s}", name)
+			}
+			segs = append(segs, segment{placeholder: name})
+			component = component[open+end+1:]
+		}
+		t.parts = append(t.parts, segs)
+	}
+
+	last := t.parts[len(t.parts)-1]
+	n := len(last)
+	if n < 2 || last[n-1].placeholder != "ext" || !strings.HasSuffix(last[n-2].literal, ".") {
+		return nil, fmt.Errorf("template must end with .{ext}")
+	}
+	// Keep the extension out of the file name stem so cleanup can't eat it
+	stem := strings.TrimSuffix(last[n-2].literal, ".")
+	t.parts[len(t.parts)-1] = last[:n-2]
+	if stem != "" {
+		t.parts[len(t.parts)-1] = append(t.parts[len(t.parts)-1], segment{literal: stem})
+	}
+	return t, nil
+}
+
+// String returns the template source.
+func (t *Template) String() string {
+	return t.raw
+}
+
+// Render builds the relative path for a track, using the host path separator.
+// Values are sanitized so they cannot introduce extra path components.
+func (t *Template) Render(f Fields) string {
+	components := make([]string, 0, len(t.parts))
+	for _, segs := range t.parts {
+		var b strings.Builder
+		for _, seg := range segs {
+			if seg.placeholder == "" {
+				b.WriteString(seg.literal)
+				continue
+			}
+			value := sanitize(placeholders[seg.placeholder](f))
+			if value == "" {
+				// Drop the separator that introduced the missing value
+				trimmed := strings.TrimRight(b.String(), " -_")
+				b.Reset()
+				b.WriteString(trimmed)
+			}
+			b.WriteString(value)
+		}
+		components = append(components, cleanComponent(b.String()))
+	}
+	components[len(components)-1] += "." + sanitize(placeholders["ext"](f))
+	return filepath.Join(components...)
+}
+
+// formatNumber zero-pads n to width digits; zero renders as empty.
+func formatNumber(n, width int) string {
+	if n <= 0 {
+		return ""
+	}
+	s := strconv.Itoa(n)
+	for len(s) < width {
+		s = "0" + s
+	}
+	return s
+}
+
+// sanitize replaces characters that are invalid in file names on common
+// filesystems (including Windows) with an underscore.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20:
+			return -1
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// cleanComponent tidies separators left dangling by empty values, so that
+// "{year} - {album}" without a year becomes "Album" rather than " - Album".
+func cleanComponent(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	s = strings.TrimLeft(s, " -_.")
+	s = strings.TrimRight(s, " -")
+	s = strings.TrimRight(s, ". ")
+	if s == "" {
+		return "Unknown"
+	}
+	return s
+}
@@ -0,0 +1,333 @@
+package playlistfile
+
+// Package playlistfile reads and writes M3U8, XSPF and PLS playlists, the
+// formats desktop players exchange.
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Supported formats.
+const (
+	FormatM3U8 = "m3u8"
+	FormatXSPF = "xspf"
+	FormatPLS  = "pls"
+)
+
+// ErrUnknownFormat is returned for format names other than the above.
+var ErrUnknownFormat = errors.New("unknown playlist format")
+
+// File is a playlist read from or written to a file.
+type File struct {
+	Name    string
+	Entries []Entry
+}
+
+// Entry is one track in a playlist file. Location is a file path or URL;
+// the metadata is optional and used for matching when the path doesn't
+// resolve.
+type Entry struct {
+	Location        string
+	Artist          string
+	Album           string
+	Title           string
+	DurationSeconds float64
+}
+
+// ContentType returns the MIME type for a format.
+func ContentType(format string) string {
+	switch format {
+	case FormatXSPF:
+		return "application/xspf+xml"
+	case FormatPLS:
+		return "audio/x-scpls"
+	default:
+		return "audio/x-mpegurl"
+	}
+}
+
+// Detect guesses the format of a playlist file from its contents.
+func Detect(data []byte) string {
+	s := strings.TrimSpace(strings.TrimPrefix(string(data), "\uFEFF"))
+	switch {
+	case strings.HasPrefix(s, "<"):
+		return FormatXSPF
+	case len(s) >= 10 && strings.EqualFold(s[:10], "[playlist]"):
+		return FormatPLS
+	default:
+		return FormatM3U8
+	}
+}
+
+// Write encodes f in format to w.
+func Write(w io.Writer, format string, f *File) error {
+	switch format {
+	case FormatM3U8:
+		return writeM3U8(w, f)
+	case FormatXSPF:
+		return writeXSPF(w, f)
+	case FormatPLS:
+		return writePLS(w, f)
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+}
+
+// Parse decodes a playlist file in format.
+func Parse(r io.Reader, format string) (*File, error) {
+	switch format {
+	case FormatM3U8:
+		return parseM3U8(r)
+	case FormatXSPF:
+		return parseXSPF(r)
+	case FormatPLS:
+		return parsePLS(r)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+}
+
+// --- M3U8 ---
+
+func writeM3U8(w io.Writer, f *File) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("#EXTM3U\n")
+	if f.Name != "" {
+		fmt.Fprintf(bw, "#PLAYLIST:%s\n", oneLine(f.Name))
+	}
+	for _, e := range f.Entries {
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n", seconds(e.DurationSeconds), oneLine(displayTitle(e)))
+		if e.Album != "" {
+			fmt.Fprintf(bw, "#EXTALB:%s\n", oneLine(e.Album))
+		}
+		fmt.Fprintf(bw, "%s\n", e.Location)
+	}
+	return bw.Flush()
+}
+
+func parseM3U8(r io.Reader) (*File, error) {
+	f := &File{}
+	var pending Entry
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\uFEFF"))
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// #EXTINF:<seconds>[ attributes],<artist> - <title>
+			info, title, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			if secs, err := strconv.ParseFloat(strings.Fields(info + " ")[0], 64); err == nil && secs > 0 {
+				pending.DurationSeconds = secs
+			}
+			pending.Artist, pending.Title = splitTitle(title)
+		case strings.HasPrefix(line, "#EXTALB:"):
+			pending.Album = strings.TrimSpace(strings.TrimPrefix(line, "#EXTALB:"))
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			f.Name = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#"):
+			// Other directives and comments
+		default:
+			pending.Location = line
+			f.Entries = append(f.Entries, pending)
+			pending = Entry{}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read m3u8: %w", err)
+	}
+	return f, nil
+}
+
+// --- XSPF ---
+
+const xspfNamespace = "http://xspf.org/ns/0/"
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location []string `xml:"location"`
+	Title    string   `xml:"title,omitempty"`
+	Creator  string   `xml:"creator,omitempty"`
+	Album    string   `xml:"album,omitempty"`
+	Duration int64    `xml:"duration,omitempty"` // milliseconds
+}
+
+func writeXSPF(w io.Writer, f *File) error {
+	doc := xspfPlaylist{Version: "1", Xmlns: xspfNamespace, Title: f.Name}
+	for _, e := range f.Entries {
+		doc.Tracks = append(doc.Tracks, xspfTrack{
+			Location: []string{locationURI(e.Location)},
+			Title:    e.Title,
+			Creator:  e.Artist,
+			Album:    e.Album,
+			Duration: int64(math.Round(e.DurationSeconds * 1000)),
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("write xspf: %w", err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func parseXSPF(r io.Reader) (*File, error) {
+	var doc xspfPlaylist
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("read xspf: %w", err)
+	}
+	f := &File{Name: strings.TrimSpace(doc.Title)}
+	for _, t := range doc.Tracks {
+		e := Entry{
+			Artist:          strings.TrimSpace(t.Creator),
+			Album:           strings.TrimSpace(t.Album),
+			Title:           strings.TrimSpace(t.Title),
+			DurationSeconds: float64(t.Duration) / 1000,
+		}
+		if len(t.Location) > 0 {
+			e.Location = locationPath(strings.TrimSpace(t.Location[0]))
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	return f, nil
+}
+
+// locationURI renders a path as the URI XSPF requires; URLs pass through.
+func locationURI(loc string) string {
+	if strings.Contains(loc, "://") {
+		return loc
+	}
+	u := url.URL{Path: filepath.ToSlash(loc)}
+	if filepath.IsAbs(loc) {
+		u.Scheme = "file"
+	}
+	return u.String()
+}
+
+// locationPath turns an XSPF location back into a path; other URLs pass
+// through.
+func locationPath(loc string) string {
+	u, err := url.Parse(loc)
+	if err != nil || (u.Scheme != "" && u.Scheme != "file") {
+		return loc
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// --- PLS ---
+
+func writePLS(w io.Writer, f *File) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("[playlist]\n")
+	for i, e := range f.Entries {
+		n := i + 1
+		fmt.Fprintf(bw, "File%d=%s\n", n, e.Location)
+		fmt.Fprintf(bw, "Title%d=%s\n", n, oneLine(displayTitle(e)))
+		fmt.Fprintf(bw, "Length%d=%d\n", n, seconds(e.DurationSeconds))
+	}
+	fmt.Fprintf(bw, "NumberOfEntries=%d\nVersion=2\n", len(f.Entries))
+	return bw.Flush()
+}
+
+func parsePLS(r io.Reader) (*File, error) {
+	byIndex := make(map[int]*Entry)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(sc.Text()), "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		var field string
+		for _, f := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, f) {
+				field = f
+				break
+			}
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(key, field))
+		if field == "" || err != nil || n < 1 {
+			continue
+		}
+		e := byIndex[n]
+		if e == nil {
+			e = &Entry{}
+			byIndex[n] = e
+		}
+		value = strings.TrimSpace(value)
+		switch field {
+		case "file":
+			e.Location = value
+		case "title":
+			e.Artist, e.Title = splitTitle(value)
+		case "length":
+			if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+				e.DurationSeconds = secs
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read pls: %w", err)
+	}
+
+	f := &File{}
+	for _, n := range slices.Sorted(maps.Keys(byIndex)) {
+		if e := byIndex[n]; e.Location != "" {
+			f.Entries = append(f.Entries, *e)
+		}
+	}
+	return f, nil
+}
+
+// --- Helpers ---
+
+// displayTitle renders "Artist - Title", the convention M3U and PLS players
+// show and parse.
+func displayTitle(e Entry) string {
+	if e.Artist == "" {
+		return e.Title
+	}
+	return e.Artist + " - " + e.Title
+}
+
+// splitTitle splits "Artist - Title"; without a separator it is all title.
+func splitTitle(s string) (artist, title string) {
+	s = strings.TrimSpace(s)
+	if a, t, ok := strings.Cut(s, " - "); ok {
+		return strings.TrimSpace(a), strings.TrimSpace(t)
+	}
+	return "", s
+}
+
+// oneLine keeps names from breaking the line-based formats.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func seconds(d float64) int {
+	if d <= 0 {
+		return -1
+	}
+	return int(math.Round(d))
+}
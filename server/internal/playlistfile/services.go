@@ -0,0 +1,382 @@
+package playlistfile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Streaming-service export sources.
+const (
+	SourceSpotify    = "spotify" // account data export: Playlist1.json, YourLibrary.json
+	SourceCSV        = "csv"     // Exportify, TuneMyMusic, Soundiiz and similar
+	SourceAppleMusic = "apple"   // Music/iTunes "Export Library" XML
+)
+
+// ErrUnknownSource is returned for source names other than the above.
+var ErrUnknownSource = errors.New("unknown export source")
+
+// DetectSource guesses the source of a streaming-service export from its
+// contents.
+func DetectSource(data []byte) string {
+	s := strings.TrimSpace(strings.TrimPrefix(string(data), "\uFEFF"))
+	switch {
+	case strings.HasPrefix(s, "{"):
+		return SourceSpotify
+	case strings.HasPrefix(s, "<"):
+		return SourceAppleMusic
+	default:
+		return SourceCSV
+	}
+}
+
+// ParseExport decodes the playlists in a streaming-service export. Exports
+// without playlist names, such as one CSV per playlist, give a single File
+// with an empty Name.
+func ParseExport(r io.Reader, source string) ([]*File, error) {
+	switch source {
+	case SourceSpotify:
+		return parseSpotify(r)
+	case SourceCSV:
+		return parseCSV(r)
+	case SourceAppleMusic:
+		return parseAppleMusic(r)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownSource, source)
+}
+
+// --- Spotify ---
+
+// spotifyExport covers the playlist and library files of Spotify's account
+// data export.
+type spotifyExport struct {
+	Playlists []struct {
+		Name  string `json:"name"`
+		Items []struct {
+			Track *struct {
+				TrackName  string `json:"trackName"`
+				ArtistName string `json:"artistName"`
+				AlbumName  string `json:"albumName"`
+			} `json:"track"`
+			LocalTrack *struct {
+				URI string `json:"uri"`
+			} `json:"localTrack"`
+		} `json:"items"`
+	} `json:"playlists"`
+	Tracks []struct {
+		Artist string `json:"artist"`
+		Album  string `json:"album"`
+		Track  string `json:"track"`
+	} `json:"tracks"`
+}
+
+func parseSpotify(r io.Reader) ([]*File, error) {
+	var doc spotifyExport
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("read spotify export: %w", err)
+	}
+
+	var files []*File
+	for _, p := range doc.Playlists {
+		f := &File{Name: strings.TrimSpace(p.Name)}
+		for _, it := range p.Items {
+			switch {
+			case it.Track != nil:
+				f.Entries = append(f.Entries, Entry{
+					Artist: strings.TrimSpace(it.Track.ArtistName),
+					Album:  strings.TrimSpace(it.Track.AlbumName),
+					Title:  strings.TrimSpace(it.Track.TrackName),
+				})
+			case it.LocalTrack != nil:
+				if e, ok := spotifyLocalTrack(it.LocalTrack.URI); ok {
+					f.Entries = append(f.Entries, e)
+				}
+			}
+			// Podcast episodes have neither and are skipped.
+		}
+		files = append(files, f)
+	}
+	if len(doc.Tracks) > 0 {
+		f := &File{Name: "Liked Songs"}
+		for _, t := range doc.Tracks {
+			f.Entries = append(f.Entries, Entry{
+				Artist: strings.TrimSpace(t.Artist),
+				Album:  strings.TrimSpace(t.Album),
+				Title:  strings.TrimSpace(t.Track),
+			})
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("read spotify export: no playlists or saved tracks")
+	}
+	return files, nil
+}
+
+// spotifyLocalTrack decodes spotify:local:<artist>:<album>:<title>:<seconds>,
+// the URI Spotify gives files played from the user's own disk.
+func spotifyLocalTrack(uri string) (Entry, bool) {
+	parts := strings.Split(uri, ":")
+	if len(parts) != 6 || parts[1] != "local" {
+		return Entry{}, false
+	}
+	for i := 2; i < 5; i++ {
+		if s, err := url.QueryUnescape(parts[i]); err == nil {
+			parts[i] = strings.TrimSpace(s)
+		}
+	}
+	e := Entry{Artist: parts[2], Album: parts[3], Title: parts[4]}
+	if secs, err := strconv.ParseFloat(parts[5], 64); err == nil && secs > 0 {
+		e.DurationSeconds = secs
+	}
+	return e, e.Title != ""
+}
+
+// --- CSV ---
+
+// csvColumns maps the header names used by playlist-transfer tools, lower
+// case with any "(s)" removed, to entry fields.
+var csvColumns = map[string]string{
+	"track name":    "title",
+	"track title":   "title",
+	"track":         "title",
+	"title":         "title",
+	"song":          "title",
+	"song name":     "title",
+	"name":          "title",
+	"artist name":   "artist",
+	"artist":        "artist",
+	"artists":       "artist",
+	"album name":    "album",
+	"album title":   "album",
+	"album":         "album",
+	"duration (ms)": "duration_ms",
+	"duration_ms":   "duration_ms",
+	"duration":      "duration",
+	"length":        "duration",
+	"time":          "duration",
+	"playlist name": "playlist",
+	"playlist":      "playlist",
+}
+
+func parseCSV(r io.Reader) ([]*File, error) {
+	cr := csv.NewReader(r)
+	cr.LazyQuotes = true
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+
+	cols := make(map[string]int)
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\uFEFF")))
+		h = strings.ReplaceAll(h, "(s)", "")
+		if field, ok := csvColumns[strings.TrimSpace(h)]; ok {
+			if _, seen := cols[field]; !seen {
+				cols[field] = i
+			}
+		}
+	}
+	if _, ok := cols["title"]; !ok {
+		return nil, errors.New("read csv: no track title column")
+	}
+
+	var files []*File
+	byName := make(map[string]*File)
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read csv: %w", err)
+		}
+		get := func(field string) string {
+			if i, ok := cols[field]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		e := Entry{Artist: get("artist"), Album: get("album"), Title: get("title")}
+		if e.Title == "" {
+			continue
+		}
+		if ms, err := strconv.ParseFloat(get("duration_ms"), 64); err == nil && ms > 0 {
+			e.DurationSeconds = ms / 1000
+		} else {
+			e.DurationSeconds = parseDuration(get("duration"))
+		}
+
+		name := get("playlist")
+		f := byName[name]
+		if f == nil {
+			f = &File{Name: name}
+			byName[name] = f
+			files = append(files, f)
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("read csv: no tracks")
+	}
+	return files, nil
+}
+
+// parseDuration reads seconds, "m:ss" or "h:mm:ss"; 0 when it can't.
+func parseDuration(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var secs float64
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		secs = secs*60 + n
+	}
+	return secs
+}
+
+// --- Apple Music ---
+
+// parseAppleMusic reads the property list written by Music's (and iTunes')
+// File > Library > Export Library.
+func parseAppleMusic(r io.Reader) ([]*File, error) {
+	v, err := decodePlist(xml.NewDecoder(r))
+	if err != nil {
+		return nil, fmt.Errorf("read apple music library: %w", err)
+	}
+	lib, _ := v.(map[string]any)
+	tracks, _ := lib["Tracks"].(map[string]any)
+	playlists, _ := lib["Playlists"].([]any)
+	if tracks == nil || playlists == nil {
+		return nil, errors.New("read apple music library: no tracks or playlists")
+	}
+
+	var files []*File
+	for _, pv := range playlists {
+		p, _ := pv.(map[string]any)
+		// Skip the whole library, built-in lists such as "Music" and
+		// "Downloaded", and folders, which repeat their children's tracks.
+		if p == nil || p["Master"] == true || p["Distinguished Kind"] != nil || p["Folder"] == true {
+			continue
+		}
+		name, _ := p["Name"].(string)
+		f := &File{Name: strings.TrimSpace(name)}
+		items, _ := p["Playlist Items"].([]any)
+		for _, iv := range items {
+			item, _ := iv.(map[string]any)
+			id, _ := item["Track ID"].(int64)
+			t, _ := tracks[strconv.FormatInt(id, 10)].(map[string]any)
+			if t == nil {
+				continue
+			}
+			e := Entry{
+				Artist: plistString(t, "Artist"),
+				Album:  plistString(t, "Album"),
+				Title:  plistString(t, "Name"),
+			}
+			if ms, ok := t["Total Time"].(int64); ok && ms > 0 {
+				e.DurationSeconds = float64(ms) / 1000
+			}
+			if loc := plistString(t, "Location"); loc != "" {
+				e.Location = locationPath(loc)
+			}
+			f.Entries = append(f.Entries, e)
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("read apple music library: no playlists")
+	}
+	return files, nil
+}
+
+func plistString(m map[string]any, key string) string {
+	s, _ := m[key].(string)
+	return strings.TrimSpace(s)
+}
+
+// decodePlist reads an XML property list into maps, slices, strings, int64,
+// float64 and bool. Dates and data stay strings.
+func decodePlist(d *xml.Decoder) (any, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local != "plist" {
+			return plistValue(d, se)
+		}
+	}
+}
+
+func plistValue(d *xml.Decoder, start xml.StartElement) (any, error) {
+	switch start.Name.Local {
+	case "dict":
+		m := make(map[string]any)
+		var key string
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				if tok.Name.Local == "key" {
+					if err := d.DecodeElement(&key, &tok); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				v, err := plistValue(d, tok)
+				if err != nil {
+					return nil, err
+				}
+				m[key] = v
+			case xml.EndElement:
+				return m, nil
+			}
+		}
+	case "array":
+		var a []any
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				v, err := plistValue(d, tok)
+				if err != nil {
+					return nil, err
+				}
+				a = append(a, v)
+			case xml.EndElement:
+				return a, nil
+			}
+		}
+	case "true", "false":
+		return start.Name.Local == "true", d.Skip()
+	}
+
+	var s string
+	if err := d.DecodeElement(&s, &start); err != nil {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case "integer":
+		return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	case "real":
+		return strconv.ParseFloat(strings.TrimSpace(s), 64)
+	}
+	return s, nil
+}
@@ -2,10 +2,13 @@ package scanner
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"image/jpeg"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 
@@ -16,24 +19,61 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// ErrBusy is returned when a scan or organize is already running.
+var ErrBusy = errors.New("a library scan or organize is already running")
+
+// LibraryLock keeps scans and organizes apart. An organize moves each file
+// before updating its path, so a scan in between would index moved files as
+// new tracks.
+type LibraryLock struct {
+	mu   sync.Mutex
+	held bool
+}
+
+// TryLock takes the lock, or returns ErrBusy if it is held.
+func (l *LibraryLock) TryLock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held {
+		return ErrBusy
+	}
+	l.held = true
+	return nil
+}
+
+// Unlock releases the lock.
+func (l *LibraryLock) Unlock() {
+	l.mu.Lock()
+	l.held = false
+	l.mu.Unlock()
+}
+
 // Scanner walks music directories and extracts metadata into the database.
 type Scanner struct {
-	repo       *db.Repository
+	repo       db.Store
 	dirs       []string
 	artworkDir string
+	lock       *LibraryLock
 	mu         sync.Mutex
 	scanning   bool
+	onComplete []func()
 }
 
 // NewScanner creates a new library scanner.
-func NewScanner(repo *db.Repository, dirs []string, artworkDir string) *Scanner {
+func NewScanner(repo db.Store, dirs []string, artworkDir string) *Scanner {
 	return &Scanner{
 		repo:       repo,
 		dirs:       dirs,
 		artworkDir: artworkDir,
+		lock:       &LibraryLock{},
 	}
 }
 
+// LibraryLock returns the lock scans take, for the organizer to share.
+func (s *Scanner) LibraryLock() *LibraryLock {
+	return s.lock
+}
+
 // IsScanning returns whether a scan is currently in progress.
 func (s *Scanner) IsScanning() bool {
 	s.mu.Lock()
@@ -41,21 +81,71 @@ func (s *Scanner) IsScanning() bool {
 	return s.scanning
 }
 
-// ScanAll walks all configured directories and indexes music files.
-func (s *Scanner) ScanAll() error {
+// Dirs returns the music directories the scanner walks.
+func (s *Scanner) Dirs() []string {
+	return s.dirs
+}
+
+// OnComplete registers fn to run after every scan, e.g. to refresh caches
+// built from the catalog.
+func (s *Scanner) OnComplete(fn func()) {
+	s.mu.Lock()
+	s.onComplete = append(s.onComplete, fn)
+	s.mu.Unlock()
+}
+
+func (s *Scanner) complete() {
 	s.mu.Lock()
-	if s.scanning {
-		s.mu.Unlock()
-		return fmt.Errorf("scan already in progress")
+	hooks := slices.Clone(s.onComplete)
+	s.mu.Unlock()
+	for _, fn := range hooks {
+		fn()
 	}
+}
+
+// ScanAll walks all configured directories and indexes music files. It
+// returns ErrBusy if a scan or organize is running.
+func (s *Scanner) ScanAll() error {
+	if err := s.begin(); err != nil {
+		return err
+	}
+	defer s.end()
+	s.scanAll()
+	return nil
+}
+
+// StartScanAll takes the library lock and runs ScanAll in the background.
+// It returns ErrBusy if a scan or organize is running.
+func (s *Scanner) StartScanAll() error {
+	if err := s.begin(); err != nil {
+		return err
+	}
+	go func() {
+		defer s.end()
+		s.scanAll()
+	}()
+	return nil
+}
+
+// begin takes the library lock for a scan.
+func (s *Scanner) begin() error {
+	if err := s.lock.TryLock(); err != nil {
+		return err
+	}
+	s.mu.Lock()
 	s.scanning = true
 	s.mu.Unlock()
-	defer func() {
-		s.mu.Lock()
-		s.scanning = false
-		s.mu.Unlock()
-	}()
+	return nil
+}
 
+func (s *Scanner) end() {
+	s.mu.Lock()
+	s.scanning = false
+	s.mu.Unlock()
+	s.lock.Unlock()
+}
+
+func (s *Scanner) scanAll() {
 	var total, errors int
 	for _, dir := range s.dirs {
 		n, e := s.scanDirectory(dir)
@@ -64,6 +154,33 @@ func (s *Scanner) ScanAll() error {
 	}
 
 	log.Info().Int("tracks", total).Int("errors", errors).Msg("library scan complete")
+	s.complete()
+}
+
+// ScanFiles indexes the given files without walking the library. It
+// returns ErrBusy if a scan or organize is running.
+func (s *Scanner) ScanFiles(paths []string) error {
+	if err := s.begin(); err != nil {
+		return err
+	}
+	defer s.end()
+
+	var total, errors int
+	for _, path := range paths {
+		ext := strings.ToLower(filepath.Ext(path))
+		if !isAudioExt(ext) {
+			continue
+		}
+		if err := s.scanFile(path, ext); err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("scan file error")
+			errors++
+		} else {
+			total++
+		}
+	}
+
+	log.Info().Int("tracks", total).Int("errors", errors).Msg("file scan complete")
+	s.complete()
 	return nil
 }
 
@@ -80,7 +197,7 @@ func (s *Scanner) scanDirectory(dir string) (scanned, errors int) {
 		}
 
 		ext := strings.ToLower(filepath.Ext(path))
-		if ext != ".flac" && ext != ".mp3" && ext != ".m4a" && ext != ".ogg" && ext != ".opus" {
+		if !isAudioExt(ext) {
 			return nil
 		}
 
@@ -135,7 +252,8 @@ func (s *Scanner) scanFile(path, ext string) error {
 
 	// Get track title (fall back to filename)
 	title := metadata.Title()
-	if title == "" {
+	titleFromFilename := title == ""
+	if titleFromFilename {
 		title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
 	}
 
@@ -180,8 +298,14 @@ func (s *Scanner) scanFile(path, ext string) error {
 		duration = float64(fi.Size()) / 125000
 	}
 
-	// Generate deterministic track ID from file path
-	trackID := uuid.NewSHA1(uuid.NameSpaceURL, []byte("track:"+path)).String()
+	// Keep the ID of a track already stored at this path (the organizer moves
+	// files without changing IDs), otherwise derive one from the file path
+	trackID, err := s.repo.GetTrackIDByPath(ctx, path)
+	if errors.Is(err, sql.ErrNoRows) {
+		trackID = uuid.NewSHA1(uuid.NameSpaceURL, []byte("track:"+path)).String()
+	} else if err != nil {
+		return err
+	}
 
 	format := strings.TrimPrefix(ext, ".")
 
@@ -221,19 +345,23 @@ func (s *Scanner) scanFile(path, ext string) error {
 		return fmt.Errorf("upsert track: %w", err)
 	}
 
+	// Note tag fallbacks for the library health report
+	s.repo.SetTitleFromFilename(ctx, trackID, titleFromFilename)
+
+	// Remember the MusicBrainz recording for duplicate detection and matching
+	if mbid := musicBrainzRecordingID(metadata); mbid != "" {
+		s.repo.SaveMusicBrainzID(ctx, trackID, mbid)
+	}
+
 	// Update album stats
 	s.repo.UpdateAlbumStats(ctx, album.ID)
 
-	// Extract cover art if album doesn't have one yet
+	// Extract cover art if album doesn't have one yet. The search index is
+	// kept up to date by database triggers.
 	if album.CoverPath == nil {
 		s.extractCoverArt(ctx, f, metadata, album.ID)
 	}
 
-	// Index for full-text search
-	s.repo.IndexTrack(ctx, trackID, "track", title, artistName, albumTitle)
-	s.repo.IndexTrack(ctx, album.ID, "album", albumTitle, artistName, "")
-	s.repo.IndexTrack(ctx, artist.ID, "artist", artistName, "", "")
-
 	return nil
 }
 
@@ -289,6 +417,34 @@ func (s *Scanner) extractCoverArt(ctx context.Context, f *os.File, metadata tag.
 	s.repo.UpdateAlbumCover(ctx, albumID, coverPath)
 }
 
+// isAudioExt reports whether a lowercase file extension is a supported audio format.
+func isAudioExt(ext string) bool {
+	return ext == ".flac" || ext == ".mp3" || ext == ".m4a" || ext == ".ogg" || ext == ".opus"
+}
+
+// IsAudioFile reports whether path has a supported audio file extension.
+func IsAudioFile(path string) bool {
+	return isAudioExt(strings.ToLower(filepath.Ext(path)))
+}
+
+// musicBrainzRecordingID reads the MusicBrainz recording ID as written by
+// Picard: a Vorbis comment, an ID3 UFID frame, or an MP4 freeform atom.
+func musicBrainzRecordingID(metadata tag.Metadata) string {
+	for key, value := range metadata.Raw() {
+		switch v := value.(type) {
+		case string:
+			if strings.EqualFold(key, "musicbrainz_trackid") || strings.EqualFold(key, "MusicBrainz Track Id") {
+				return strings.TrimSpace(v)
+			}
+		case *tag.UFID:
+			if v.Provider == "http://musicbrainz.org" {
+				return strings.TrimSpace(string(v.Identifier))
+			}
+		}
+	}
+	return ""
+}
+
 // sortName generates a sort-friendly name (strips leading "The ", etc.)
 func sortName(name string) string {
 	lower := strings.ToLower(name)
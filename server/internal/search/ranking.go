@@ -0,0 +1,69 @@
+package search
+
+import (
+	"math"
+	"time"
+)
+
+// Weights controls how much each signal moves a result. Signals are scaled to
+// 0..1 across the result set before weighting, so the weights compare
+// directly: with Relevance 1 and Plays 0.5, the most played result gains half
+// as much as the best text match.
+type Weights struct {
+	Relevance float64 // bm25 text match
+	Plays     float64 // times played, log-scaled
+	Rating    float64 // average rating of rated tracks
+	Recency   float64 // how recently it was played
+	Artist    float64 // how much of the library is by the result's artist
+	// RecencyHalfLife is how long after the last play the recency signal
+	// halves.
+	RecencyHalfLife time.Duration
+}
+
+// Signals are the inputs to ranking for one result.
+type Signals struct {
+	Rank         float64 // bm25 rank: negative, lower is better; 0 without text
+	Plays        int64
+	Rating       float64 // 1-5, 0 when unrated
+	LastPlayed   time.Time
+	ArtistTracks int64
+}
+
+// Blend returns a combined rank for each result. As with bm25, ranks are
+// negative and lower is better.
+func (w Weights) Blend(sigs []Signals, now time.Time) []float64 {
+	var bestRank float64
+	var maxPlays, maxTracks int64
+	for _, s := range sigs {
+		bestRank = min(bestRank, s.Rank)
+		maxPlays = max(maxPlays, s.Plays)
+		maxTracks = max(maxTracks, s.ArtistTracks)
+	}
+
+	out := make([]float64, len(sigs))
+	for i, s := range sigs {
+		text := 1.0 // filter-only queries have no text score
+		if bestRank < 0 {
+			text = s.Rank / bestRank
+		}
+		score := w.Relevance*text +
+			w.Plays*logScale(s.Plays, maxPlays) +
+			w.Rating*s.Rating/5 +
+			w.Artist*logScale(s.ArtistTracks, maxTracks)
+		if !s.LastPlayed.IsZero() && w.RecencyHalfLife > 0 {
+			age := now.Sub(s.LastPlayed).Hours() / w.RecencyHalfLife.Hours()
+			score += w.Recency * math.Pow(0.5, max(age, 0))
+		}
+		out[i] = -score
+	}
+	return out
+}
+
+// logScale maps n to 0..1 relative to the largest value, so one heavily
+// played album doesn't flatten everything else to zero.
+func logScale(n, largest int64) float64 {
+	if largest <= 0 {
+		return 0
+	}
+	return math.Log1p(float64(n)) / math.Log1p(float64(largest))
+}
@@ -2,32 +2,319 @@ package search
 
 // Package search provides full-text search utilities.
 // The actual FTS5 queries are implemented in the db/repository.go
-// This package provides query preprocessing and result ranking helpers.
+// This package parses user queries and renders them as FTS5 or tsquery
+// match expressions.
 
-import "strings"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
 
-// PrepareQuery cleans and prepares a search query for FTS5.
-func PrepareQuery(raw string) string {
-	raw = strings.TrimSpace(raw)
-	if raw == "" {
+	"github.com/marks-music-solutions/mms/internal/match"
+)
+
+// Field qualifiers. Text fields match search_index columns; the others
+// filter on catalog data.
+const (
+	FieldArtist = "artist"
+	FieldAlbum  = "album"
+	FieldTitle  = "title"
+	FieldGenre  = "genre"
+	FieldYear   = "year"
+	FieldFormat = "format"
+)
+
+var textFields = map[string]bool{FieldArtist: true, FieldAlbum: true, FieldTitle: true}
+
+var filterFields = map[string]bool{FieldGenre: true, FieldYear: true, FieldFormat: true}
+
+// Query is a parsed search query.
+type Query struct {
+	Terms   []Term
+	Filters []Filter
+}
+
+// Term is a word or quoted phrase to match, optionally limited to one
+// search_index column.
+type Term struct {
+	Field  string // "", FieldArtist, FieldAlbum or FieldTitle
+	Text   string
+	Phrase bool
+	Negate bool
+	Prefix bool // type-ahead: the word may be incomplete
+}
+
+// Filter restricts results by genre, year or format.
+type Filter struct {
+	Field    string // FieldGenre, FieldYear or FieldFormat
+	Value    string
+	YearFrom int // year filters only; 0 means open
+	YearTo   int
+	Negate   bool
+}
+
+// ParseError reports invalid query syntax. Pos is a byte offset into the
+// query.
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s (at character %d)", e.Msg, e.Pos+1)
+}
+
+// PrepareQuery parses a search query. Supported syntax:
+//
+//	radiohead ok comp         all words, the last one as a prefix
+//	"paranoid android"        exact phrase
+//	artist:bjork              word in one field (artist, album, title)
+//	album:"ok computer"       phrase in one field
+//	genre:jazz format:flac    catalog filters
+//	year:1997 year:1990..1999 year or range (either end may be left open)
+//	-live -format:mp3         exclude a word, phrase or filter
+func PrepareQuery(raw string) (*Query, error) {
+	p := &parser{s: raw}
+	q := &Query{}
+	for {
+		p.skipSpace()
+		if p.done() {
+			break
+		}
+		start := p.pos
+		negate := false
+		if p.peek() == '-' && p.pos+1 < len(p.s) && !isSpace(rune(p.s[p.pos+1])) {
+			negate = true
+			p.pos++
+		}
+
+		field := p.field()
+		text, phrase, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if field != "" && text == "" {
+			return nil, &ParseError{Pos: start, Msg: field + ": needs a value"}
+		}
+
+		if filterFields[field] {
+			f, err := parseFilter(field, text, start)
+			if err != nil {
+				return nil, err
+			}
+			f.Negate = negate
+			q.Filters = append(q.Filters, *f)
+			continue
+		}
+		if !hasWordChars(text) {
+			// Lone punctuation such as "&" matches nothing in the index
+			continue
+		}
+		q.Terms = append(q.Terms, Term{Field: field, Text: text, Phrase: phrase, Negate: negate})
+	}
+
+	// Type-ahead: the last plain word is probably still being typed
+	for i := len(q.Terms) - 1; i >= 0; i-- {
+		t := &q.Terms[i]
+		if !t.Negate {
+			t.Prefix = !t.Phrase && i == len(q.Terms)-1
+			break
+		}
+	}
+
+	if len(q.Terms) == 0 && len(q.Filters) == 0 {
+		return nil, &ParseError{Pos: 0, Msg: "query has no search terms"}
+	}
+	if !q.HasText() && q.hasNegatedTerm() {
+		return nil, &ParseError{Pos: 0, Msg: "excluded words need at least one word to search for"}
+	}
+	return q, nil
+}
+
+// HasText reports whether the query has a word or phrase to match, as
+// opposed to only filters.
+func (q *Query) HasText() bool {
+	for _, t := range q.Terms {
+		if !t.Negate {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Query) hasNegatedTerm() bool {
+	for _, t := range q.Terms {
+		if t.Negate {
+			return true
+		}
+	}
+	return false
+}
+
+// FTS5 renders the text terms as an FTS5 MATCH expression. Every term is
+// quoted, so punctuation in user input cannot break the expression. It
+// returns "" when the query has no text to match.
+func (q *Query) FTS5() string {
+	var pos, neg []string
+	for _, t := range q.Terms {
+		expr := `"` + strings.ReplaceAll(t.Text, `"`, `""`) + `"`
+		if t.Prefix {
+			expr += "*"
+		}
+		if t.Field != "" {
+			expr = t.Field + " : " + expr
+		}
+		if t.Negate {
+			neg = append(neg, expr)
+		} else {
+			pos = append(pos, expr)
+		}
+	}
+	if len(pos) == 0 {
 		return ""
 	}
+	out := "(" + strings.Join(pos, " AND ") + ")"
+	for _, n := range neg {
+		out += " NOT (" + n + ")"
+	}
+	return out
+}
+
+// tsWeights maps field qualifiers to the tsvector weights search_index
+// documents are built with.
+var tsWeights = map[string]string{FieldTitle: "A", FieldArtist: "B", FieldAlbum: "C"}
 
-	// Split into tokens, add prefix matching to last token
-	tokens := strings.Fields(raw)
-	if len(tokens) == 0 {
+// TSQuery renders the text terms as a PostgreSQL tsquery over accent-folded
+// words, e.g. `artist:"pink floyd" wall` becomes
+// "(pink:B <-> floyd:B) & wall:*". It returns "" when the query has no text
+// to match.
+func (q *Query) TSQuery() string {
+	var parts []string
+	hasPos := false
+	for _, t := range q.Terms {
+		words := strings.FieldsFunc(match.Fold(t.Text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if len(words) == 0 {
+			continue
+		}
+		for i, w := range words {
+			suffix := tsWeights[t.Field]
+			if t.Prefix && i == len(words)-1 {
+				suffix = "*" + suffix
+			}
+			if suffix != "" {
+				w += ":" + suffix
+			}
+			words[i] = w
+		}
+		expr := "(" + strings.Join(words, " <-> ") + ")"
+		if t.Negate {
+			expr = "!" + expr
+		} else {
+			hasPos = true
+		}
+		parts = append(parts, expr)
+	}
+	if !hasPos {
 		return ""
 	}
+	return strings.Join(parts, " & ")
+}
+
+// parseFilter reads the value of a genre:, year: or format: qualifier.
+func parseFilter(field, value string, pos int) (*Filter, error) {
+	f := &Filter{Field: field, Value: value}
+	if field != FieldYear {
+		return f, nil
+	}
 
-	// Escape FTS5 special characters
-	for i, t := range tokens {
-		t = strings.ReplaceAll(t, "\"", "")
-		t = strings.ReplaceAll(t, "'", "")
-		tokens[i] = t
+	bad := &ParseError{Pos: pos, Msg: "year: expects a year or range such as 1997, 1990..1999, 1990.. or ..1999"}
+	from, to, isRange := strings.Cut(value, "..")
+	if !isRange {
+		to = from
+	}
+	var err error
+	if from != "" {
+		if f.YearFrom, err = strconv.Atoi(from); err != nil || f.YearFrom <= 0 {
+			return nil, bad
+		}
+	}
+	if to != "" {
+		if f.YearTo, err = strconv.Atoi(to); err != nil || f.YearTo <= 0 {
+			return nil, bad
+		}
 	}
+	if f.YearFrom == 0 && f.YearTo == 0 {
+		return nil, bad
+	}
+	if f.YearTo > 0 && f.YearFrom > f.YearTo {
+		return nil, &ParseError{Pos: pos, Msg: "year: range starts after it ends"}
+	}
+	return f, nil
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) done() bool { return p.pos >= len(p.s) }
+
+func (p *parser) peek() byte { return p.s[p.pos] }
+
+func (p *parser) skipSpace() {
+	for !p.done() && isSpace(rune(p.peek())) {
+		p.pos++
+	}
+}
+
+// field consumes a "name:" qualifier and returns the lower-cased name, or ""
+// if the token has none. Any other "word:" is left in place to be read as
+// text, so titles like "Mission: Impossible" and "Re:Stacks" still search.
+func (p *parser) field() string {
+	end := p.pos
+	for end < len(p.s) && isASCIILetter(p.s[end]) {
+		end++
+	}
+	if end == p.pos || end >= len(p.s) || p.s[end] != ':' {
+		return ""
+	}
+	name := strings.ToLower(p.s[p.pos:end])
+	if !textFields[name] && !filterFields[name] {
+		return ""
+	}
+	p.pos = end + 1
+	return name
+}
+
+// value consumes a quoted phrase or a bare word.
+func (p *parser) value() (string, bool, error) {
+	if p.done() {
+		return "", false, nil
+	}
+	if p.peek() == '"' {
+		start := p.pos
+		end := strings.IndexByte(p.s[p.pos+1:], '"')
+		if end < 0 {
+			return "", false, &ParseError{Pos: start, Msg: `unterminated quote; close the phrase with "`}
+		}
+		text := strings.TrimSpace(p.s[p.pos+1 : p.pos+1+end])
+		p.pos += end + 2
+		return text, true, nil
+	}
+	start := p.pos
+	for !p.done() && !isSpace(rune(p.peek())) {
+		p.pos++
+	}
+	return p.s[start:p.pos], false, nil
+}
+
+func isSpace(r rune) bool { return unicode.IsSpace(r) }
 
-	// Add prefix matching to the last token (for type-ahead search)
-	tokens[len(tokens)-1] = tokens[len(tokens)-1] + "*"
+func isASCIILetter(b byte) bool { return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') }
 
-	return strings.Join(tokens, " ")
+func hasWordChars(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0
 }
@@ -0,0 +1,223 @@
+package search
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/marks-music-solutions/mms/internal/match"
+	"github.com/rs/zerolog/log"
+)
+
+// minCorrectable is the shortest word the speller will correct; shorter
+// words are within a typo or two of too many others.
+const minCorrectable = 4
+
+// Speller corrects misspelled query words against the words in the search
+// index, e.g. "radiohed" to "radiohead". The vocabulary is loaded on first
+// use and again after Invalidate.
+type Speller struct {
+	load func(ctx context.Context) (map[string]int64, error)
+
+	mu    sync.Mutex
+	vocab *vocabulary
+}
+
+// NewSpeller creates a speller that loads its vocabulary, words mapped to the
+// number of index entries containing them, with load.
+func NewSpeller(load func(ctx context.Context) (map[string]int64, error)) *Speller {
+	return &Speller{load: load}
+}
+
+// Invalidate drops the vocabulary so the next correction reloads it. Call it
+// after the search index changes.
+func (s *Speller) Invalidate() {
+	s.mu.Lock()
+	s.vocab = nil
+	s.mu.Unlock()
+}
+
+func (s *Speller) vocabulary(ctx context.Context) (*vocabulary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.vocab == nil {
+		counts, err := s.load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.vocab = newVocabulary(counts)
+	}
+	return s.vocab, nil
+}
+
+// Correct returns q with unknown words replaced by the closest indexed word,
+// and whether anything changed. Excluded words are left alone.
+func (s *Speller) Correct(ctx context.Context, q *Query) (*Query, bool) {
+	v, err := s.vocabulary(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("load search vocabulary")
+		return q, false
+	}
+
+	out := &Query{Terms: slices.Clone(q.Terms), Filters: q.Filters}
+	changed := false
+	for i, t := range out.Terms {
+		if t.Negate {
+			continue
+		}
+		words := indexWords(t.Text)
+		fixed := false
+		for j, w := range words {
+			prefix := t.Prefix && j == len(words)-1
+			if v.known(w, prefix) {
+				continue
+			}
+			if c, ok := v.closest(w); ok {
+				words[j], fixed = c, true
+			}
+		}
+		if fixed {
+			out.Terms[i].Text = strings.Join(words, " ")
+			out.Terms[i].Phrase = t.Phrase || len(words) > 1
+			// The correction is a whole word
+			out.Terms[i].Prefix = false
+			changed = true
+		}
+	}
+	return out, changed
+}
+
+// String renders the query back in search syntax, e.g. for a "did you mean"
+// suggestion.
+func (q *Query) String() string {
+	var parts []string
+	for _, t := range q.Terms {
+		s := t.Text
+		if t.Phrase {
+			s = `"` + s + `"`
+		}
+		if t.Field != "" {
+			s = t.Field + ":" + s
+		}
+		if t.Negate {
+			s = "-" + s
+		}
+		parts = append(parts, s)
+	}
+	for _, f := range q.Filters {
+		s := f.Value
+		if strings.ContainsFunc(s, unicode.IsSpace) {
+			s = `"` + s + `"`
+		}
+		s = f.Field + ":" + s
+		if f.Negate {
+			s = "-" + s
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+// indexWords splits text into words the way the search index tokenizes it:
+// folded case and diacritics, split on anything but letters and digits.
+func indexWords(text string) []string {
+	return strings.FieldsFunc(match.Fold(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// vocabulary holds the indexed words with a trigram index for finding close
+// spellings.
+type vocabulary struct {
+	words  []string // sorted
+	counts map[string]int64
+	grams  map[string][]int32 // trigram -> indexes into words
+}
+
+func newVocabulary(counts map[string]int64) *vocabulary {
+	v := &vocabulary{
+		counts: counts,
+		grams:  make(map[string][]int32),
+	}
+	for w := range counts {
+		v.words = append(v.words, w)
+	}
+	slices.Sort(v.words)
+	for i, w := range v.words {
+		for _, g := range trigrams(w) {
+			v.grams[g] = append(v.grams[g], int32(i))
+		}
+	}
+	return v
+}
+
+// known reports whether w is an indexed word, or begins one when prefix is
+// set.
+func (v *vocabulary) known(w string, prefix bool) bool {
+	if _, ok := v.counts[w]; ok {
+		return true
+	}
+	if !prefix {
+		return false
+	}
+	i, _ := slices.BinarySearch(v.words, w)
+	return i < len(v.words) && strings.HasPrefix(v.words[i], w)
+}
+
+// closest finds the indexed word with the smallest edit distance to w,
+// preferring more common words on ties. Words with digits and short words
+// are not corrected.
+func (v *vocabulary) closest(w string) (string, bool) {
+	rw := []rune(w)
+	if len(rw) < minCorrectable || strings.ContainsFunc(w, unicode.IsDigit) {
+		return "", false
+	}
+	maxEdits := 1
+	if len(rw) > 7 {
+		maxEdits = 2
+	}
+
+	// Candidates share at least one trigram; a word within maxEdits keeps
+	// most of them
+	shared := make(map[int32]int)
+	for _, g := range trigrams(w) {
+		for _, i := range v.grams[g] {
+			shared[i]++
+		}
+	}
+
+	best, bestDist, bestCount := "", maxEdits+1, int64(-1)
+	for i := range shared {
+		c := v.words[i]
+		rc := []rune(c)
+		if abs(len(rc)-len(rw)) > maxEdits {
+			continue
+		}
+		d := match.Distance(rw, rc)
+		n := v.counts[c]
+		if d < bestDist || (d == bestDist && (n > bestCount || (n == bestCount && c < best))) {
+			best, bestDist, bestCount = c, d, n
+		}
+	}
+	return best, best != ""
+}
+
+// trigrams returns the three-rune substrings of w padded with spaces, so
+// the start and end of a word count as well.
+func trigrams(w string) []string {
+	r := []rune(" " + w + " ")
+	out := make([]string, 0, len(r))
+	for i := 0; i+3 <= len(r); i++ {
+		out = append(out, string(r[i:i+3]))
+	}
+	return out
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,187 @@
+package search
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MaxSuggestions is the most completions Suggest returns.
+const MaxSuggestions = 20
+
+// shortPrefix is the longest prefix, in runes, whose completions are
+// precomputed. Longer prefixes match few enough keys to rank on the fly.
+const shortPrefix = 2
+
+// Suggestion is an artist, album or track offered as a completion.
+type Suggestion struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"` // "artist", "album" or "track"
+	Title  string `json:"title"`
+	Artist string `json:"artist,omitempty"`
+	Plays  int64  `json:"-"`
+}
+
+// Suggester completes partly typed queries from an in-memory prefix index of
+// artist, album and track names. Each name can be completed from the start of
+// any of its words, and albums and tracks also from their artist's name, so
+// "andr", "paranoid" and "radiohead par" all offer "Paranoid Android".
+type Suggester struct {
+	load func(ctx context.Context) ([]Suggestion, error)
+
+	mu    sync.Mutex
+	index *prefixIndex
+}
+
+// NewSuggester creates a suggester that builds its index from the entries
+// returned by load.
+func NewSuggester(load func(ctx context.Context) ([]Suggestion, error)) *Suggester {
+	return &Suggester{load: load}
+}
+
+// Refresh rebuilds the index. Call it after the catalog changes; until it
+// finishes, suggestions come from the previous index.
+func (s *Suggester) Refresh() {
+	entries, err := s.load(context.Background())
+	if err != nil {
+		log.Warn().Err(err).Msg("load search suggestions")
+		return
+	}
+	index := newPrefixIndex(entries)
+	s.mu.Lock()
+	s.index = index
+	s.mu.Unlock()
+	log.Debug().Int("entries", len(entries)).Msg("search suggestions refreshed")
+}
+
+func (s *Suggester) current(ctx context.Context) (*prefixIndex, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index == nil {
+		entries, err := s.load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.index = newPrefixIndex(entries)
+	}
+	return s.index, nil
+}
+
+// Suggest returns up to limit completions of text, names that start with it
+// first, then the most played.
+func (s *Suggester) Suggest(ctx context.Context, text string, limit int) ([]Suggestion, error) {
+	index, err := s.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Join(indexWords(text), " ")
+	if prefix == "" {
+		return []Suggestion{}, nil
+	}
+	limit = min(max(limit, 1), MaxSuggestions)
+
+	hits := index.lookup(prefix)
+	out := make([]Suggestion, 0, min(limit, len(hits)))
+	for _, h := range hits[:min(limit, len(hits))] {
+		out = append(out, index.items[h.item])
+	}
+	return out, nil
+}
+
+// prefixIndex holds sorted completion keys, with the best completions of
+// short prefixes precomputed.
+type prefixIndex struct {
+	items []Suggestion
+	keys  []indexKey // sorted by key
+	short map[string][]hit
+}
+
+type indexKey struct {
+	key  string
+	item int32
+	full bool // key is the whole name, not a later word or the artist
+}
+
+type hit struct {
+	item int32
+	full bool
+}
+
+func newPrefixIndex(items []Suggestion) *prefixIndex {
+	idx := &prefixIndex{items: items, short: make(map[string][]hit)}
+	for i, it := range items {
+		words := indexWords(it.Title)
+		for j := range words {
+			idx.keys = append(idx.keys, indexKey{key: strings.Join(words[j:], " "), item: int32(i), full: j == 0})
+		}
+		if it.Artist != "" && it.Type != "artist" {
+			key := strings.Join(append(indexWords(it.Artist), words...), " ")
+			idx.keys = append(idx.keys, indexKey{key: key, item: int32(i)})
+		}
+	}
+	slices.SortFunc(idx.keys, func(a, b indexKey) int { return cmp.Compare(a.key, b.key) })
+
+	for _, k := range idx.keys {
+		r := []rune(k.key)
+		for n := 1; n <= min(shortPrefix, len(r)); n++ {
+			p := string(r[:n])
+			idx.short[p] = append(idx.short[p], hit{k.item, k.full})
+		}
+	}
+	for p, hits := range idx.short {
+		hits = idx.rank(hits)
+		idx.short[p] = hits[:min(len(hits), MaxSuggestions):min(len(hits), MaxSuggestions)]
+	}
+	return idx
+}
+
+// lookup returns the completions of prefix, best first.
+func (idx *prefixIndex) lookup(prefix string) []hit {
+	if hits, ok := idx.short[prefix]; ok || len([]rune(prefix)) <= shortPrefix {
+		return hits
+	}
+	i, _ := slices.BinarySearchFunc(idx.keys, prefix, func(k indexKey, p string) int { return cmp.Compare(k.key, p) })
+	var hits []hit
+	for ; i < len(idx.keys) && strings.HasPrefix(idx.keys[i].key, prefix); i++ {
+		hits = append(hits, hit{idx.keys[i].item, idx.keys[i].full})
+	}
+	return idx.rank(hits)
+}
+
+// typeOrder lists artists before albums before tracks on otherwise equal
+// completions.
+var typeOrder = map[string]int{"artist": 0, "album": 1, "track": 2}
+
+// rank keeps the best hit per item and orders them: whole-name matches, then
+// plays, then type and shorter names.
+func (idx *prefixIndex) rank(hits []hit) []hit {
+	best := make(map[int32]bool, len(hits))
+	for _, h := range hits {
+		best[h.item] = best[h.item] || h.full
+	}
+	out := make([]hit, 0, len(best))
+	for item, full := range best {
+		out = append(out, hit{item, full})
+	}
+	slices.SortFunc(out, func(a, b hit) int {
+		x, y := idx.items[a.item], idx.items[b.item]
+		if a.full != b.full {
+			if a.full {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(
+			cmp.Compare(y.Plays, x.Plays),
+			cmp.Compare(typeOrder[x.Type], typeOrder[y.Type]),
+			cmp.Compare(len(x.Title), len(y.Title)),
+			cmp.Compare(x.Title, y.Title),
+			cmp.Compare(x.ID, y.ID),
+		)
+	})
+	return out
+}
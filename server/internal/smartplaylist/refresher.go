@@ -0,0 +1,64 @@
+package smartplaylist
+
+import (
+	"context"
+	"time"
+
+	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+// tick is how often the refresher looks for smart playlists that are due.
+// It bounds how late a scheduled refresh can be.
+const tick = time.Minute
+
+// Refresher re-evaluates smart playlists on their schedules. Live smart
+// playlists are refreshed when read instead.
+type Refresher struct {
+	repo db.Store
+}
+
+// NewRefresher creates a refresher for the smart playlists in repo.
+func NewRefresher(repo db.Store) *Refresher {
+	return &Refresher{repo: repo}
+}
+
+// Run refreshes due smart playlists until ctx is cancelled.
+func (rf *Refresher) Run(ctx context.Context) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		if err := rf.RefreshDue(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("scheduled smart playlist refresh skipped")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RefreshDue refreshes every scheduled smart playlist whose interval has
+// passed since its last refresh. One failing playlist doesn't hold up the
+// rest.
+func (rf *Refresher) RefreshDue(ctx context.Context, now time.Time) error {
+	playlists, err := rf.repo.ListPlaylists(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range playlists {
+		if p.Rules == nil || !p.Rules.Due(p.RefreshedAt, now) {
+			continue
+		}
+		if _, err := rf.repo.RefreshSmartPlaylist(ctx, p.ID); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Error().Err(err).Str("playlist", p.ID).Msg("smart playlist refresh failed")
+			continue
+		}
+		log.Debug().Str("playlist", p.ID).Str("name", p.Name).Msg("smart playlist refreshed")
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/marks-music-solutions/mms/internal/match"
+)
+
+// ErrInvalidDocument is returned for documents that are not MMS exports or
+// come from a newer format version.
+var ErrInvalidDocument = errors.New("invalid library export document")
+
+// Match methods reported per track, strongest first.
+const (
+	MatchMusicBrainz = "musicbrainz"
+	MatchPath        = "path"
+	MatchPathSuffix  = "path_suffix" // same album folder and file name under a different root
+	MatchMetadata    = "metadata"
+	MatchFuzzy       = "fuzzy" // similar artist and title, e.g. from a streaming service
+)
+
+// fuzzyThreshold is the lowest title or artist Similarity a fuzzy match
+// accepts.
+const fuzzyThreshold = 0.8
+
+// durationTolerance is how far apart in seconds metadata matches may be.
+const durationTolerance = 3.0
+
+// Report summarizes what an import re-linked, added and dropped.
+type Report struct {
+	Tracks           int            `json:"tracks"`
+	Matched          map[string]int `json:"matched"` // by match method
+	Unmatched        []*TrackRef    `json:"unmatched"`
+	PlaylistsCreated int            `json:"playlists_created"`
+	PlaylistsSkipped []string       `json:"playlists_skipped"` // name already exists
+	EntriesDropped   int            `json:"entries_dropped"`
+	PlaysImported    int            `json:"plays_imported"`
+	PlaysDuplicate   int            `json:"plays_duplicate"`
+	PlaysDropped     int            `json:"plays_dropped"`
+	RatingsImported  int            `json:"ratings_imported"`
+	RatingsDropped   int            `json:"ratings_dropped"`
+	SettingsImported int            `json:"settings_imported"`
+}
+
+// Import re-links the document's tracks to the current library and adds its
+// playlists, plays, ratings and settings. It is safe to run twice: existing
+// playlists (by name) and plays (by track and time) are not duplicated.
+func Import(ctx context.Context, repo db.Store, doc *Document) (*Report, error) {
+	if doc.Format != FormatName {
+		return nil, fmt.Errorf("%w: format %q", ErrInvalidDocument, doc.Format)
+	}
+	if doc.Version < 1 || doc.Version > FormatVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidDocument, doc.Version)
+	}
+	for _, p := range doc.Playlists {
+		if p.Rules != nil {
+			if err := p.Rules.Validate(); err != nil {
+				return nil, fmt.Errorf("%w: playlist %q: %v", ErrInvalidDocument, p.Name, err)
+			}
+		}
+	}
+
+	idx, err := LoadIndex(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Tracks:           len(doc.Tracks),
+		Matched:          make(map[string]int),
+		Unmatched:        []*TrackRef{},
+		PlaylistsSkipped: []string{},
+	}
+	linked := make(map[string]string, len(doc.Tracks)) // ref -> track ID
+	for _, ref := range doc.Tracks {
+		t, method := idx.Find(ref)
+		if t == nil {
+			report.Unmatched = append(report.Unmatched, ref)
+			continue
+		}
+		linked[ref.Ref] = t.ID
+		report.Matched[method]++
+	}
+
+	existing, err := repo.ListPlaylists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(existing))
+	for _, p := range existing {
+		names[p.Name] = true
+	}
+	folders, err := loadFolderPaths(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range doc.Playlists {
+		if names[p.Name] {
+			report.PlaylistsSkipped = append(report.PlaylistsSkipped, p.Name)
+			continue
+		}
+		var entries []*db.PlaylistTrack
+		for _, e := range p.Entries {
+			id, ok := linked[e.Track]
+			if !ok {
+				report.EntriesDropped++
+				continue
+			}
+			entries = append(entries, &db.PlaylistTrack{TrackID: id, AddedAt: e.AddedAt})
+		}
+		pl := &db.Playlist{Name: p.Name, CreatedAt: p.CreatedAt, UpdatedAt: p.UpdatedAt, Rules: p.Rules, PinnedAt: p.PinnedAt}
+		if p.Description != "" {
+			pl.Description = &p.Description
+		}
+		if len(p.Folder) > 0 {
+			if pl.FolderID, err = folders.ensure(ctx, p.Folder); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := repo.RestorePlaylist(ctx, pl, entries); err != nil {
+			return nil, err
+		}
+		names[p.Name] = true
+		report.PlaylistsCreated++
+	}
+
+	for _, p := range doc.Plays {
+		id, ok := linked[p.Track]
+		if !ok {
+			report.PlaysDropped++
+			continue
+		}
+		added, err := repo.RestorePlay(ctx, id, p.PlayedAt, p.DurationListened)
+		if err != nil {
+			return nil, err
+		}
+		if added {
+			report.PlaysImported++
+		} else {
+			report.PlaysDuplicate++
+		}
+	}
+
+	for _, r := range doc.Ratings {
+		id, ok := linked[r.Track]
+		if !ok || r.Rating < 1 || r.Rating > 5 {
+			report.RatingsDropped++
+			continue
+		}
+		if err := repo.SetTrackRating(ctx, id, r.Rating, r.RatedAt); err != nil {
+			return nil, err
+		}
+		report.RatingsImported++
+	}
+
+	for _, s := range doc.Settings {
+		if err := repo.SetSystemConfig(ctx, s.Key, s.Value); err != nil {
+			return nil, err
+		}
+		report.SettingsImported++
+	}
+
+	return report, nil
+}
+
+// Index looks up library tracks by the identifiers in a TrackRef.
+type Index struct {
+	byMBID       map[string][]*db.Track
+	byPath       map[string]*db.Track
+	byPathSuffix map[string][]*db.Track
+	byKey        map[string][]*db.Track
+	byArtist     map[string][]*db.Track // normalized artist
+	byTitle      map[string][]*db.Track // normalized title
+}
+
+// LoadIndex builds an index over every track in the library.
+func LoadIndex(ctx context.Context, repo db.Store) (*Index, error) {
+	tracks, err := repo.ListAllTracks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	idents, err := repo.ListTrackIdentifiers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{
+		byMBID:       make(map[string][]*db.Track),
+		byPath:       make(map[string]*db.Track, len(tracks)),
+		byPathSuffix: make(map[string][]*db.Track, len(tracks)),
+		byKey:        make(map[string][]*db.Track, len(tracks)),
+		byArtist:     make(map[string][]*db.Track),
+		byTitle:      make(map[string][]*db.Track, len(tracks)),
+	}
+	for _, t := range tracks {
+		if id, ok := idents[t.ID]; ok && id.MBRecordingID != "" {
+			idx.byMBID[id.MBRecordingID] = append(idx.byMBID[id.MBRecordingID], t)
+		}
+		idx.byPath[t.FilePath] = t
+		suffix := pathSuffix(t.FilePath)
+		idx.byPathSuffix[suffix] = append(idx.byPathSuffix[suffix], t)
+		key := match.Key(t.ArtistName, t.Title)
+		idx.byKey[key] = append(idx.byKey[key], t)
+		artist, title := match.Normalize(t.ArtistName), match.Normalize(t.Title)
+		idx.byArtist[artist] = append(idx.byArtist[artist], t)
+		idx.byTitle[title] = append(idx.byTitle[title], t)
+	}
+	return idx, nil
+}
+
+// Find returns the library track best matching ref and how it was matched,
+// or nil when nothing matches.
+func (idx *Index) Find(ref *TrackRef) (*db.Track, string) {
+	if ref.MusicBrainzID != "" {
+		if t := closest(idx.byMBID[ref.MusicBrainzID], ref, math.Inf(1)); t != nil {
+			return t, MatchMusicBrainz
+		}
+	}
+	if ref.Path != "" {
+		if t, ok := idx.byPath[ref.Path]; ok {
+			return t, MatchPath
+		}
+		if t := closest(idx.byPathSuffix[pathSuffix(ref.Path)], ref, durationTolerance); t != nil {
+			return t, MatchPathSuffix
+		}
+	}
+	if t := closest(idx.byKey[match.Key(ref.Artist, ref.Title)], ref, durationTolerance); t != nil {
+		return t, MatchMetadata
+	}
+	return nil, ""
+}
+
+// FindFuzzy is Find with a last resort for metadata spelled differently
+// from the library's: one of the artist's tracks with a similar title, or a
+// track with the same title by a similar artist. Credits such as "A, B" or
+// "A & B" are also tried one artist at a time.
+func (idx *Index) FindFuzzy(ref *TrackRef) (*db.Track, string) {
+	if t, method := idx.Find(ref); t != nil {
+		return t, method
+	}
+	if ref.Title == "" {
+		return nil, ""
+	}
+	artists := splitArtists(ref.Artist)
+	for _, a := range artists {
+		one := *ref
+		one.Artist = a
+		if t := closest(idx.byKey[match.Key(a, ref.Title)], &one, durationTolerance); t != nil {
+			return t, MatchMetadata
+		}
+	}
+
+	album := match.Normalize(ref.Album)
+	var best *db.Track
+	bestScore := 0.0
+	consider := func(t *db.Track, score float64) {
+		if score < fuzzyThreshold {
+			return
+		}
+		if ref.DurationSeconds > 0 && t.DurationSeconds > 0 &&
+			math.Abs(ref.DurationSeconds-t.DurationSeconds) > durationTolerance {
+			return
+		}
+		if album != "" && match.Normalize(t.AlbumTitle) == album {
+			score += 0.05
+		}
+		if score > bestScore {
+			best, bestScore = t, score
+		}
+	}
+	for _, a := range artists {
+		for _, t := range idx.byArtist[match.Normalize(a)] {
+			consider(t, match.Similarity(t.Title, ref.Title))
+		}
+	}
+	for _, t := range idx.byTitle[match.Normalize(ref.Title)] {
+		for _, a := range artists {
+			consider(t, match.Similarity(t.ArtistName, a))
+		}
+	}
+	if best == nil {
+		return nil, ""
+	}
+	return best, MatchFuzzy
+}
+
+// splitArtists returns the full artist credit followed by each artist in it.
+func splitArtists(credit string) []string {
+	out := []string{credit}
+	parts := strings.FieldsFunc(credit, func(r rune) bool { return r == ',' || r == ';' || r == '&' })
+	if len(parts) > 1 {
+		for _, p := range parts {
+			if p = strings.TrimSpace(p); p != "" {
+				out = append(out, p)
+			}
+		}
+	}
+	return out
+}
+
+// closest picks the candidate on the same album with the nearest duration,
+// falling back to other albums, within tolerance seconds. A zero duration
+// on either side matches any duration.
+func closest(candidates []*db.Track, ref *TrackRef, tolerance float64) *db.Track {
+	album := match.Normalize(ref.Album)
+	var best *db.Track
+	bestScore := math.Inf(1)
+	for _, t := range candidates {
+		diff := 0.0
+		if ref.DurationSeconds > 0 && t.DurationSeconds > 0 {
+			diff = math.Abs(ref.DurationSeconds - t.DurationSeconds)
+		}
+		if diff > tolerance {
+			continue
+		}
+		score := diff
+		if match.Normalize(t.AlbumTitle) != album {
+			score += 1000 // any same-album candidate wins
+		}
+		if score < bestScore {
+			best, bestScore = t, score
+		}
+	}
+	return best
+}
+
+// pathSuffix returns the album folder and file name of a path, which usually
+// survive moving a library to a new root.
+func pathSuffix(path string) string {
+	path = filepath.ToSlash(path)
+	parts := strings.Split(path, "/")
+	if len(parts) > 2 {
+		parts = parts[len(parts)-2:]
+	}
+	return strings.ToLower(strings.Join(parts, "/"))
+}
+
+// folderPaths finds playlist folders by name path, creating missing ones.
+type folderPaths struct {
+	repo db.Store
+	ids  map[string]string // parent ID + "/" + name -> folder ID
+}
+
+func loadFolderPaths(ctx context.Context, repo db.Store) (*folderPaths, error) {
+	folders, err := repo.ListPlaylistFolders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fp := &folderPaths{repo: repo, ids: make(map[string]string, len(folders))}
+	for _, f := range folders {
+		key := f.Name
+		if f.ParentID != nil {
+			key = *f.ParentID + "/" + f.Name
+		}
+		if _, ok := fp.ids[key]; !ok {
+			fp.ids[key] = f.ID
+		}
+	}
+	return fp, nil
+}
+
+// ensure returns the ID of the folder at path, creating any missing
+// folders along it.
+func (fp *folderPaths) ensure(ctx context.Context, path []string) (*string, error) {
+	var parentID *string
+	for _, name := range path {
+		key := name
+		if parentID != nil {
+			key = *parentID + "/" + name
+		}
+		id, ok := fp.ids[key]
+		if !ok {
+			f, err := fp.repo.CreatePlaylistFolder(ctx, name, parentID)
+			if err != nil {
+				return nil, err
+			}
+			id = f.ID
+			fp.ids[key] = id
+		}
+		parentID = &id
+	}
+	return parentID, nil
+}
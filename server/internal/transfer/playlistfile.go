@@ -0,0 +1,178 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/marks-music-solutions/mms/internal/playlistfile"
+)
+
+// MatchStreamURL is reported for playlist file entries that are MMS stream
+// URLs, which name the track directly.
+const MatchStreamURL = "stream_url"
+
+// How exported playlist files locate tracks.
+const (
+	PathsAbsolute = "absolute"
+	PathsRelative = "relative" // to the music directory holding the track
+	PathsStream   = "stream"   // stream URLs on this server
+)
+
+// streamURLPattern extracts the track ID from an MMS stream URL.
+var streamURLPattern = regexp.MustCompile(`/tracks/([^/?#]+)/stream\b`)
+
+// PlaylistFileReport describes a playlist file import.
+type PlaylistFileReport struct {
+	Playlist  *db.Playlist      `json:"playlist"` // nil when nothing matched
+	Entries   int               `json:"entries"`
+	Matched   map[string]int    `json:"matched"` // by match method
+	Unmatched []*UnmatchedEntry `json:"unmatched"`
+}
+
+// UnmatchedEntry is a playlist file entry that no library track matched.
+type UnmatchedEntry struct {
+	Position        int     `json:"position"` // 1-based, in the file
+	Location        string  `json:"location,omitempty"`
+	Artist          string  `json:"artist,omitempty"`
+	Title           string  `json:"title,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+}
+
+// ExportPlaylistFile converts a playlist for writing as a playlist file.
+// paths is one of the Paths constants; dirs are the music directories for
+// relative paths and streamBase the server URL for stream URLs. Entries
+// whose track is gone are left out.
+func ExportPlaylistFile(ctx context.Context, repo db.Store, playlistID, paths string, dirs []string, streamBase string) (*playlistfile.File, error) {
+	p, err := repo.GetPlaylistByID(ctx, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := repo.ListPlaylistTracks(ctx, playlistID, math.MaxInt32, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &playlistfile.File{Name: p.Name}
+	for _, e := range entries {
+		if e.Missing {
+			continue
+		}
+		t := e.Track
+		loc := t.FilePath
+		switch paths {
+		case PathsRelative:
+			loc = relativePath(t.FilePath, dirs)
+		case PathsStream:
+			loc = strings.TrimSuffix(streamBase, "/") + "/api/v1/tracks/" + t.ID + "/stream"
+		}
+		f.Entries = append(f.Entries, playlistfile.Entry{
+			Location:        loc,
+			Artist:          t.ArtistName,
+			Album:           t.AlbumTitle,
+			Title:           t.Title,
+			DurationSeconds: t.DurationSeconds,
+		})
+	}
+	return f, nil
+}
+
+// relativePath returns path relative to the music directory containing it,
+// or unchanged when it is outside all of them.
+func relativePath(path string, dirs []string) string {
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return path
+}
+
+// ImportPlaylistFile creates a playlist from a playlist file, resolving each
+// entry to a library track by stream URL, path (relative paths are tried
+// under each music directory), or artist, title and duration, exactly and
+// then fuzzily. Nothing is created when no entry matches. name overrides the
+// name in the file.
+func ImportPlaylistFile(ctx context.Context, repo db.Store, f *playlistfile.File, name string, dirs []string) (*PlaylistFileReport, error) {
+	idx, err := LoadIndex(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	return importPlaylistFile(ctx, repo, idx, f, name, dirs)
+}
+
+func importPlaylistFile(ctx context.Context, repo db.Store, idx *Index, f *playlistfile.File, name string, dirs []string) (*PlaylistFileReport, error) {
+	report := &PlaylistFileReport{
+		Entries:   len(f.Entries),
+		Matched:   make(map[string]int),
+		Unmatched: []*UnmatchedEntry{},
+	}
+	now := time.Now().UTC()
+	var entries []*db.PlaylistTrack
+	for i, e := range f.Entries {
+		t, method := resolveEntry(ctx, repo, idx, &e, dirs)
+		if t == nil {
+			report.Unmatched = append(report.Unmatched, &UnmatchedEntry{
+				Position:        i + 1,
+				Location:        e.Location,
+				Artist:          e.Artist,
+				Title:           e.Title,
+				DurationSeconds: e.DurationSeconds,
+			})
+			continue
+		}
+		report.Matched[method]++
+		entries = append(entries, &db.PlaylistTrack{TrackID: t.ID, AddedAt: now})
+	}
+	if len(entries) == 0 {
+		return report, nil
+	}
+
+	if name = strings.TrimSpace(name); name == "" {
+		name = f.Name
+	}
+	if name == "" {
+		name = "Imported " + now.Format(time.DateOnly)
+	}
+	p, err := repo.RestorePlaylist(ctx, &db.Playlist{Name: name, CreatedAt: now, UpdatedAt: now}, entries)
+	if err != nil {
+		return nil, fmt.Errorf("create playlist: %w", err)
+	}
+	report.Playlist = p
+	return report, nil
+}
+
+// resolveEntry finds the library track for a playlist file entry and how it
+// was matched.
+func resolveEntry(ctx context.Context, repo db.Store, idx *Index, e *playlistfile.Entry, dirs []string) (*db.Track, string) {
+	if strings.Contains(e.Location, "://") {
+		if m := streamURLPattern.FindStringSubmatch(e.Location); m != nil {
+			if t, err := repo.GetTrackByID(ctx, m[1]); err == nil {
+				return t, MatchStreamURL
+			}
+		}
+		return idx.FindFuzzy(&TrackRef{Artist: e.Artist, Album: e.Album, Title: e.Title, DurationSeconds: e.DurationSeconds})
+	}
+
+	ref := &TrackRef{
+		Artist:          e.Artist,
+		Album:           e.Album,
+		Title:           e.Title,
+		DurationSeconds: e.DurationSeconds,
+		Path:            filepath.FromSlash(e.Location),
+	}
+	if ref.Path != "" && !filepath.IsAbs(ref.Path) {
+		for _, dir := range dirs {
+			if t, ok := idx.byPath[filepath.Join(dir, ref.Path)]; ok {
+				return t, MatchPath
+			}
+		}
+	}
+	return idx.FindFuzzy(ref)
+}
@@ -0,0 +1,76 @@
+package transfer
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/marks-music-solutions/mms/internal/match"
+	"github.com/marks-music-solutions/mms/internal/playlistfile"
+)
+
+// ServiceImportReport describes an import of streaming-service playlists.
+type ServiceImportReport struct {
+	Playlists []*ServicePlaylistReport `json:"playlists"`
+	// Missing lists each track that matched nothing once, however many
+	// playlists it was in: a shopping list.
+	Missing []*MissingTrack `json:"missing"`
+}
+
+// ServicePlaylistReport describes one imported playlist.
+type ServicePlaylistReport struct {
+	Name string `json:"name"`
+	*PlaylistFileReport
+}
+
+// MissingTrack is a track from a streaming service that isn't in the library.
+type MissingTrack struct {
+	Artist    string   `json:"artist"`
+	Album     string   `json:"album,omitempty"`
+	Title     string   `json:"title"`
+	Playlists []string `json:"playlists"`
+}
+
+// ImportServicePlaylists creates a playlist for each streaming-service
+// playlist with at least one track in the library, matching entries by
+// artist, title and duration, then fuzzily. name replaces the playlist name
+// when there is only one playlist, as with a CSV export of a single list.
+func ImportServicePlaylists(ctx context.Context, repo db.Store, files []*playlistfile.File, name string) (*ServiceImportReport, error) {
+	idx, err := LoadIndex(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ServiceImportReport{Playlists: []*ServicePlaylistReport{}, Missing: []*MissingTrack{}}
+	missing := make(map[string]*MissingTrack)
+	for _, f := range files {
+		fileName := f.Name
+		if len(files) == 1 && strings.TrimSpace(name) != "" {
+			fileName = strings.TrimSpace(name)
+		}
+		r, err := importPlaylistFile(ctx, repo, idx, f, fileName, nil)
+		if err != nil {
+			return nil, err
+		}
+		if r.Playlist != nil {
+			fileName = r.Playlist.Name
+		}
+		report.Playlists = append(report.Playlists, &ServicePlaylistReport{Name: fileName, PlaylistFileReport: r})
+
+		for _, u := range r.Unmatched {
+			e := f.Entries[u.Position-1]
+			key := match.Key(e.Artist, e.Title)
+			m := missing[key]
+			if m == nil {
+				m = &MissingTrack{Artist: e.Artist, Album: e.Album, Title: e.Title, Playlists: []string{}}
+				missing[key] = m
+				report.Missing = append(report.Missing, m)
+			}
+			if !slices.Contains(m.Playlists, fileName) {
+				m.Playlists = append(m.Playlists, fileName)
+			}
+		}
+	}
+	return report, nil
+}
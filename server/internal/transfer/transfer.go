@@ -0,0 +1,225 @@
+package transfer
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/marks-music-solutions/mms/internal/db"
+)
+
+// FormatName and FormatVersion identify export documents. The format is
+// described in ref/library_export.md.
+const (
+	FormatName    = "mms-library-export"
+	FormatVersion = 1
+)
+
+// Document is a portable export of user-generated library data. Tracks are
+// listed once and referenced by Ref from the other sections, so a document
+// can be re-linked to a library whose track IDs differ.
+type Document struct {
+	Format     string      `json:"format"`
+	Version    int         `json:"version"`
+	ExportedAt time.Time   `json:"exported_at"`
+	Tracks     []*TrackRef `json:"tracks"`
+	Playlists  []*Playlist `json:"playlists"`
+	Plays      []*Play     `json:"play_history"`
+	Ratings    []*Rating   `json:"ratings"`
+	Settings   []*Setting  `json:"settings"`
+}
+
+// TrackRef identifies a track independently of its database ID.
+type TrackRef struct {
+	Ref             string  `json:"ref"`
+	MusicBrainzID   string  `json:"musicbrainz_id,omitempty"`
+	Artist          string  `json:"artist"`
+	Album           string  `json:"album"`
+	Title           string  `json:"title"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	TrackNumber     *int    `json:"track_number,omitempty"`
+	DiscNumber      int     `json:"disc_number"`
+	Path            string  `json:"path"`
+}
+
+// Playlist is an exported playlist with its ordered entries.
+type Playlist struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	Rules       *db.SmartRules   `json:"rules,omitempty"`  // smart playlists
+	Folder      []string         `json:"folder,omitempty"` // folder names from the top level down
+	PinnedAt    *time.Time       `json:"pinned_at,omitempty"`
+	Entries     []*PlaylistEntry `json:"entries"`
+}
+
+// PlaylistEntry is one track in an exported playlist.
+type PlaylistEntry struct {
+	Track   string    `json:"track"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// Play is one exported play event.
+type Play struct {
+	Track            string    `json:"track"`
+	PlayedAt         time.Time `json:"played_at"`
+	DurationListened *float64  `json:"duration_listened,omitempty"`
+}
+
+// Rating is one exported track rating.
+type Rating struct {
+	Track   string    `json:"track"`
+	Rating  int       `json:"rating"`
+	RatedAt time.Time `json:"rated_at"`
+}
+
+// Setting is one exported system_config entry.
+type Setting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Export collects playlists, play history, ratings and settings into a
+// document. Only tracks referenced by one of those sections are listed.
+func Export(ctx context.Context, repo db.Store) (*Document, error) {
+	tracks, err := repo.ListAllTracks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	idents, err := repo.ListTrackIdentifiers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*db.Track, len(tracks))
+	for _, t := range tracks {
+		byID[t.ID] = t
+	}
+
+	doc := &Document{
+		Format:     FormatName,
+		Version:    FormatVersion,
+		ExportedAt: time.Now().UTC(),
+		Tracks:     []*TrackRef{},
+		Playlists:  []*Playlist{},
+		Plays:      []*Play{},
+		Ratings:    []*Rating{},
+		Settings:   []*Setting{},
+	}
+
+	// ref adds a track to the document on first use. Tracks that no longer
+	// exist are skipped.
+	seen := make(map[string]bool)
+	ref := func(trackID string) (string, bool) {
+		t, ok := byID[trackID]
+		if !ok {
+			return "", false
+		}
+		if !seen[trackID] {
+			seen[trackID] = true
+			tr := &TrackRef{
+				Ref:             t.ID,
+				Artist:          t.ArtistName,
+				Album:           t.AlbumTitle,
+				Title:           t.Title,
+				DurationSeconds: t.DurationSeconds,
+				TrackNumber:     t.TrackNumber,
+				DiscNumber:      t.DiscNumber,
+				Path:            t.FilePath,
+			}
+			if id, ok := idents[t.ID]; ok {
+				tr.MusicBrainzID = id.MBRecordingID
+			}
+			doc.Tracks = append(doc.Tracks, tr)
+		}
+		return trackID, true
+	}
+
+	playlists, err := repo.ListPlaylists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	folders, err := repo.ListPlaylistFolders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, fp := range treeOrder(db.BuildPlaylistTree(folders, playlists)) {
+		p := fp.playlist
+		entries, err := repo.ListPlaylistEntries(ctx, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		ep := &Playlist{
+			Name:      p.Name,
+			CreatedAt: p.CreatedAt,
+			UpdatedAt: p.UpdatedAt,
+			Rules:     p.Rules,
+			Folder:    fp.folder,
+			PinnedAt:  p.PinnedAt,
+			Entries:   []*PlaylistEntry{},
+		}
+		if p.Description != nil {
+			ep.Description = *p.Description
+		}
+		for _, e := range entries {
+			if r, ok := ref(e.TrackID); ok {
+				ep.Entries = append(ep.Entries, &PlaylistEntry{Track: r, AddedAt: e.AddedAt})
+			}
+		}
+		doc.Playlists = append(doc.Playlists, ep)
+	}
+
+	plays, err := repo.ListPlayHistory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range plays {
+		if r, ok := ref(p.TrackID); ok {
+			doc.Plays = append(doc.Plays, &Play{Track: r, PlayedAt: p.PlayedAt, DurationListened: p.DurationListened})
+		}
+	}
+
+	ratings, err := repo.ListRatings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, rt := range ratings {
+		if r, ok := ref(rt.TrackID); ok {
+			doc.Ratings = append(doc.Ratings, &Rating{Track: r, Rating: rt.Rating, RatedAt: rt.RatedAt})
+		}
+	}
+
+	settings, err := repo.ListSystemConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range slices.Sorted(maps.Keys(settings)) {
+		doc.Settings = append(doc.Settings, &Setting{Key: k, Value: settings[k]})
+	}
+
+	return doc, nil
+}
+
+// filedPlaylist is a playlist with the names of the folders it is in.
+type filedPlaylist struct {
+	playlist *db.Playlist
+	folder   []string
+}
+
+// treeOrder lists playlists depth first, each folder's subfolders before its
+// playlists, so importing them in order rebuilds the same tree.
+func treeOrder(tree *db.PlaylistTree) []filedPlaylist {
+	var out []filedPlaylist
+	var walk func(folders []*db.PlaylistTreeFolder, playlists []*db.Playlist, path []string)
+	walk = func(folders []*db.PlaylistTreeFolder, playlists []*db.Playlist, path []string) {
+		for _, f := range folders {
+			walk(f.Folders, f.Playlists, append(slices.Clip(path), f.Name))
+		}
+		for _, p := range playlists {
+			out = append(out, filedPlaylist{playlist: p, folder: path})
+		}
+	}
+	walk(tree.Folders, tree.Playlists, nil)
+	return out
+}
@@ -0,0 +1,67 @@
+package trash
+
+import (
+	"context"
+	"time"
+
+	"github.com/marks-music-solutions/mms/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+// tick is how often the purger looks for expired playlists.
+const tick = time.Hour
+
+// Purger permanently removes playlists that have been deleted for longer
+// than the retention period.
+type Purger struct {
+	repo      db.Store
+	retention time.Duration
+}
+
+// NewPurger creates a purger for the deleted playlists in repo. A retention
+// of 0 keeps them until purged by hand.
+func NewPurger(repo db.Store, retention time.Duration) *Purger {
+	return &Purger{repo: repo, retention: retention}
+}
+
+// PurgeAt returns when a playlist deleted at deletedAt will be purged, or
+// nil if it is kept indefinitely.
+func (p *Purger) PurgeAt(deletedAt time.Time) *time.Time {
+	if p.retention <= 0 {
+		return nil
+	}
+	at := deletedAt.Add(p.retention)
+	return &at
+}
+
+// Run purges expired playlists until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	if p.retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		if err := p.PurgeExpired(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("deleted playlist purge skipped")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeExpired removes the playlists whose retention had run out by now.
+func (p *Purger) PurgeExpired(ctx context.Context, now time.Time) error {
+	n, err := p.repo.PurgeDeletedPlaylists(ctx, now.Add(-p.retention))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Info().Int("playlists", n).Msg("purged deleted playlists")
+	}
+	return nil
+}